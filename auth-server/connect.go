@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"golang.org/x/oauth2"
+	"shared/database"
+	. "shared/jwt"
+)
+
+// connectConfig is the Google client for connecting additional calendar
+// accounts. It differs from the login client only in its callback.
+var connectConfig *oauth2.Config
+
+func setupConnectConfig() {
+	cfg := *oauthConfig
+	cfg.RedirectURL = getEnv("GOOGLE_CONNECT_REDIRECT_URL", "http://localhost:3000/connect/google/callback")
+	connectConfig = &cfg
+}
+
+// handleConnectGoogle starts connecting another Google account to the
+// signed-in user. The user is kept in the signed state cookie, so the
+// callback knows whose account it is without a login of its own. An optional
+// login_hint preselects the account, as when reconnecting one whose access
+// was revoked.
+func handleConnectGoogle(w http.ResponseWriter, r *http.Request) {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:5173")
+
+	cookie, err := r.Cookie("JWT")
+	if err != nil {
+		http.Redirect(w, r, "/login?return_to="+url.QueryEscape("/home"), http.StatusFound)
+		return
+	}
+	email, err := ParseJWT(cookie.Value)
+	if err != nil {
+		http.Redirect(w, r, "/login?return_to="+url.QueryEscape("/home"), http.StatusFound)
+		return
+	}
+	userID, err := getUserID(email)
+	if err != nil {
+		log.Printf("Failed to load user for connect: %v", err)
+		http.Redirect(w, r, frontendURL+"/home?error="+url.QueryEscape("Failed to connect account"), http.StatusSeeOther)
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		log.Printf("Failed to generate state: %v", err)
+		http.Error(w, "Failed to start connect flow", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = setStateCookie(w, loginState{
+		State:    state,
+		Verifier: verifier,
+		UserID:   userID,
+		Expires:  time.Now().Add(stateTTL).Unix(),
+	}, connectConfig.RedirectURL)
+	if err != nil {
+		log.Printf("Failed to set state cookie: %v", err)
+		http.Error(w, "Failed to start connect flow", http.StatusInternalServerError)
+		return
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("prompt", "consent select_account"),
+	}
+	if hint := r.URL.Query().Get("login_hint"); hint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", hint))
+	}
+	authURL := connectConfig.AuthCodeURL(state, opts...)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleConnectGoogleCallback stores the Google account as a connected
+// account of the user from the state. It never creates a user.
+func handleConnectGoogleCallback(w http.ResponseWriter, r *http.Request) {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:5173")
+	fail := func(message string) {
+		http.Redirect(w, r, frontendURL+"/home?error="+url.QueryEscape(message), http.StatusSeeOther)
+	}
+	query := r.URL.Query()
+
+	st, err := readStateCookie(r, query.Get("state"))
+	clearStateCookie(w, connectConfig.RedirectURL)
+	if err != nil || st.UserID == "" {
+		log.Printf("Rejected connect callback: %v", err)
+		fail("Invalid or expired connect attempt, please try again")
+		return
+	}
+
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		log.Printf("Google connect was not completed: %s", oauthErr)
+		fail("Google connection was cancelled")
+		return
+	}
+
+	ctx := context.Background()
+	token, err := connectConfig.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		log.Printf("Connect token exchange failed: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	resp, err := connectConfig.Client(ctx, token).Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	if err != nil {
+		log.Printf("Failed getting user info: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+	defer resp.Body.Close()
+
+	var userInfo struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil || userInfo.ID == "" {
+		log.Printf("Failed decoding user info: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	if err := saveConnectedAccount(st.UserID, providerGoogle, userInfo.ID, userInfo.Email, token, true); err != nil {
+		log.Printf("Failed to save connected account: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	log.Printf("Connected Google account %s to user %s", userInfo.Email, st.UserID)
+	http.Redirect(w, r, frontendURL+"/home?connected="+url.QueryEscape(userInfo.Email), http.StatusSeeOther)
+}
+
+// saveConnectedAccount creates or updates the user's connected account for
+// the provider account subject, keeping the stored refresh token when there
+// is no new one. Accounts connected by logging in as them, and login
+// accounts migrated before their subject was known, are keyed by a user ID
+// instead; they're found by email and rekeyed. Unless create is set, a
+// missing account is left missing. A new refresh token means the user
+// consented again, which restores an account whose access was revoked.
+func saveConnectedAccount(userID, provider, subject, email string, token *oauth2.Token, create bool) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	var storedRefresh, storedKeyID sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, refresh_token, token_key_id FROM connected_accounts
+		WHERE user_id = ? AND provider = ?
+		  AND (provider_account_id = ? OR (email = ? AND provider_account_id IN (SELECT id FROM users)))
+		ORDER BY provider_account_id = ? DESC
+		LIMIT 1
+	`, userID, provider, subject, email, subject).Scan(&id, &storedRefresh, &storedKeyID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get connected account: %w", err)
+	}
+	if id == "" && !create {
+		return nil
+	}
+
+	access, refresh := token.AccessToken, token.RefreshToken
+	regranted := refresh != ""
+	if refresh == "" && id != "" {
+		refresh = storedRefresh.String
+		if err := database.OpenTokens(storedKeyID, &refresh); err != nil {
+			return fmt.Errorf("failed to decrypt tokens: %w", err)
+		}
+	}
+	keyID, err := database.SealTokens(&access, &refresh)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
+	var refreshVal any
+	if refresh != "" {
+		refreshVal = refresh
+	}
+
+	now := time.Now().Unix()
+	if id != "" {
+		_, err = tx.Exec(`
+			UPDATE connected_accounts
+			SET provider_account_id = ?, email = ?, access_token = ?, refresh_token = ?, token_key_id = ?,
+			    token_expiry = ?, updated_at = ?,
+			    needs_reauth = needs_reauth AND NOT ?,
+			    reauth_reason = CASE WHEN ? THEN NULL ELSE reauth_reason END
+			WHERE id = ?
+		`, subject, email, access, refreshVal, keyID, tokenExpiry(token), now, regranted, regranted, id)
+	} else {
+		if id, err = gonanoid.New(); err != nil {
+			return fmt.Errorf("failed to generate id: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO connected_accounts
+			(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry,
+			 token_key_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, id, userID, provider, subject, email, access, refreshVal, tokenExpiry(token), keyID, now, now)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save connected account: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"shared/database"
+)
+
+const (
+	providerGoogle    = "google"
+	providerMicrosoft = "microsoft"
+)
+
+// identity is an account at a login provider. It's named by the provider's
+// subject ID rather than the email, which can change or be given to someone
+// else.
+type identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// identityUser returns the user the identity signs in as. An identity seen
+// before signs in as the user it was linked to. A new one is linked to
+// linkTo, the user signed in while it was used, if given, or else to the user
+// with the same email when the provider verified it, and linked reports
+// that. It returns "" when the identity belongs to no user yet.
+func identityUser(id identity, linkTo string) (userID string, linked bool, err error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	err = db.QueryRow(
+		"SELECT user_id FROM identities WHERE provider = ? AND subject = ?",
+		id.Provider, id.Subject,
+	).Scan(&userID)
+	switch {
+	case err == nil:
+		_, err = db.Exec(
+			"UPDATE identities SET email = ?, last_login_at = ? WHERE provider = ? AND subject = ?",
+			id.Email, time.Now().Unix(), id.Provider, id.Subject,
+		)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to update identity: %w", err)
+		}
+		return userID, false, nil
+	case err != sql.ErrNoRows:
+		return "", false, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	if linkTo == "" && id.EmailVerified {
+		err := db.QueryRow("SELECT id FROM users WHERE email = ?", id.Email).Scan(&linkTo)
+		if err != nil && err != sql.ErrNoRows {
+			return "", false, fmt.Errorf("failed to get user: %w", err)
+		}
+	}
+	if linkTo == "" {
+		return "", false, nil
+	}
+
+	if err := linkIdentity(db, linkTo, id); err != nil {
+		return "", false, err
+	}
+	return linkTo, true, nil
+}
+
+func linkIdentity(exec interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, userID string, id identity) error {
+	identityID, err := gonanoid.New()
+	if err != nil {
+		return fmt.Errorf("failed to generate id: %w", err)
+	}
+
+	now := time.Now().Unix()
+	_, err = exec.Exec(`
+		INSERT INTO identities (id, user_id, provider, subject, email, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, identityID, userID, id.Provider, id.Subject, id.Email, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
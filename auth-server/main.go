@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -55,89 +57,187 @@ func SetupOAuthConfig() {
 	}
 }
 
-func userExists(email string) bool {
+// addUser creates a user for a new identity, linked to it. token is the
+// Google token kept on the user; other providers have none.
+func addUser(id identity, token *oauth2.Token) (string, error) {
 	db, err := database.GetDB()
 	if err != nil {
-		log.Printf("Failed to get database: %v", err)
-		return false
+		return "", fmt.Errorf("failed to get database: %w", err)
 	}
 
-	var token string
-	err = db.QueryRow("SELECT token FROM users WHERE email = ?", email).Scan(&token)
+	tx, err := db.Begin()
 	if err != nil {
-		return false
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	return true
-}
 
-func addUser(email, token, rtoken string) error {
-	db, err := database.GetDB()
+	userID, err := gonanoid.New()
 	if err != nil {
-		return fmt.Errorf("failed to get database: %w", err)
+		tx.Rollback()
+		return "", fmt.Errorf("failed to generate id: %w", err)
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+	var access, refresh string
+	var expiry any
+	if token != nil {
+		access, refresh, expiry = token.AccessToken, token.RefreshToken, tokenExpiry(token)
 	}
-
-	id, err := gonanoid.New()
+	keyID, err := database.SealTokens(&access, &refresh)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to generate id: %w", err)
+		return "", fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
+	isOutlook := 0
+	if id.Provider == providerMicrosoft {
+		isOutlook = 1
 	}
 
 	_, err = tx.Exec(
-		"INSERT INTO users(id, email, created_at, token, refresh_token, is_outlook) VALUES(?, ?, ?, ?, ?, ?)",
-		id, email, time.Now().Unix(), token, rtoken, 0,
+		"INSERT INTO users(id, email, created_at, token, refresh_token, token_key_id, token_expiry, is_outlook) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, id.Email, time.Now().Unix(), access, refresh, keyID, expiry, isOutlook,
 	)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to insert user: %w", err)
+		return "", fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	if err := linkIdentity(tx, userID, id); err != nil {
+		tx.Rollback()
+		return "", err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit: %w", err)
+		return "", fmt.Errorf("failed to commit: %w", err)
 	}
 
-	log.Printf("Added user: %s", email)
-	return nil
+	log.Printf("Added user: %s", id.Email)
+	return userID, nil
+}
+
+// tokenExpiry is the token's expiry as stored in token_expiry, or nil when
+// the provider didn't say.
+func tokenExpiry(token *oauth2.Token) any {
+	if token.Expiry.IsZero() {
+		return nil
+	}
+	return token.Expiry.Unix()
 }
 
-func updateUserTokens(email, token, rtoken string) error {
+func getUserID(email string) (string, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var id string
+	if err := db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id); err != nil {
+		return "", fmt.Errorf("failed to get user id: %w", err)
+	}
+	return id, nil
+}
+
+func updateUserTokens(userID string, token *oauth2.Token) error {
 	db, err := database.GetDB()
 	if err != nil {
 		return fmt.Errorf("failed to get database: %w", err)
 	}
 
+	access, refresh := token.AccessToken, token.RefreshToken
+	if refresh == "" {
+		// Google only returns a refresh token on consent; keep the one we
+		// have. It's sealed again below, possibly under a newer key.
+		var stored sql.NullString
+		var storedKeyID sql.NullString
+		err := db.QueryRow("SELECT refresh_token, token_key_id FROM users WHERE id = ?", userID).Scan(&stored, &storedKeyID)
+		if err != nil {
+			return fmt.Errorf("failed to get user tokens: %w", err)
+		}
+		refresh = stored.String
+		if err := database.OpenTokens(storedKeyID, &refresh); err != nil {
+			return fmt.Errorf("failed to decrypt tokens: %w", err)
+		}
+	}
+
+	keyID, err := database.SealTokens(&access, &refresh)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
 	_, err = db.Exec(
-		"UPDATE users SET token = ?, refresh_token = ? WHERE email = ?",
-		token, rtoken, email,
+		"UPDATE users SET token = ?, refresh_token = ?, token_key_id = ?, token_expiry = ? WHERE id = ?",
+		access, refresh, keyID, tokenExpiry(token), userID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update user tokens: %w", err)
 	}
 
-	log.Printf("Updated tokens for user: %s", email)
+	log.Printf("Updated tokens for user: %s", userID)
 	return nil
 }
 
 func main() {
 	SetupOAuthConfig()
+	setupConnectConfig()
+	setupStateKey()
+	setupTrustedProxies()
+
+	if err := Setup(); err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	revocations = NewSQLRevocationStore(db)
+	SetRevocationStore(revocations)
 
 	http.HandleFunc("/login", handleLogin)
 	http.HandleFunc("/auth", handleAuth)
 	http.HandleFunc("/auth/callback", handleCallback)
+	http.HandleFunc("/connect/google", handleConnectGoogle)
+	http.HandleFunc("/connect/google/callback", handleConnectGoogleCallback)
+	if setupMicrosoftConfig() {
+		http.HandleFunc("/auth/microsoft", handleMicrosoftAuth)
+		http.HandleFunc("/auth/microsoft/callback", handleMicrosoftCallback)
+	} else {
+		log.Printf("Microsoft sign-in disabled - missing MICROSOFT_CLIENT_ID or MICROSOFT_CLIENT_SECRET")
+	}
+	http.HandleFunc("/auth/refresh", handleRefresh)
+	http.HandleFunc("/auth/logout", handleLogout)
+	http.HandleFunc("GET /auth/sessions", handleGetSessions)
+	http.HandleFunc("DELETE /auth/sessions/{id}", handleRevokeSession)
+	http.HandleFunc("OPTIONS /auth/sessions/", handlePreflight)
+	http.HandleFunc("GET /.well-known/jwks.json", handleJWKS)
 
 	port := getEnv("AUTH_SERVER_PORT", "3000")
 	fmt.Printf("Auth server started at :%s\n", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
+// handleJWKS publishes the public keys tokens are signed with, so other
+// services can verify them without sharing a secret.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, err := JWKS()
+	if err != nil {
+		log.Printf("Failed to build JWKS: %v", err)
+		http.Error(w, "Failed to load keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(jwks)
+}
+
 func handleLogin(w http.ResponseWriter, r *http.Request) {
+	authURL := "/auth"
+	if returnPath := safeReturnPath(r.URL.Query().Get("return_to")); returnPath != "" {
+		authURL += "?return_to=" + url.QueryEscape(returnPath)
+	}
+
 	jwt, err := r.Cookie("JWT")
 	if err == http.ErrNoCookie {
-		http.Redirect(w, r, "/auth", http.StatusFound)
+		http.Redirect(w, r, authURL, http.StatusFound)
 		return
 	}
 
@@ -147,23 +247,61 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, "/auth", http.StatusFound)
+	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
+// handleAuth starts a login. The state and PKCE verifier are kept in a
+// signed cookie for the callback, together with the page to return to.
 func handleAuth(w http.ResponseWriter, r *http.Request) {
-	url := oauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-	http.Redirect(w, r, url, http.StatusFound)
+	state, err := randomToken()
+	if err != nil {
+		log.Printf("Failed to generate state: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = setStateCookie(w, loginState{
+		State:      state,
+		Verifier:   verifier,
+		ReturnPath: safeReturnPath(r.URL.Query().Get("return_to")),
+		Expires:    time.Now().Add(stateTTL).Unix(),
+	}, oauthConfig.RedirectURL)
+	if err != nil {
+		log.Printf("Failed to set state cookie: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
 func handleCallback(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
-	code := r.URL.Query().Get("code")
+	query := r.URL.Query()
+
+	st, err := readStateCookie(r, query.Get("state"))
+	clearStateCookie(w, oauthConfig.RedirectURL)
+	if err != nil {
+		log.Printf("Rejected login callback: %v", err)
+		http.Error(w, "Invalid or expired login attempt, please sign in again", http.StatusBadRequest)
+		return
+	}
+
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		log.Printf("Login was not completed: %s", oauthErr)
+		http.Error(w, "Login was not completed", http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
 	if code == "" {
 		http.Error(w, "Missing code", http.StatusBadRequest)
 		return
 	}
 
-	token, err := oauthConfig.Exchange(ctx, code)
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(st.Verifier))
 	if err != nil {
 		log.Printf("Token exchange failed: %v", err)
 		http.Error(w, "Token exchange failed", http.StatusInternalServerError)
@@ -180,7 +318,9 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 	defer resp.Body.Close()
 
 	var userInfo struct {
-		Email string `json:"email"`
+		ID            string `json:"id"`
+		Email         string `json:"email"`
+		VerifiedEmail bool   `json:"verified_email"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
 		log.Printf("Failed decoding user info: %v", err)
@@ -188,27 +328,79 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !userExists(userInfo.Email) {
-		log.Printf("New user: %s", userInfo.Email)
-		if err := addUser(userInfo.Email, token.AccessToken, token.RefreshToken); err != nil {
+	id := identity{
+		Provider:      providerGoogle,
+		Subject:       userInfo.ID,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.VerifiedEmail,
+	}
+	userID, linked, err := identityUser(id, "")
+	if err != nil {
+		log.Printf("Failed to look up identity: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	firstLogin := userID == "" || linked
+	if userID == "" {
+		if !id.EmailVerified {
+			log.Printf("Refused login with unverified email: %s", id.Email)
+			http.Error(w, "Your Google account's email address is not verified", http.StatusForbidden)
+			return
+		}
+		log.Printf("New user: %s", id.Email)
+		if userID, err = addUser(id, token); err != nil {
 			log.Printf("Failed to add user: %v", err)
 			http.Error(w, "Failed to save user", http.StatusInternalServerError)
 			return
 		}
 	} else {
-		log.Printf("Existing user: %s", userInfo.Email)
-		if err := updateUserTokens(userInfo.Email, token.AccessToken, token.RefreshToken); err != nil {
+		log.Printf("Existing user: %s", id.Email)
+		if err := updateUserTokens(userID, token); err != nil {
 			log.Printf("Failed to update user tokens: %v", err)
 		}
 	}
 
-	jwtToken, err := GenerateJWT(userInfo.Email)
+	// The login account's calendars are used through a connected account
+	// like any other. It's created on the account's first login; later
+	// logins only refresh its tokens, so removing it sticks.
+	if err := saveConnectedAccount(userID, providerGoogle, id.Subject, id.Email, token, firstLogin); err != nil {
+		log.Printf("Failed to save login account: %v", err)
+	}
+
+	email, err := getUserEmail(userID)
+	if err != nil {
+		log.Printf("Failed to load user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	completeLogin(w, r, st, userID, email)
+}
+
+// completeLogin starts a session for the user and sets its cookies, then
+// sends the browser on to the page the login started from. The cookies are
+// only ever set on the response to the provider's callback, which the state
+// cookie ties to the browser that began the login.
+func completeLogin(w http.ResponseWriter, r *http.Request, st *loginState, userID, email string) {
+	sessionID, refreshToken, err := createSession(userID, r)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	jwtToken, err := GenerateSessionJWT(email, sessionID)
 	if err != nil {
 		log.Printf("Failed to generate JWT: %v", err)
 		http.Error(w, "Failed to generate JWT", http.StatusInternalServerError)
 		return
 	}
+	setSessionCookies(w, jwtToken, refreshToken)
 
-	backendURL := getEnv("BACKEND_URL", "http://localhost:8080")
-	http.Redirect(w, r, backendURL+"/auth/callback?token="+jwtToken, http.StatusSeeOther)
+	next := "/home"
+	if st.ReturnPath != "" {
+		next = st.ReturnPath
+	}
+	http.Redirect(w, r, getEnv("FRONTEND_URL", "http://localhost:5173")+next, http.StatusSeeOther)
 }
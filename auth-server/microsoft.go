@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	. "shared/jwt"
+)
+
+// consumerTenantID is the tenant of personal Microsoft accounts. Microsoft
+// verifies their email addresses; for work accounts it's up to the tenant.
+const consumerTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+var (
+	microsoftConfig    *oauth2.Config
+	microsoftKeys      *KeySet
+	microsoftAuthority string
+	microsoftTenant    string
+)
+
+// setupMicrosoftConfig enables signing in with Microsoft when a client ID
+// and secret are configured. The Outlook app registration can be reused, with
+// the login callback added as a redirect URI.
+func setupMicrosoftConfig() bool {
+	clientID := getEnv("MICROSOFT_CLIENT_ID", os.Getenv("OUTLOOK_CLIENT_ID"))
+	clientSecret := getEnv("MICROSOFT_CLIENT_SECRET", os.Getenv("OUTLOOK_CLIENT_SECRET"))
+	if clientID == "" || clientSecret == "" {
+		return false
+	}
+
+	microsoftAuthority = strings.TrimRight(getEnv("MICROSOFT_AUTHORITY", "https://login.microsoftonline.com"), "/")
+	microsoftTenant = getEnv("MICROSOFT_TENANT", "common")
+	base := microsoftAuthority + "/" + microsoftTenant
+
+	microsoftConfig = &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  getEnv("MICROSOFT_REDIRECT_URL", "http://localhost:3000/auth/microsoft/callback"),
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  base + "/oauth2/v2.0/authorize",
+			TokenURL: base + "/oauth2/v2.0/token",
+		},
+	}
+	microsoftKeys = NewKeySet(base + "/discovery/v2.0/keys")
+	return true
+}
+
+// handleMicrosoftAuth starts a login with a Microsoft account, like
+// handleAuth does for Google, with a nonce for the ID token.
+func handleMicrosoftAuth(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		log.Printf("Failed to generate state: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		log.Printf("Failed to generate nonce: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = setStateCookie(w, loginState{
+		State:      state,
+		Verifier:   verifier,
+		ReturnPath: safeReturnPath(r.URL.Query().Get("return_to")),
+		Nonce:      nonce,
+		Expires:    time.Now().Add(stateTTL).Unix(),
+	}, microsoftConfig.RedirectURL)
+	if err != nil {
+		log.Printf("Failed to set state cookie: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL := microsoftConfig.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("prompt", "select_account"))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleMicrosoftCallback signs in with the identity from the ID token.
+// Signing in with Microsoft while signed in links the account to the
+// signed-in user, so either login lands on the same account afterwards.
+func handleMicrosoftCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	st, err := readStateCookie(r, query.Get("state"))
+	clearStateCookie(w, microsoftConfig.RedirectURL)
+	if err != nil {
+		log.Printf("Rejected Microsoft login callback: %v", err)
+		http.Error(w, "Invalid or expired login attempt, please sign in again", http.StatusBadRequest)
+		return
+	}
+
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		log.Printf("Microsoft login was not completed: %s: %s", oauthErr, query.Get("error_description"))
+		http.Error(w, "Login was not completed", http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Missing code", http.StatusBadRequest)
+		return
+	}
+
+	token, err := microsoftConfig.Exchange(r.Context(), code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		log.Printf("Microsoft token exchange failed: %v", err)
+		http.Error(w, "Token exchange failed", http.StatusInternalServerError)
+		return
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	id, err := verifyMicrosoftIDToken(rawIDToken, st.Nonce)
+	if err != nil {
+		log.Printf("Rejected Microsoft ID token: %v", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	var linkTo string
+	if existing, err := r.Cookie("JWT"); err == nil {
+		if email, err := ParseJWT(existing.Value); err == nil {
+			if linkTo, err = getUserID(email); err != nil {
+				log.Printf("Failed to load signed-in user: %v", err)
+			}
+		}
+	}
+
+	userID, _, err := identityUser(id, linkTo)
+	if err != nil {
+		log.Printf("Failed to look up identity: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	if userID == "" {
+		if !id.EmailVerified {
+			log.Printf("Refused Microsoft login with unverified email: %s", id.Email)
+			http.Error(w, "Microsoft did not verify this account's email address. Sign in with Google first, then sign in with Microsoft to link the accounts.", http.StatusForbidden)
+			return
+		}
+		log.Printf("New user: %s", id.Email)
+		if userID, err = addUser(id, nil); err != nil {
+			log.Printf("Failed to add user: %v", err)
+			http.Error(w, "Failed to save user", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		log.Printf("Existing user signed in with Microsoft: %s", id.Email)
+	}
+
+	email, err := getUserEmail(userID)
+	if err != nil {
+		log.Printf("Failed to load user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	completeLogin(w, r, st, userID, email)
+}
+
+// verifyMicrosoftIDToken checks the ID token's signature, audience and
+// expiry, that it was issued by the tenant it names and allowed by
+// MICROSOFT_TENANT, and that it carries the login's nonce.
+func verifyMicrosoftIDToken(rawIDToken, nonce string) (identity, error) {
+	if rawIDToken == "" {
+		return identity{}, errors.New("token response has no ID token")
+	}
+
+	claims, err := microsoftKeys.Verify(rawIDToken, microsoftConfig.ClientID)
+	if err != nil {
+		return identity{}, err
+	}
+
+	tid, _ := claims["tid"].(string)
+	iss, _ := claims["iss"].(string)
+	if tid == "" || iss != microsoftAuthority+"/"+tid+"/v2.0" {
+		return identity{}, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	switch microsoftTenant {
+	case "common":
+	case "organizations":
+		if tid == consumerTenantID {
+			return identity{}, errors.New("personal accounts are not allowed")
+		}
+	case "consumers":
+		if tid != consumerTenantID {
+			return identity{}, errors.New("only personal accounts are allowed")
+		}
+	default:
+		if tid != microsoftTenant {
+			return identity{}, fmt.Errorf("tenant %s is not allowed", tid)
+		}
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return identity{}, errors.New("nonce mismatch")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return identity{}, errors.New("ID token has no subject")
+	}
+
+	id := identity{Provider: providerMicrosoft, Subject: sub}
+	id.Email, _ = claims["email"].(string)
+	if id.Email != "" {
+		edov, _ := claims["xms_edov"].(bool)
+		id.EmailVerified = tid == consumerTenantID || edov
+	} else {
+		// Only a sign-in name, which the tenant may not have verified.
+		id.Email, _ = claims["preferred_username"].(string)
+	}
+	id.Email = strings.ToLower(id.Email)
+	return id, nil
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"shared/database"
+	. "shared/jwt"
+)
+
+const (
+	refreshCookie = "refresh_token"
+	// refreshCookiePath covers /auth/refresh, the only place the refresh
+	// token is sent to.
+	refreshCookiePath = "/auth"
+	// refreshTokenTTL is how long a refresh token is valid. Every refresh
+	// hands out a new one, so sessions in use don't run out.
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// refreshReuseGrace is how long a rotated refresh token is still
+	// accepted. Tabs refreshing at the same time, or a response lost on the
+	// way, present the old token again just after it was exchanged.
+	refreshReuseGrace = 10 * time.Second
+)
+
+// errRefreshTokenReused is returned when a refresh token that was already
+// exchanged is presented again. After refreshReuseGrace that means it was
+// copied.
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// session is one refresh token. The tokens handed out by refreshing the
+// token of one login form a family; only the newest is not rotated.
+type session struct {
+	ID         string
+	FamilyID   string
+	UserID     string
+	ExpiresAt  int64
+	RotatedAt  sql.NullInt64
+	RevokedAt  sql.NullInt64
+	ReplacedBy sql.NullString
+}
+
+// Only the hash of a refresh token is stored. The tokens are random, so a
+// plain SHA-256 is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func insertSession(exec interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, userID, familyID string, r *http.Request) (string, string, error) {
+	id, err := gonanoid.New()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	_, err = exec.Exec(`
+		INSERT INTO sessions (id, family_id, user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, familyID, userID, hashToken(token), r.UserAgent(), clientIP(r),
+		now.Unix(), now.Unix(), now.Add(refreshTokenTTL).Unix())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to insert session: %w", err)
+	}
+	return id, token, nil
+}
+
+// createSession starts a session family for a login and returns its ID and
+// first refresh token.
+func createSession(userID string, r *http.Request) (string, string, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	familyID, err := gonanoid.New()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	_, token, err := insertSession(db, userID, familyID, r)
+	if err != nil {
+		return "", "", err
+	}
+	return familyID, token, nil
+}
+
+func getSessionByToken(token string) (*session, error) {
+	return querySession("token_hash = ?", hashToken(token))
+}
+
+func getSession(id string) (*session, error) {
+	return querySession("id = ?", id)
+}
+
+func querySession(where string, arg any) (*session, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var s session
+	err = db.QueryRow(`
+		SELECT id, family_id, user_id, expires_at, rotated_at, revoked_at, replaced_by
+		FROM sessions
+		WHERE `+where, arg).Scan(&s.ID, &s.FamilyID, &s.UserID, &s.ExpiresAt, &s.RotatedAt, &s.RevokedAt, &s.ReplacedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return &s, nil
+}
+
+// rotateSession exchanges the session's refresh token for a new one in the
+// same family. Of two requests racing with the same token only one wins;
+// the other gets errRefreshTokenReused.
+func rotateSession(s *session, r *http.Request) (string, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	childID, token, err := insertSession(tx, s.UserID, s.FamilyID, r)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		UPDATE sessions SET rotated_at = ?, last_used_at = ?, replaced_by = ?
+		WHERE id = ? AND rotated_at IS NULL AND revoked_at IS NULL
+	`, now, now, childID, s.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return "", errRefreshTokenReused
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit: %w", err)
+	}
+	return token, nil
+}
+
+// graceSuccessor returns the session that replaced the one with the given
+// ID if that happened less than refreshReuseGrace ago, and nil otherwise.
+func graceSuccessor(id string) (*session, error) {
+	s, err := getSession(id)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	if !s.RotatedAt.Valid || !s.ReplacedBy.Valid ||
+		time.Since(time.Unix(s.RotatedAt.Int64, 0)) > refreshReuseGrace {
+		return nil, nil
+	}
+
+	child, err := getSession(s.ReplacedBy.String)
+	if err != nil || child == nil {
+		return nil, err
+	}
+	if child.RevokedAt.Valid || child.ExpiresAt < time.Now().Unix() {
+		return nil, nil
+	}
+	return child, nil
+}
+
+// revokeSessionFamily ends every session descended from the same login.
+func revokeSessionFamily(familyID string) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		time.Now().Unix(), familyID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+func getUserEmail(userID string) (string, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	return email, nil
+}
+
+// trustedProxies are the addresses of the reverse proxies in front of the
+// auth server, from TRUSTED_PROXIES.
+var trustedProxies []netip.Prefix
+
+// setupTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of
+// addresses and CIDR ranges. X-Forwarded-For is only believed for requests
+// coming from one of them.
+func setupTrustedProxies() {
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(entry)
+			if aerr != nil {
+				log.Fatalf("Invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+}
+
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address the request came from. Behind trusted
+// proxies that is the right-most X-Forwarded-For hop that isn't one of them;
+// hops further left were added by the client and can say anything.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func secureCookies() bool {
+	return strings.HasPrefix(oauthConfig.RedirectURL, "https://")
+}
+
+// setSessionCookies hands the browser a new access token and the refresh
+// token to renew it with.
+func setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) {
+	setAccessCookie(w, accessToken)
+	setRefreshCookie(w, refreshToken)
+}
+
+func setAccessCookie(w http.ResponseWriter, accessToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "JWT",
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func setRefreshCookie(w http.ResponseWriter, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		MaxAge:   int(refreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, c := range []struct{ name, path string }{{"JWT", "/"}, {refreshCookie, refreshCookiePath}} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Value:    "",
+			Path:     c.path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// allowCORS lets the frontend call the session endpoints with its cookies
+// when it is served from another origin. It reports whether the request was
+// a preflight that has been answered.
+func allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	for _, allowed := range strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:5173"), ",") {
+		if origin != "" && strings.TrimSpace(allowed) == origin {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Add("Vary", "Origin")
+			break
+		}
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleRefresh exchanges the refresh token cookie for a new access token
+// and a new refresh token. A refresh token exchanged less than
+// refreshReuseGrace ago gets an access token for the session that replaced
+// it and keeps the cookie the winning response set. Exchanged longer ago, it
+// revokes its whole family, since either the client or whoever copied the
+// token is now using a later one.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unauthorized := func(reason string) {
+		clearSessionCookies(w)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": reason})
+	}
+
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil || cookie.Value == "" {
+		unauthorized("missing refresh token")
+		return
+	}
+
+	s, err := getSessionByToken(cookie.Value)
+	if err != nil {
+		log.Printf("Failed to look up session: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to refresh session"})
+		return
+	}
+	if s == nil || s.RevokedAt.Valid || s.ExpiresAt < time.Now().Unix() {
+		unauthorized("session expired")
+		return
+	}
+
+	newRefresh, err := "", errRefreshTokenReused
+	if !s.RotatedAt.Valid {
+		newRefresh, err = rotateSession(s, r)
+	}
+	if err == errRefreshTokenReused {
+		child, gerr := graceSuccessor(s.ID)
+		if gerr != nil {
+			log.Printf("Failed to look up successor of session %s: %v", s.ID, gerr)
+		}
+		if child == nil {
+			log.Printf("Refresh token of session family %s reused, revoking the family", s.FamilyID)
+			if err := revokeSessionFamily(s.FamilyID); err != nil {
+				log.Printf("Failed to revoke session family %s: %v", s.FamilyID, err)
+			}
+			unauthorized("session revoked")
+			return
+		}
+		s, err = child, nil
+	}
+	if err != nil {
+		log.Printf("Failed to rotate session %s: %v", s.ID, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to refresh session"})
+		return
+	}
+
+	email, err := getUserEmail(s.UserID)
+	if err != nil {
+		log.Printf("Failed to load user of session %s: %v", s.ID, err)
+		unauthorized("session expired")
+		return
+	}
+
+	accessToken, err := GenerateSessionJWT(email, s.FamilyID)
+	if err != nil {
+		log.Printf("Failed to generate JWT: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to refresh session"})
+		return
+	}
+
+	if newRefresh != "" {
+		setSessionCookies(w, accessToken, newRefresh)
+	} else {
+		setAccessCookie(w, accessToken)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"expires_at": time.Now().Add(AccessTokenTTL).Unix(),
+	})
+}
+
+// revocations records logged out access tokens.
+var revocations *SQLRevocationStore
+
+// activeSession is a login as the user sees it: the family of refresh
+// tokens, described by the device it was last used from.
+type activeSession struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+// getActiveSessions returns the user's sessions that are neither revoked
+// nor expired, most recently used first.
+func getActiveSessions(userID string) ([]activeSession, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT s.family_id, COALESCE(s.user_agent, ''), COALESCE(s.ip, ''),
+		       (SELECT MIN(created_at) FROM sessions WHERE family_id = s.family_id),
+		       s.last_used_at, s.expires_at
+		FROM sessions s
+		WHERE s.user_id = ? AND s.rotated_at IS NULL AND s.revoked_at IS NULL AND s.expires_at > ?
+		ORDER BY s.last_used_at DESC
+	`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []activeSession{}
+	for rows.Next() {
+		var s activeSession
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// sessionBelongsTo reports whether the session family is the user's.
+func sessionBelongsTo(familyID, userID string) (bool, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var exists bool
+	err = db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM sessions WHERE family_id = ? AND user_id = ?)",
+		familyID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to get session: %w", err)
+	}
+	return exists, nil
+}
+
+// authenticate returns the claims of the request's access token and the ID
+// of its user, or writes a 401.
+func authenticate(w http.ResponseWriter, r *http.Request) (*Claims, string, bool) {
+	cookie, err := r.Cookie("JWT")
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return nil, "", false
+	}
+	claims, err := ParseClaims(cookie.Value)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return nil, "", false
+	}
+	userID, err := getUserID(claims.Subject)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "user not found"})
+		return nil, "", false
+	}
+	return claims, userID, true
+}
+
+func handlePreflight(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+}
+
+// handleLogout ends the browser's session: its refresh tokens and current
+// access token stop working and the cookies are cleared. It succeeds even
+// when the session is already gone.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var familyID string
+	if cookie, err := r.Cookie(refreshCookie); err == nil && cookie.Value != "" {
+		s, err := getSessionByToken(cookie.Value)
+		if err != nil {
+			log.Printf("Failed to look up session: %v", err)
+		} else if s != nil {
+			familyID = s.FamilyID
+		}
+	}
+
+	if cookie, err := r.Cookie("JWT"); err == nil {
+		if claims, err := ParseClaims(cookie.Value); err == nil {
+			if familyID == "" {
+				familyID = claims.SessionID
+			}
+			if claims.ID != "" {
+				if err := revocations.Revoke(claims.ID, claims.ExpiresAt); err != nil {
+					log.Printf("Failed to revoke access token: %v", err)
+				}
+			}
+		}
+	}
+
+	if familyID != "" {
+		if err := revokeSessionFamily(familyID); err != nil {
+			log.Printf("Failed to revoke session family %s: %v", familyID, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	clearSessionCookies(w)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+func handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	claims, userID, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := getActiveSessions(userID)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to list sessions"})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+// handleRevokeSession logs one of the user's sessions out. Revoking the
+// current session also clears the cookies, like logging out.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	allowCORS(w, r)
+	claims, userID, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	familyID := r.PathValue("id")
+	owned, err := sessionBelongsTo(familyID, userID)
+	if err != nil {
+		log.Printf("Failed to look up session %s: %v", familyID, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+		return
+	}
+	if !owned {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Session not found"})
+		return
+	}
+
+	if err := revokeSessionFamily(familyID); err != nil {
+		log.Printf("Failed to revoke session family %s: %v", familyID, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+		return
+	}
+	if familyID == claims.SessionID {
+		clearSessionCookies(w)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
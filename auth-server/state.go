@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	stateCookie = "oauth_state"
+	stateTTL    = 10 * time.Minute
+	// maxReturnPath bounds the return path kept in the state cookie.
+	maxReturnPath = 512
+)
+
+var stateKey []byte
+
+// loginState is what the state cookie remembers of a login between
+// redirecting to the provider and its callback. OpenID logins also keep the
+// nonce the ID token must carry, and connect flows the user connecting.
+type loginState struct {
+	State      string `json:"s"`
+	Verifier   string `json:"v"`
+	ReturnPath string `json:"r,omitempty"`
+	Nonce      string `json:"n,omitempty"`
+	UserID     string `json:"u,omitempty"`
+	Expires    int64  `json:"e"`
+}
+
+// setupStateKey loads the key state cookies are signed with. Without
+// OAUTH_STATE_SECRET a random key is used, so logins in progress fail after
+// a restart and every instance needs the same secret behind a load balancer.
+func setupStateKey() {
+	if secret := os.Getenv("OAUTH_STATE_SECRET"); secret != "" {
+		stateKey = []byte(secret)
+		return
+	}
+	stateKey = make([]byte, 32)
+	if _, err := rand.Read(stateKey); err != nil {
+		log.Fatalf("Failed to generate state key: %v", err)
+	}
+	log.Printf("OAUTH_STATE_SECRET not set, using a random key for this process")
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func signState(payload string) string {
+	mac := hmac.New(sha256.New, stateKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setStateCookie stores the login state in a signed cookie scoped to the
+// callback at redirectURL. SameSite=Lax still sends it on the provider's
+// top-level redirect back.
+func setStateCookie(w http.ResponseWriter, st loginState, redirectURL string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    payload + "." + signState(payload),
+		Path:     callbackPath(redirectURL),
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearStateCookie(w http.ResponseWriter, redirectURL string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    "",
+		Path:     callbackPath(redirectURL),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readStateCookie returns the login state if the cookie is intact, unexpired
+// and was issued for the state the provider sent back.
+func readStateCookie(r *http.Request, state string) (*loginState, error) {
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		return nil, errors.New("missing state cookie")
+	}
+
+	payload, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signState(payload))) {
+		return nil, errors.New("invalid state cookie signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("malformed state cookie")
+	}
+	var st loginState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, errors.New("malformed state cookie")
+	}
+
+	if time.Now().Unix() > st.Expires {
+		return nil, errors.New("state cookie expired")
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(st.State), []byte(state)) != 1 {
+		return nil, errors.New("state mismatch")
+	}
+	return &st, nil
+}
+
+func callbackPath(redirectURL string) string {
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// safeReturnPath returns path if it is a local path of the frontend, and ""
+// otherwise, so the return path can't send users to another site.
+func safeReturnPath(path string) string {
+	if path == "" || len(path) > maxReturnPath {
+		return ""
+	}
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	if strings.ContainsAny(path, "\\\r\n\t") {
+		return ""
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+	return path
+}
@@ -105,6 +105,220 @@ var migrations = []Migration{
 			CREATE INDEX IF NOT EXISTS idx_events_provider_event_id ON events(calendar_id, provider_event_id);
 		`,
 	},
+	{
+		Version: 5,
+		Name:    "add_calendars_sync_mode",
+		Up: `
+			ALTER TABLE calendars ADD COLUMN sync_mode TEXT;
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "add_calendars_webhook_token",
+		Up: `
+			ALTER TABLE calendars ADD COLUMN webhook_token TEXT;
+			CREATE INDEX IF NOT EXISTS idx_calendars_webhook_channel_id ON calendars(webhook_channel_id);
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "add_connected_accounts_server_credentials",
+		Up: `
+			ALTER TABLE connected_accounts ADD COLUMN server_url TEXT;
+			ALTER TABLE connected_accounts ADD COLUMN username TEXT;
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "add_calendars_feed_state",
+		Up: `
+			ALTER TABLE calendars ADD COLUMN feed_etag TEXT;
+			ALTER TABLE calendars ADD COLUMN feed_last_modified TEXT;
+			ALTER TABLE calendars ADD COLUMN feed_checked_at INTEGER;
+			ALTER TABLE calendars ADD COLUMN feed_error TEXT;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "create_feed_tokens_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS feed_tokens (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				calendar_id TEXT,
+				token_hash TEXT UNIQUE NOT NULL,
+				busy_only INTEGER DEFAULT 0,
+				last_used_at INTEGER,
+				created_at INTEGER NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (calendar_id) REFERENCES calendars(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS idx_feed_tokens_user_id ON feed_tokens(user_id);
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "create_app_passwords_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS app_passwords (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				password_hash TEXT UNIQUE NOT NULL,
+				last_used_at INTEGER,
+				created_at INTEGER NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS idx_app_passwords_user_id ON app_passwords(user_id);
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "add_events_dav_resources",
+		Up: `
+			ALTER TABLE events ADD COLUMN dav_name TEXT;
+			ALTER TABLE events ADD COLUMN dav_uid TEXT;
+			CREATE INDEX IF NOT EXISTS idx_events_dav_name ON events(calendar_id, dav_name);
+
+			CREATE TABLE IF NOT EXISTS event_tombstones (
+				calendar_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				deleted_at INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_event_tombstones_calendar ON event_tombstones(calendar_id, deleted_at);
+
+			CREATE TRIGGER IF NOT EXISTS events_tombstone AFTER DELETE ON events
+			BEGIN
+				INSERT INTO event_tombstones (calendar_id, name, deleted_at)
+				VALUES (old.calendar_id, COALESCE(old.dav_name, old.id || '.ics'), strftime('%s', 'now'));
+			END;
+		`,
+	},
+	{
+		Version: 12,
+		Name:    "create_provider_quota_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS provider_quota (
+				provider TEXT NOT NULL,
+				account_id TEXT NOT NULL,
+				hour INTEGER NOT NULL,
+				calls INTEGER NOT NULL DEFAULT 0,
+				throttled INTEGER NOT NULL DEFAULT 0,
+				wait_ms INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (provider, account_id, hour)
+			);
+			CREATE INDEX IF NOT EXISTS idx_provider_quota_hour ON provider_quota(hour);
+		`,
+	},
+	{
+		Version: 13,
+		Name:    "create_sessions_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				family_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				token_hash TEXT UNIQUE NOT NULL,
+				user_agent TEXT,
+				ip TEXT,
+				created_at INTEGER NOT NULL,
+				last_used_at INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL,
+				rotated_at INTEGER,
+				revoked_at INTEGER,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS idx_sessions_family ON sessions(family_id);
+			CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+		`,
+	},
+	{
+		Version: 14,
+		Name:    "create_revoked_tokens_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS revoked_tokens (
+				jti TEXT PRIMARY KEY,
+				expires_at INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON revoked_tokens(expires_at);
+		`,
+	},
+	{
+		Version: 15,
+		Name:    "add_token_key_ids",
+		Up: `
+			ALTER TABLE users ADD COLUMN token_key_id TEXT;
+			ALTER TABLE connected_accounts ADD COLUMN token_key_id TEXT;
+		`,
+	},
+	{
+		Version: 16,
+		Name:    "add_users_token_expiry",
+		Up: `
+			ALTER TABLE users ADD COLUMN token_expiry INTEGER;
+		`,
+	},
+	{
+		Version: 17,
+		Name:    "create_identities_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS identities (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				email TEXT,
+				created_at INTEGER NOT NULL,
+				last_login_at INTEGER NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				UNIQUE(provider, subject)
+			);
+			CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities(user_id);
+		`,
+	},
+	{
+		Version: 18,
+		Name:    "connect_login_accounts",
+		Up: `
+			INSERT INTO connected_accounts
+			(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry,
+			 token_key_id, created_at, updated_at)
+			SELECT lower(hex(randomblob(11))), u.id, 'google',
+			       COALESCE((SELECT i.subject FROM identities i
+			                 WHERE i.user_id = u.id AND i.provider = 'google' AND i.email = u.email), u.id),
+			       u.email, u.token, u.refresh_token, u.token_expiry, u.token_key_id,
+			       strftime('%s', 'now'), strftime('%s', 'now')
+			FROM users u
+			WHERE u.token IS NOT NULL AND u.token != ''
+			  AND NOT EXISTS (
+			      SELECT 1 FROM connected_accounts a
+			      WHERE a.user_id = u.id AND a.provider = 'google' AND a.email = u.email
+			  );
+
+			UPDATE calendars
+			SET connected_account_id = (
+			    SELECT a.id FROM connected_accounts a
+			    WHERE a.user_id = calendars.user_id AND a.provider = 'google'
+			      AND a.email = (SELECT email FROM users WHERE id = calendars.user_id)
+			)
+			WHERE connected_account_id IS NULL AND provider = 'google';
+		`,
+	},
+	{
+		Version: 19,
+		Name:    "add_connected_account_reauth",
+		Up: `
+			ALTER TABLE connected_accounts ADD COLUMN needs_reauth INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE connected_accounts ADD COLUMN reauth_reason TEXT;
+		`,
+	},
+	{
+		Version: 20,
+		Name:    "add_sessions_replaced_by",
+		Up: `
+			ALTER TABLE sessions ADD COLUMN replaced_by TEXT;
+		`,
+	},
 }
 
 func RunMigrations(db *sql.DB) error {
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OAuth tokens and CalDAV account passwords are encrypted at rest with
+// envelope encryption: every value gets its own random data key, and the data
+// key is stored next to it, encrypted with a key-encryption key (KEK). Rows
+// record the ID of the KEK in token_key_id; rows without one hold plaintext
+// from before encryption was configured.
+//
+// KEKs come from TOKEN_ENCRYPTION_KEYS, or the file named by
+// TOKEN_ENCRYPTION_KEY_FILE, as a list of id:base64-key entries separated by
+// commas or newlines. Keys are 32 bytes (AES-256). The first entry encrypts
+// new values; the others are still used to decrypt, until the rekey command
+// has moved every row to the first.
+
+const dataKeySize = 32
+
+type tokenKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var (
+	tokenKeysOnce sync.Once
+	tokenKeysErr  error
+	currentKey    *tokenKey
+	keysByID      map[string]*tokenKey
+)
+
+func loadTokenKeys() error {
+	tokenKeysOnce.Do(func() {
+		keysByID = make(map[string]*tokenKey)
+
+		spec := os.Getenv("TOKEN_ENCRYPTION_KEYS")
+		if file := os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				tokenKeysErr = fmt.Errorf("failed to read token encryption keys: %w", err)
+				return
+			}
+			spec = string(data)
+		}
+
+		for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+			key, err := parseTokenKey(entry)
+			if err != nil {
+				tokenKeysErr = err
+				return
+			}
+			if _, ok := keysByID[key.id]; ok {
+				tokenKeysErr = fmt.Errorf("duplicate token encryption key ID %q", key.id)
+				return
+			}
+			keysByID[key.id] = key
+			if currentKey == nil {
+				currentKey = key
+			}
+		}
+
+		if currentKey == nil && os.Getenv("GO_ENV") == "production" {
+			log.Printf("WARNING: TOKEN_ENCRYPTION_KEYS not set, OAuth tokens are stored unencrypted")
+		}
+	})
+	return tokenKeysErr
+}
+
+func parseTokenKey(entry string) (*tokenKey, error) {
+	id, encoded, ok := strings.Cut(entry, ":")
+	if !ok || id == "" {
+		return nil, errors.New("token encryption keys must be given as id:base64-key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("token encryption key %q must be 32 bytes in base64", id)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenKey{id: id, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CurrentTokenKeyID returns the ID of the key new tokens are encrypted with,
+// or "" when encryption isn't configured.
+func CurrentTokenKeyID() (string, error) {
+	if err := loadTokenKeys(); err != nil {
+		return "", err
+	}
+	if currentKey == nil {
+		return "", nil
+	}
+	return currentKey.id, nil
+}
+
+// SealTokens encrypts the tokens of one row in place with the current key
+// and returns the key ID to store in the row's token_key_id. Without keys it
+// leaves the tokens as they are and returns nil. Empty tokens stay empty.
+func SealTokens(tokens ...*string) (*string, error) {
+	if err := loadTokenKeys(); err != nil {
+		return nil, err
+	}
+	if currentKey == nil {
+		return nil, nil
+	}
+
+	for _, token := range tokens {
+		if *token == "" {
+			continue
+		}
+		sealed, err := seal(currentKey, *token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt token: %w", err)
+		}
+		*token = sealed
+	}
+
+	id := currentKey.id
+	return &id, nil
+}
+
+// OpenTokens decrypts in place the tokens of a row whose token_key_id is
+// keyID. Rows without a key ID are plaintext and left alone.
+func OpenTokens(keyID sql.NullString, tokens ...*string) error {
+	if !keyID.Valid || keyID.String == "" {
+		return nil
+	}
+	if err := loadTokenKeys(); err != nil {
+		return err
+	}
+	key, ok := keysByID[keyID.String]
+	if !ok {
+		return fmt.Errorf("token encryption key %q is not configured", keyID.String)
+	}
+
+	for _, token := range tokens {
+		if *token == "" {
+			continue
+		}
+		opened, err := open(key, *token)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt token: %w", err)
+		}
+		*token = opened
+	}
+	return nil
+}
+
+// seal encrypts plaintext with a fresh data key and wraps the data key with
+// the KEK. The result is the base64 of wrap nonce, wrapped data key, nonce
+// and ciphertext.
+func seal(kek *tokenKey, plaintext string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapNonce := make([]byte, kek.aead.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(wrapNonce); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	out := append([]byte{}, wrapNonce...)
+	out = kek.aead.Seal(out, wrapNonce, dataKey, []byte(kek.id))
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func open(kek *tokenKey, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	wrapNonceSize := kek.aead.NonceSize()
+	wrappedSize := dataKeySize + kek.aead.Overhead()
+	if len(data) < wrapNonceSize+wrappedSize {
+		return "", errors.New("sealed token is too short")
+	}
+	dataKey, err := kek.aead.Open(nil, data[:wrapNonceSize], data[wrapNonceSize:wrapNonceSize+wrappedSize], []byte(kek.id))
+	if err != nil {
+		return "", err
+	}
+	data = data[wrapNonceSize+wrappedSize:]
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed token is too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+)
+
+func resetTokenKeys(keys string) {
+	os.Setenv("TOKEN_ENCRYPTION_KEYS", keys)
+	tokenKeysOnce = sync.Once{}
+	tokenKeysErr = nil
+	currentKey = nil
+	keysByID = nil
+}
+
+func TestSealAndOpenTokens(t *testing.T) {
+	defer os.Unsetenv("TOKEN_ENCRYPTION_KEYS")
+
+	oldKey := "old:" + "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	newKey := "new:" + "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+
+	resetTokenKeys(oldKey)
+	access, refresh, empty := "access-token", "refresh-token", ""
+	keyID, err := SealTokens(&access, &refresh, &empty)
+	if err != nil {
+		t.Fatalf("SealTokens failed: %v", err)
+	}
+	if keyID == nil || *keyID != "old" {
+		t.Fatalf("Expected key ID old, got %v", keyID)
+	}
+	if access == "access-token" || empty != "" {
+		t.Fatalf("Expected non-empty tokens to be encrypted and empty ones kept, got %q and %q", access, empty)
+	}
+
+	// After rotating, the old key still decrypts.
+	resetTokenKeys(newKey + "," + oldKey)
+	if err := OpenTokens(sql.NullString{String: *keyID, Valid: true}, &access, &refresh); err != nil {
+		t.Fatalf("OpenTokens failed: %v", err)
+	}
+	if access != "access-token" || refresh != "refresh-token" {
+		t.Errorf("Expected decrypted tokens, got %q and %q", access, refresh)
+	}
+
+	// Plaintext rows are left alone.
+	plain := "plain"
+	if err := OpenTokens(sql.NullString{}, &plain); err != nil || plain != "plain" {
+		t.Errorf("Expected plaintext token to be kept, got %q (%v)", plain, err)
+	}
+
+	resetTokenKeys(newKey)
+	sealed := "token"
+	keyID, _ = SealTokens(&sealed)
+	resetTokenKeys(oldKey)
+	if err := OpenTokens(sql.NullString{String: *keyID, Valid: true}, &sealed); err == nil {
+		t.Error("Expected an error for a key that is not configured")
+	}
+}
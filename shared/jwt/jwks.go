@@ -0,0 +1,139 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksMaxAge is how long fetched keys are used before fetching again.
+	jwksMaxAge = 10 * time.Minute
+	// jwksMinRefresh limits refetching for tokens with unknown key IDs.
+	jwksMinRefresh = 30 * time.Second
+)
+
+// remoteKeySet caches the keys published at JWT_JWKS_URL. A token signed
+// with a key it doesn't know yet triggers a refetch, so verifiers pick up a
+// rotated key as soon as the first token signed with it arrives.
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]publicKey
+	fetched time.Time
+}
+
+var (
+	remote     *remoteKeySet
+	remoteOnce sync.Once
+)
+
+func getRemoteKeySet() *remoteKeySet {
+	remoteOnce.Do(func() {
+		if url := os.Getenv("JWT_JWKS_URL"); url != "" {
+			remote = &remoteKeySet{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+		}
+	})
+	return remote
+}
+
+func (s *remoteKeySet) key(kid string) (publicKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetched)
+	if (ok && age < jwksMaxAge) || (!ok && age < jwksMinRefresh) {
+		return key, ok
+	}
+
+	keys, err := s.fetch()
+	s.fetched = time.Now()
+	if err != nil {
+		// Keep verifying with the keys we have until the set is back.
+		log.Printf("Failed to fetch JWKS from %s: %v", s.url, err)
+		return key, ok
+	}
+	s.keys = keys
+
+	key, ok = s.keys[kid]
+	return key, ok
+}
+
+func (s *remoteKeySet) fetch() (map[string]publicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := fromJWK(jwk)
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[pub.kid] = pub
+	}
+	return keys, nil
+}
+
+// KeySet verifies tokens from another issuer, such as the ID tokens of an
+// OpenID provider, with the keys it publishes as a JWKS.
+type KeySet struct {
+	keys *remoteKeySet
+}
+
+// NewKeySet returns a key set that fetches its keys from url on first use.
+func NewKeySet(url string) *KeySet {
+	return &KeySet{keys: &remoteKeySet{url: url, client: &http.Client{Timeout: 10 * time.Second}}}
+}
+
+// Verify checks the token's signature, expiry and audience and returns its
+// claims. Checking the issuer and any nonce is left to the caller, as their
+// format differs between providers.
+func (s *KeySet) Verify(tokenStr, audience string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		pub, ok := s.keys.key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if pub.method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method for key %q", kid)
+		}
+		return pub.key, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
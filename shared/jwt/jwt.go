@@ -1,6 +1,8 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"sync"
@@ -9,6 +11,10 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AccessTokenTTL is how long a token from GenerateJWT is valid. Clients
+// renew it with their refresh token before or when it runs out.
+const AccessTokenTTL = 15 * time.Minute
+
 var (
 	jwtSecret []byte
 	once      sync.Once
@@ -19,39 +25,140 @@ func getSecret() []byte {
 		secret := os.Getenv("JWT_SECRET")
 		if secret == "" {
 			fmt.Println("WARNING: JWT_SECRET not set. Using insecure default. Set JWT_SECRET env var in production.")
-			secret = "dev-secret-do-not-use-in-production"
+			secret = defaultSecret
 		}
 		jwtSecret = []byte(secret)
 	})
 	return jwtSecret
 }
 
-func ParseJWT(tokenStr string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// Claims are the parts of a token callers act on.
+type Claims struct {
+	Subject string
+	// ID is the token's jti, by which it can be revoked on its own.
+	ID string
+	// SessionID is the session the token was issued for, if any. Revoking
+	// the session revokes its tokens.
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// ParseClaims verifies the token and returns its claims. Tokens that were
+// revoked are rejected like expired ones.
+func ParseClaims(tokenStr string) (*Claims, error) {
+	token, err := jwt.Parse(tokenStr, verificationKey,
+		jwt.WithValidMethods([]string{"EdDSA", "RS256", "HS256"}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims := &Claims{}
+	if claims.Subject, ok = mapClaims["sub"].(string); !ok {
+		return nil, fmt.Errorf("invalid token")
+	}
+	claims.ID, _ = mapClaims["jti"].(string)
+	claims.SessionID, _ = mapClaims["sid"].(string)
+	if exp, err := mapClaims.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+
+	if err := checkRevoked(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verificationKey finds the key a token was signed with: a key from the
+// local ring or the published JWKS by kid, or the shared secret for HS256.
+// The secret is only accepted while no keys are configured or JWT_SECRET is
+// still set, which lets tokens signed before switching to keys run out.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if asymmetricConfigured() && os.Getenv("JWT_SECRET") == "" {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
 		return getSecret(), nil
-	})
+	}
 
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no key ID")
+	}
+
+	r, err := getKeyRing()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	pub, ok := r.keys[kid]
+	if !ok {
+		if remote := getRemoteKeySet(); remote != nil {
+			pub, ok = remote.key(kid)
+		}
 	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if pub.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method for key %q", kid)
+	}
+	return pub.key, nil
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["sub"].(string), nil
-	} else {
-		return "", fmt.Errorf("invalid token")
+// ParseJWT verifies the token and returns the email it was issued to.
+func ParseJWT(tokenStr string) (string, error) {
+	claims, err := ParseClaims(tokenStr)
+	if err != nil {
+		return "", err
 	}
+	return claims.Subject, nil
 }
 
 func GenerateJWT(email string) (string, error) {
+	return GenerateSessionJWT(email, "")
+}
+
+// GenerateSessionJWT issues a token for a session, so that ending the
+// session also ends the token.
+func GenerateSessionJWT(email, sessionID string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
 		"sub": email,
-		"exp": time.Now().Add(time.Hour * 1).Unix(),
+		"jti": jti,
+		"exp": time.Now().Add(AccessTokenTTL).Unix(),
 		"iat": time.Now().Unix(),
 	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(getSecret())
-}
\ No newline at end of file
+	r, err := getKeyRing()
+	if err != nil {
+		return "", err
+	}
+	if r.signingKey == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(getSecret())
+	}
+
+	token := jwt.NewWithClaims(r.signingMethod, claims)
+	token.Header["kid"] = r.signingKID
+	return token.SignedString(r.signingKey)
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
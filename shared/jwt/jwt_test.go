@@ -59,3 +59,48 @@ func TestParseEmptyJWT(t *testing.T) {
 		t.Error("Expected error for empty token")
 	}
 }
+
+type fakeRevocationStore struct {
+	tokens   map[string]bool
+	sessions map[string]bool
+}
+
+func (s *fakeRevocationStore) IsRevoked(tokenID, sessionID string) (bool, error) {
+	return s.tokens[tokenID] || s.sessions[sessionID], nil
+}
+
+func TestParseRevokedJWT(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret-key-for-testing-purposes")
+	defer os.Unsetenv("JWT_SECRET")
+
+	jwtSecret = nil
+	once = sync.Once{}
+
+	store := &fakeRevocationStore{tokens: map[string]bool{}, sessions: map[string]bool{}}
+	SetRevocationStore(store)
+	defer SetRevocationStore(nil)
+
+	token, err := GenerateSessionJWT("test@example.com", "session-1")
+	if err != nil {
+		t.Fatalf("GenerateSessionJWT failed: %v", err)
+	}
+
+	claims, err := ParseClaims(token)
+	if err != nil {
+		t.Fatalf("ParseClaims failed: %v", err)
+	}
+	if claims.ID == "" || claims.SessionID != "session-1" {
+		t.Fatalf("Expected jti and sid claims, got %+v", claims)
+	}
+
+	store.tokens[claims.ID] = true
+	if _, err := ParseJWT(token); err != ErrRevoked {
+		t.Errorf("Expected ErrRevoked for revoked token, got %v", err)
+	}
+
+	delete(store.tokens, claims.ID)
+	store.sessions["session-1"] = true
+	if _, err := ParseJWT(token); err != ErrRevoked {
+		t.Errorf("Expected ErrRevoked for token of revoked session, got %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultSecret is only used outside production, when neither keys nor
+// JWT_SECRET are configured.
+const defaultSecret = "dev-secret-do-not-use-in-production"
+
+// exampleSecret is the placeholder from .env.example.
+const exampleSecret = "your-secure-random-secret-min-32-chars"
+
+// minSecretLength is the shortest JWT_SECRET accepted in production.
+const minSecretLength = 32
+
+// publicKey is a key tokens are verified with, under its kid.
+type publicKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+// keyRing holds the key new tokens are signed with and the keys tokens are
+// still accepted from. Rotating a key means signing with a new one while the
+// old one stays in the ring until the tokens it signed have expired.
+type keyRing struct {
+	signingKID    string
+	signingMethod jwt.SigningMethod
+	signingKey    crypto.PrivateKey
+	keys          map[string]publicKey
+	// order keeps the JWKS output stable, signing key first.
+	order []string
+}
+
+var (
+	ring     *keyRing
+	ringErr  error
+	ringOnce sync.Once
+)
+
+// Setup loads the signing configuration and reports problems that should
+// stop a service from starting: unreadable key files, and in production
+// (GO_ENV=production) falling back to the built-in or example secret.
+// Services call it at startup; token functions load it on first use too.
+func Setup() error {
+	_, err := getKeyRing()
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("GO_ENV") == "production" && !asymmetricConfigured() {
+		secret := os.Getenv("JWT_SECRET")
+		switch {
+		case secret == "" || secret == defaultSecret || secret == exampleSecret:
+			return errors.New("JWT_SECRET must be set in production, or JWT_SIGNING_KEY_FILE/JWT_JWKS_URL configured")
+		case len(secret) < minSecretLength:
+			return fmt.Errorf("JWT_SECRET must be at least %d characters in production", minSecretLength)
+		}
+	}
+	return nil
+}
+
+func asymmetricConfigured() bool {
+	r, err := getKeyRing()
+	return err == nil && len(r.keys) > 0 || os.Getenv("JWT_JWKS_URL") != ""
+}
+
+// getKeyRing loads the keys from JWT_SIGNING_KEY_FILE, the PEM private key
+// new tokens are signed with, and JWT_VERIFY_KEY_FILES, a comma-separated
+// list of PEM keys (private or public) of earlier signing keys.
+func getKeyRing() (*keyRing, error) {
+	ringOnce.Do(func() {
+		ring, ringErr = loadKeyRing(os.Getenv("JWT_SIGNING_KEY_FILE"), os.Getenv("JWT_VERIFY_KEY_FILES"))
+	})
+	return ring, ringErr
+}
+
+func loadKeyRing(signingFile, verifyFiles string) (*keyRing, error) {
+	r := &keyRing{keys: make(map[string]publicKey)}
+
+	if signingFile != "" {
+		priv, err := readPrivateKey(signingFile)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := newPublicKey(priv.(crypto.Signer).Public())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", signingFile, err)
+		}
+		r.signingKID = pub.kid
+		r.signingMethod = pub.method
+		r.signingKey = priv
+		r.add(pub)
+	}
+
+	for _, file := range strings.Split(verifyFiles, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		pub, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		r.add(pub)
+	}
+
+	return r, nil
+}
+
+func (r *keyRing) add(pub publicKey) {
+	if _, ok := r.keys[pub.kid]; ok {
+		return
+	}
+	r.keys[pub.kid] = pub
+	r.order = append(r.order, pub.kid)
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+	return block, nil
+}
+
+func readPrivateKey(file string) (crypto.PrivateKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	switch key.(type) {
+	case ed25519.PrivateKey, *rsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%s: only Ed25519 and RSA keys are supported", file)
+	}
+}
+
+func readPublicKey(file string) (publicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return publicKey{}, err
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		var priv crypto.PrivateKey
+		if priv, err = readPrivateKey(file); err == nil {
+			key = priv.(crypto.Signer).Public()
+		}
+	}
+	if err != nil {
+		return publicKey{}, fmt.Errorf("%s: %w", file, err)
+	}
+
+	pub, err := newPublicKey(key)
+	if err != nil {
+		return publicKey{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return pub, nil
+}
+
+// newPublicKey names the key by its RFC 7638 thumbprint, so the kid follows
+// from the key itself and needs no configuration.
+func newPublicKey(key crypto.PublicKey) (publicKey, error) {
+	jwk, err := toJWK(key)
+	if err != nil {
+		return publicKey{}, err
+	}
+
+	var members string
+	var method jwt.SigningMethod
+	switch jwk.Kty {
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+		method = jwt.SigningMethodEdDSA
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+		method = jwt.SigningMethodRS256
+	}
+	sum := sha256.Sum256([]byte(members))
+
+	return publicKey{
+		kid:    base64.RawURLEncoding.EncodeToString(sum[:]),
+		method: method,
+		key:    key,
+	}, nil
+}
+
+// JSONWebKey is a public key as published in a JWKS.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func toJWK(key crypto.PublicKey) (JSONWebKey, error) {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return JSONWebKey{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(k)}, nil
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	default:
+		return JSONWebKey{}, errors.New("only Ed25519 and RSA keys are supported")
+	}
+}
+
+func fromJWK(jwk JSONWebKey) (publicKey, error) {
+	var key crypto.PublicKey
+	switch jwk.Kty {
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return publicKey{}, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid Ed25519 key")
+		}
+		key = ed25519.PublicKey(x)
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return publicKey{}, errors.New("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, errors.New("invalid RSA exponent")
+		}
+		key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	pub, err := newPublicKey(key)
+	if err != nil {
+		return publicKey{}, err
+	}
+	if jwk.Kid != "" {
+		pub.kid = jwk.Kid
+	}
+	return pub, nil
+}
+
+// JWKS returns the public keys of the local key ring as a JSON Web Key Set,
+// for services that verify tokens without holding the keys.
+func JWKS() ([]byte, error) {
+	r, err := getKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	set := struct {
+		Keys []JSONWebKey `json:"keys"`
+	}{Keys: []JSONWebKey{}}
+
+	for _, kid := range r.order {
+		pub := r.keys[kid]
+		jwk, err := toJWK(pub.key)
+		if err != nil {
+			return nil, err
+		}
+		jwk.Use = "sig"
+		jwk.Alg = pub.method.Alg()
+		jwk.Kid = kid
+		set.Keys = append(set.Keys, jwk)
+	}
+	return json.Marshal(set)
+}
@@ -0,0 +1,180 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeEd25519Key(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return file
+}
+
+func resetKeys(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range []string{"JWT_SECRET", "JWT_SIGNING_KEY_FILE", "JWT_VERIFY_KEY_FILES", "JWT_JWKS_URL"} {
+		os.Unsetenv(key)
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+	}
+
+	jwtSecret = nil
+	once = sync.Once{}
+	ring, ringErr = nil, nil
+	ringOnce = sync.Once{}
+	remote = nil
+	remoteOnce = sync.Once{}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := writeEd25519Key(t, dir, "old.pem")
+	newKey := writeEd25519Key(t, dir, "new.pem")
+	defer resetKeys(t, nil)
+
+	resetKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": oldKey})
+	oldToken, err := GenerateJWT("test@example.com")
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	resetKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": newKey, "JWT_VERIFY_KEY_FILES": oldKey})
+	if _, err := ParseJWT(oldToken); err != nil {
+		t.Errorf("Token signed with the previous key was rejected: %v", err)
+	}
+
+	resetKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": newKey})
+	if _, err := ParseJWT(oldToken); err == nil {
+		t.Error("Expected token signed with a removed key to be rejected")
+	}
+}
+
+func TestVerifyWithJWKS(t *testing.T) {
+	dir := t.TempDir()
+	key := writeEd25519Key(t, dir, "signing.pem")
+	defer resetKeys(t, nil)
+
+	// Signed with the built-in development secret.
+	resetKeys(t, nil)
+	hsToken, err := GenerateJWT("test@example.com")
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	resetKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": key})
+	token, err := GenerateJWT("test@example.com")
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+	jwks, err := JWKS()
+	if err != nil {
+		t.Fatalf("JWKS failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	resetKeys(t, map[string]string{"JWT_JWKS_URL": server.URL})
+	email, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT with JWKS failed: %v", err)
+	}
+	if email != "test@example.com" {
+		t.Errorf("Expected email test@example.com, got %s", email)
+	}
+
+	// With keys published, HS256 tokens are no longer accepted unless a
+	// secret is configured.
+	if _, err := ParseJWT(hsToken); err == nil {
+		t.Error("Expected HS256 token to be rejected when only keys are configured")
+	}
+}
+
+func TestSetupRefusesDefaultSecretInProduction(t *testing.T) {
+	os.Setenv("GO_ENV", "production")
+	defer os.Unsetenv("GO_ENV")
+	defer resetKeys(t, nil)
+
+	resetKeys(t, nil)
+	if err := Setup(); err == nil {
+		t.Error("Expected Setup to fail without JWT_SECRET in production")
+	}
+
+	resetKeys(t, map[string]string{"JWT_SECRET": "a-long-enough-secret-for-production-use"})
+	if err := Setup(); err != nil {
+		t.Errorf("Setup failed with a proper secret: %v", err)
+	}
+}
+
+func TestKeySetVerify(t *testing.T) {
+	dir := t.TempDir()
+	key := writeEd25519Key(t, dir, "idp.pem")
+	defer resetKeys(t, nil)
+
+	resetKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": key})
+	r, err := getKeyRing()
+	if err != nil {
+		t.Fatalf("getKeyRing failed: %v", err)
+	}
+	jwks, err := JWKS()
+	if err != nil {
+		t.Fatalf("JWKS failed: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"sub": "subject",
+		"aud": "client-id",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = r.signingKID
+	idToken, err := token.SignedString(r.signingKey)
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+
+	keys := NewKeySet(server.URL)
+	claims, err := keys.Verify(idToken, "client-id")
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims["sub"] != "subject" {
+		t.Errorf("Expected subject, got %v", claims["sub"])
+	}
+
+	if _, err := keys.Verify(idToken, "other-client"); err == nil {
+		t.Error("Expected token for another audience to be rejected")
+	}
+}
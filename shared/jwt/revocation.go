@@ -0,0 +1,90 @@
+package jwt
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRevoked is returned for tokens that were revoked before they expired.
+var ErrRevoked = errors.New("token revoked")
+
+// RevocationStore knows which tokens and sessions have been revoked.
+type RevocationStore interface {
+	IsRevoked(tokenID, sessionID string) (bool, error)
+}
+
+var (
+	storeMu sync.RWMutex
+	store   RevocationStore
+)
+
+// SetRevocationStore makes ParseJWT and ParseClaims reject the tokens the
+// store reports as revoked. Without a store, tokens are valid until they
+// expire.
+func SetRevocationStore(s RevocationStore) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// checkRevoked fails closed: a token whose state can't be checked is
+// refused.
+func checkRevoked(claims *Claims) error {
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+
+	if s == nil || (claims.ID == "" && claims.SessionID == "") {
+		return nil
+	}
+	revoked, err := s.IsRevoked(claims.ID, claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// SQLRevocationStore reads revocations from the revoked_tokens and sessions
+// tables.
+type SQLRevocationStore struct {
+	db *sql.DB
+}
+
+func NewSQLRevocationStore(db *sql.DB) *SQLRevocationStore {
+	return &SQLRevocationStore{db: db}
+}
+
+func (s *SQLRevocationStore) IsRevoked(tokenID, sessionID string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		    OR EXISTS (SELECT 1 FROM sessions WHERE family_id = ? AND revoked_at IS NOT NULL)
+	`, tokenID, sessionID).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+// Revoke records that the token is revoked. The record is only needed until
+// the token expires.
+func (s *SQLRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		"INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)",
+		tokenID, expiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+module shared/oauthtoken
+
+go 1.22.1
+
+require (
+	golang.org/x/oauth2 v0.25.0
+	shared/database v0.0.0
+)
+
+require github.com/mattn/go-sqlite3 v1.14.30 // indirect
+
+replace shared/database => ../database
@@ -0,0 +1,283 @@
+// Package oauthtoken keeps the OAuth tokens of connected accounts current
+// for every service that calls a provider with them. Refreshed access
+// tokens, rotated refresh tokens and their expiry are written back to the
+// account, so the next caller reuses them instead of refreshing again. An
+// account whose grant the provider rejects is marked as needing
+// reauthentication, and isn't refreshed again until the user consents anew.
+//
+// The sync backend and the watcher refresh the same accounts. Within a
+// process a mutex per account keeps concurrent calls to one refresh; across
+// processes the new tokens are only stored if the account still holds the
+// refresh token that was used, and a caller that lost that race takes the
+// stored tokens of the one that won.
+package oauthtoken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"shared/database"
+)
+
+// ReauthError is returned when the provider no longer accepts the account's
+// grant, for example because the user revoked access. Retrying won't help;
+// the user has to consent again. Reason is the provider's error description.
+type ReauthError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ReauthError) Error() string {
+	return "account needs to be reconnected: " + e.Err.Error()
+}
+
+func (e *ReauthError) Unwrap() error {
+	return e.Err
+}
+
+// locks holds a mutex per account ID, so concurrent calls for one account
+// refresh its token once and the others pick up the stored result.
+var locks sync.Map
+
+func lock(accountID string) *sync.Mutex {
+	mu, _ := locks.LoadOrStore(accountID, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// Source returns a token source for the connected account that refreshes
+// through config and stores what it gets. token is what the caller already
+// read of the account; without an expiry it isn't trusted, since tokens
+// stored before expiries were kept need one refresh to learn theirs.
+func Source(ctx context.Context, config *oauth2.Config, accountID string, token *oauth2.Token) oauth2.TokenSource {
+	if token != nil && token.Expiry.IsZero() {
+		token = nil
+	}
+	return oauth2.ReuseTokenSource(token, &accountSource{ctx: ctx, config: config, accountID: accountID})
+}
+
+// ForceRefresh refreshes the account's token even if it hasn't expired and
+// returns the token that is stored afterwards.
+func ForceRefresh(ctx context.Context, config *oauth2.Config, accountID string) (*oauth2.Token, error) {
+	src := &accountSource{ctx: ctx, config: config, accountID: accountID, force: true}
+	return src.Token()
+}
+
+type accountSource struct {
+	ctx       context.Context
+	config    *oauth2.Config
+	accountID string
+	// force skips reusing a stored token that's still valid.
+	force bool
+}
+
+func (s *accountSource) Token() (*oauth2.Token, error) {
+	mu := lock(s.accountID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Read the account again under the lock: another caller, here or in
+	// another service, may have refreshed, and possibly rotated the refresh
+	// token, while we waited.
+	stored, err := load(s.accountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := stored.reauthErr(); err != nil {
+		return nil, err
+	}
+	if !s.force && !stored.token.Expiry.IsZero() && stored.token.Valid() {
+		return stored.token, nil
+	}
+	if stored.token.RefreshToken == "" {
+		// Nothing to refresh with; let the provider reject the token.
+		return stored.token, nil
+	}
+
+	token, err := Refresh(s.ctx, s.config, stored.token)
+	if err != nil {
+		var reauth *ReauthError
+		if errors.As(err, &reauth) {
+			log.Printf("Account %s needs to be reconnected: %s", s.accountID, reauth.Reason)
+			if err := markNeedsReauth(s.accountID, stored.sealedRefresh, reauth.Reason); err != nil {
+				log.Printf("Failed to mark account %s for reauth: %v", s.accountID, err)
+			}
+		}
+		return nil, err
+	}
+
+	swapped, err := store(s.accountID, stored.sealedRefresh, token)
+	if err != nil {
+		// The token is good for this call; the next one refreshes again.
+		log.Printf("Failed to store refreshed token for account %s: %v", s.accountID, err)
+		return token, nil
+	}
+	if !swapped {
+		// Another service refreshed, or the user reconnected, in the
+		// meantime. Keep the stored tokens so both agree on one refresh
+		// token.
+		stored, err := load(s.accountID)
+		if err != nil {
+			return nil, err
+		}
+		if err := stored.reauthErr(); err != nil {
+			return nil, err
+		}
+		return stored.token, nil
+	}
+	return token, nil
+}
+
+// Refresh trades the token's refresh token for a new access token.
+// Providers that don't rotate refresh tokens return none, so the old one is
+// kept. A refresh token the provider rejects is reported as a ReauthError.
+func Refresh(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (*oauth2.Token, error) {
+	newToken, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		err = fmt.Errorf("failed to refresh token: %w", err)
+		if reason, ok := ReauthReason(err); ok {
+			return nil, &ReauthError{Reason: reason, Err: err}
+		}
+		return nil, err
+	}
+	if newToken.RefreshToken == "" {
+		newToken.RefreshToken = token.RefreshToken
+	}
+	return newToken, nil
+}
+
+// ReauthReason reports whether err is the token endpoint refusing the grant
+// itself, as Google and Microsoft do once access was revoked, the password
+// changed or the refresh token expired, rather than a transient failure.
+func ReauthReason(err error) (string, bool) {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return "", false
+	}
+	switch retrieveErr.ErrorCode {
+	case "invalid_grant", "interaction_required", "consent_required":
+	default:
+		return "", false
+	}
+
+	reason := retrieveErr.ErrorCode
+	// Microsoft appends trace and correlation IDs on further lines.
+	desc, _, _ := strings.Cut(retrieveErr.ErrorDescription, "\n")
+	if desc = strings.TrimSpace(desc); desc != "" {
+		reason += ": " + desc
+	}
+	return reason, true
+}
+
+// storedToken is a connected account's token as the database holds it.
+// sealedRefresh is the refresh token column as stored, which updates compare
+// against to find out whether someone else changed it.
+type storedToken struct {
+	token         *oauth2.Token
+	sealedRefresh sql.NullString
+	needsReauth   bool
+	reauthReason  string
+}
+
+func (s *storedToken) reauthErr() error {
+	if !s.needsReauth {
+		return nil
+	}
+	return &ReauthError{Reason: s.reauthReason, Err: errors.New("grant was revoked")}
+}
+
+func load(accountID string) (*storedToken, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var s storedToken
+	var tokenExpiry sql.NullInt64
+	var tokenKeyID, reauthReason sql.NullString
+	s.token = &oauth2.Token{TokenType: "Bearer"}
+	err = db.QueryRow(`
+		SELECT access_token, refresh_token, token_expiry, token_key_id, needs_reauth, reauth_reason
+		FROM connected_accounts
+		WHERE id = ?
+	`, accountID).Scan(&s.token.AccessToken, &s.sealedRefresh, &tokenExpiry, &tokenKeyID, &s.needsReauth, &reauthReason)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("connected account %s not found", accountID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account tokens: %w", err)
+	}
+
+	s.token.RefreshToken = s.sealedRefresh.String
+	if err := database.OpenTokens(tokenKeyID, &s.token.AccessToken, &s.token.RefreshToken); err != nil {
+		return nil, err
+	}
+	if tokenExpiry.Valid {
+		s.token.Expiry = time.Unix(tokenExpiry.Int64, 0)
+	}
+	s.reauthReason = reauthReason.String
+	return &s, nil
+}
+
+// store writes the refreshed token if the account still holds the refresh
+// token it was refreshed with, and reports whether it did.
+func store(accountID string, sealedRefresh sql.NullString, token *oauth2.Token) (bool, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	accessToken, refreshToken := token.AccessToken, token.RefreshToken
+	tokenKeyID, err := database.SealTokens(&accessToken, &refreshToken)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
+	var refreshVal, expiryVal interface{} = nil, nil
+	if refreshToken != "" {
+		refreshVal = refreshToken
+	}
+	if !token.Expiry.IsZero() {
+		expiryVal = token.Expiry.Unix()
+	}
+
+	result, err := db.Exec(`
+		UPDATE connected_accounts
+		SET access_token = ?, refresh_token = ?, token_key_id = ?, token_expiry = ?,
+		    needs_reauth = 0, reauth_reason = NULL, updated_at = ?
+		WHERE id = ? AND refresh_token IS ?
+	`, accessToken, refreshVal, tokenKeyID, expiryVal, time.Now().Unix(), accountID, sealedRefresh)
+	if err != nil {
+		return false, fmt.Errorf("failed to update account tokens: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update account tokens: %w", err)
+	}
+	return n == 1, nil
+}
+
+// markNeedsReauth flags the account, unless its refresh token changed since
+// the one that was rejected, as when the user just reconnected it.
+func markNeedsReauth(accountID string, sealedRefresh sql.NullString, reason string) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE connected_accounts
+		SET needs_reauth = 1, reauth_reason = ?, updated_at = ?
+		WHERE id = ? AND refresh_token IS ?
+	`, reason, time.Now().Unix(), accountID, sealedRefresh)
+	if err != nil {
+		return fmt.Errorf("failed to mark account for reauth: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package oauthtoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"shared/database"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "oauthtoken")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	code := m.Run()
+	database.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// insertAccount stores a connected account with an expired access token.
+func insertAccount(t *testing.T, id, refreshToken string) {
+	t.Helper()
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	now := time.Now().Unix()
+	_, err = db.Exec(`INSERT OR IGNORE INTO users (id, email) VALUES ('user', 'user@example.com')`)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO connected_accounts
+		(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry, created_at, updated_at)
+		VALUES (?, 'user', 'google', ?, 'user@example.com', 'old-access', ?, ?, ?, ?)
+	`, id, id, refreshToken, now-60, now, now)
+	if err != nil {
+		t.Fatalf("Failed to insert account: %v", err)
+	}
+}
+
+// tokenServer answers refreshes with status and body, after calling before.
+func tokenServer(t *testing.T, before func(), status int, body string) *oauth2.Config {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if before != nil {
+			before()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: srv.URL}}
+}
+
+func TestSourceStoresRefreshedToken(t *testing.T) {
+	insertAccount(t, "stores", "refresh-1")
+	conf := tokenServer(t, nil, http.StatusOK,
+		`{"access_token":"new-access","refresh_token":"refresh-2","expires_in":3600,"token_type":"Bearer"}`)
+
+	token, err := Source(context.Background(), conf, "stores", nil).Token()
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if token.AccessToken != "new-access" {
+		t.Errorf("Expected new-access, got %q", token.AccessToken)
+	}
+
+	stored, err := load("stores")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if stored.token.AccessToken != "new-access" || stored.token.RefreshToken != "refresh-2" {
+		t.Errorf("Expected the refreshed tokens to be stored, got %q and %q",
+			stored.token.AccessToken, stored.token.RefreshToken)
+	}
+}
+
+func TestSourceKeepsTokensOfConcurrentRefresh(t *testing.T) {
+	insertAccount(t, "race", "refresh-1")
+	// Another service refreshes while our request is in flight.
+	conf := tokenServer(t, func() {
+		db, _ := database.GetDB()
+		db.Exec(`UPDATE connected_accounts SET access_token = 'their-access', refresh_token = 'their-refresh',
+			token_expiry = ? WHERE id = 'race'`, time.Now().Add(time.Hour).Unix())
+	}, http.StatusOK, `{"access_token":"our-access","refresh_token":"our-refresh","expires_in":3600}`)
+
+	token, err := Source(context.Background(), conf, "race", nil).Token()
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if token.AccessToken != "their-access" || token.RefreshToken != "their-refresh" {
+		t.Errorf("Expected the stored tokens of the other refresh, got %q and %q", token.AccessToken, token.RefreshToken)
+	}
+
+	stored, err := load("race")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if stored.token.RefreshToken != "their-refresh" {
+		t.Errorf("Expected the other refresh token to be kept, got %q", stored.token.RefreshToken)
+	}
+}
+
+func TestSourceMarksRevokedGrant(t *testing.T) {
+	insertAccount(t, "revoked", "refresh-1")
+	conf := tokenServer(t, nil, http.StatusBadRequest,
+		`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`)
+
+	_, err := Source(context.Background(), conf, "revoked", nil).Token()
+	var reauth *ReauthError
+	if !errors.As(err, &reauth) {
+		t.Fatalf("Expected a ReauthError, got %v", err)
+	}
+	if reauth.Reason != "invalid_grant: Token has been expired or revoked." {
+		t.Errorf("Unexpected reason %q", reauth.Reason)
+	}
+
+	// The account stays marked, without asking the provider again.
+	conf = tokenServer(t, func() { t.Error("Expected no refresh for an account needing reauth") },
+		http.StatusOK, `{"access_token":"new-access"}`)
+	if _, err := Source(context.Background(), conf, "revoked", nil).Token(); !errors.As(err, &reauth) {
+		t.Errorf("Expected a ReauthError for the marked account, got %v", err)
+	}
+}
+
+func TestReauthReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+		ok     bool
+	}{
+		{
+			name:   "invalid grant",
+			err:    &oauth2.RetrieveError{ErrorCode: "invalid_grant"},
+			reason: "invalid_grant",
+			ok:     true,
+		},
+		{
+			name: "microsoft trace lines",
+			err: fmt.Errorf("failed to refresh token: %w", &oauth2.RetrieveError{
+				ErrorCode:        "interaction_required",
+				ErrorDescription: "AADSTS50076: MFA required.\r\nTrace ID: 1\r\nCorrelation ID: 2",
+			}),
+			reason: "interaction_required: AADSTS50076: MFA required.",
+			ok:     true,
+		},
+		{
+			name: "transient",
+			err:  &oauth2.RetrieveError{ErrorCode: "temporarily_unavailable"},
+		},
+		{
+			name: "network",
+			err:  errors.New("connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := ReauthReason(tt.err)
+			if ok != tt.ok || reason != tt.reason {
+				t.Errorf("Expected (%q, %v), got (%q, %v)", tt.reason, tt.ok, reason, ok)
+			}
+		})
+	}
+}
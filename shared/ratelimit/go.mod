@@ -0,0 +1,9 @@
+module shared/ratelimit
+
+go 1.22.1
+
+require shared/database v0.0.0
+
+require github.com/mattn/go-sqlite3 v1.14.30 // indirect
+
+replace shared/database => ../database
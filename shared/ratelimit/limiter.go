@@ -0,0 +1,190 @@
+// Package ratelimit paces calls to calendar providers. Every provider gets
+// a token bucket per connected account. When the provider throttles a call
+// anyway, the account waits out Retry-After, its rate is halved, and the rate
+// recovers step by step as calls succeed. Calls are counted per provider,
+// account and hour in the provider_quota table, which the sync backend and
+// the watcher both add to.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned by calls the provider throttled. RetryAfter is
+// how long it asked to wait, or zero if it didn't say.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limited: " + e.Err.Error()
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// ParseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date. It returns zero when the header is missing or invalid.
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Config sets the pace of one provider's calls for each account.
+type Config struct {
+	// Rate is the steady number of calls per second.
+	Rate float64
+	// Burst is how many calls may go out at once after a quiet spell.
+	Burst int
+	// MaxRetries is how often a throttled call is retried.
+	MaxRetries int
+	// MaxWait is the longest a throttled call waits before a retry; longer
+	// Retry-After values fail the call instead.
+	MaxWait time.Duration
+}
+
+type bucket struct {
+	rate         float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	// strikes counts throttled calls in a row, for backing off when the
+	// provider doesn't send Retry-After.
+	strikes int
+}
+
+// Limiter holds the buckets of one provider's accounts.
+type Limiter struct {
+	name string
+	cfg  Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter(name string, cfg Config) *Limiter {
+	if cfg.Rate <= 0 {
+		cfg.Rate = 1
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	return &Limiter{name: name, cfg: cfg, buckets: make(map[string]*bucket)}
+}
+
+func (l *Limiter) bucket(account string, now time.Time) *bucket {
+	b, ok := l.buckets[account]
+	if !ok {
+		b = &bucket{rate: l.cfg.Rate, tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[account] = b
+	}
+	return b
+}
+
+// reserve takes a token from the account's bucket and returns how long the
+// caller has to wait before using it.
+func (l *Limiter) reserve(account string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.bucket(account, now)
+
+	b.tokens = min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if blocked := b.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	return wait
+}
+
+// throttled slows the account down after the provider refused a call, and
+// returns how long to wait before trying again.
+func (l *Limiter) throttled(account string, retryAfter time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.bucket(account, now)
+
+	b.rate = max(b.rate/2, l.cfg.Rate/16)
+	b.strikes++
+
+	backoff := retryAfter
+	if backoff <= 0 {
+		backoff = time.Second << min(b.strikes-1, 6)
+	}
+	if until := now.Add(backoff); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+	return backoff
+}
+
+// succeeded lets the account's rate recover towards the configured one.
+func (l *Limiter) succeeded(account string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(account, time.Now())
+	b.strikes = 0
+	b.rate = min(l.cfg.Rate, b.rate+l.cfg.Rate/10)
+}
+
+// Do runs call for the account once the rate allows, retrying it while the
+// provider throttles and the wait fits the context's deadline.
+func (l *Limiter) Do(ctx context.Context, account string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		wait := l.reserve(account)
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				usage.add(l.name, account, 0, 0, wait)
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		err := call()
+
+		var limited *RateLimitError
+		if !errors.As(err, &limited) {
+			l.succeeded(account)
+			usage.add(l.name, account, 1, 0, wait)
+			return err
+		}
+
+		usage.add(l.name, account, 1, 1, wait)
+		backoff := l.throttled(account, limited.RetryAfter)
+		if attempt >= l.cfg.MaxRetries || backoff > l.cfg.MaxWait {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+	}
+}
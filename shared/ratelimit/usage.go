@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"shared/database"
+)
+
+// usageRetention is how long hourly counts are kept.
+const usageRetention = 90 * 24 * time.Hour
+
+// quotaUsage counts the calls made to a provider for one account in one
+// hour. Throttled counts calls the provider refused for quota, and WaitMs
+// the time calls spent waiting for the rate limiter.
+type quotaUsage struct {
+	Provider  string
+	AccountID string
+	Hour      int64
+	Calls     int64
+	Throttled int64
+	WaitMs    int64
+}
+
+type usageKey struct {
+	provider string
+	account  string
+	hour     int64
+}
+
+// usageCounter collects counts in memory until they are flushed, so calls
+// don't each write to the database.
+type usageCounter struct {
+	mu     sync.Mutex
+	counts map[usageKey]*quotaUsage
+}
+
+var usage = &usageCounter{counts: make(map[usageKey]*quotaUsage)}
+
+func (u *usageCounter) add(provider, account string, calls, throttled int64, wait time.Duration) {
+	hour := time.Now().Truncate(time.Hour).Unix()
+	key := usageKey{provider: provider, account: account, hour: hour}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	count, ok := u.counts[key]
+	if !ok {
+		count = &quotaUsage{Provider: provider, AccountID: account, Hour: hour}
+		u.counts[key] = count
+	}
+	count.Calls += calls
+	count.Throttled += throttled
+	count.WaitMs += wait.Milliseconds()
+}
+
+// flush writes the collected counts. On failure they are kept for the next
+// attempt.
+func (u *usageCounter) flush() error {
+	u.mu.Lock()
+	counts := u.counts
+	u.counts = make(map[usageKey]*quotaUsage)
+	u.mu.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	batch := make([]quotaUsage, 0, len(counts))
+	for _, count := range counts {
+		batch = append(batch, *count)
+	}
+	if err := addQuotaUsage(batch); err != nil {
+		u.mu.Lock()
+		for key, count := range counts {
+			if current, ok := u.counts[key]; ok {
+				current.Calls += count.Calls
+				current.Throttled += count.Throttled
+				current.WaitMs += count.WaitMs
+			} else {
+				u.counts[key] = count
+			}
+		}
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// RunFlusher writes the call counts to the database once per interval and
+// drops counts older than the retention. It never returns.
+func RunFlusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for range ticker.C {
+		if err := usage.flush(); err != nil {
+			log.Printf("Failed to record provider quota usage: %v", err)
+		}
+		if time.Since(lastPrune) > 24*time.Hour {
+			if err := pruneQuotaUsage(time.Now().Add(-usageRetention).Unix()); err != nil {
+				log.Printf("Failed to prune provider quota usage: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// addQuotaUsage adds the counts to the stored totals of each provider,
+// account and hour.
+func addQuotaUsage(usage []quotaUsage) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, u := range usage {
+		_, err := tx.Exec(`
+			INSERT INTO provider_quota (provider, account_id, hour, calls, throttled, wait_ms)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (provider, account_id, hour) DO UPDATE SET
+				calls = calls + excluded.calls,
+				throttled = throttled + excluded.throttled,
+				wait_ms = wait_ms + excluded.wait_ms
+		`, u.Provider, u.AccountID, u.Hour, u.Calls, u.Throttled, u.WaitMs)
+		if err != nil {
+			return fmt.Errorf("failed to record quota usage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit quota usage: %w", err)
+	}
+	return nil
+}
+
+// pruneQuotaUsage deletes the counts of hours before the given time.
+func pruneQuotaUsage(before int64) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM provider_quota WHERE hour < ?", before); err != nil {
+		return fmt.Errorf("failed to prune quota usage: %w", err)
+	}
+	return nil
+}
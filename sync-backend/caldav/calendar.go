@@ -0,0 +1,710 @@
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"calendar-backend/ical"
+	"calendar-backend/netguard"
+	"calendar-backend/provider"
+)
+
+const ProviderName = "caldav"
+
+const prodID = "-//calendar-backend//CalDAV//EN"
+
+// Well-known servers users can pick instead of typing a URL.
+var KnownServers = map[string]string{
+	"icloud":   "https://caldav.icloud.com",
+	"fastmail": "https://caldav.fastmail.com",
+}
+
+// knownServerDomains maps known servers to the domain their accounts may
+// live under. iCloud answers discovery on caldav.icloud.com and points to
+// calendar homes on per-account hosts such as p42-caldav.icloud.com.
+var knownServerDomains = map[string]string{
+	"caldav.icloud.com": "icloud.com",
+}
+
+// Sync tokens carry the strategy they were made with: "sync:" wraps a
+// server sync-token for sync-collection REPORTs, "etag:" wraps the encoded
+// ctag and etags of every event for servers without sync-collection.
+const (
+	syncTokenPrefix = "sync:"
+	etagTokenPrefix = "etag:"
+)
+
+type etagState struct {
+	CTag  string            `json:"ctag"`
+	ETags map[string]string `json:"etags"`
+}
+
+type CalendarService struct {
+	httpClient *http.Client
+}
+
+var _ provider.Provider = (*CalendarService)(nil)
+
+func NewCalendarService() *CalendarService {
+	return &CalendarService{
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: netguard.Transport(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (s *CalendarService) getClient(creds provider.Credentials) *client {
+	c := &client{
+		http:     s.httpClient,
+		username: creds.Username,
+		password: creds.AccessToken,
+	}
+	if server, err := url.Parse(strings.TrimSpace(creds.ServerURL)); err == nil && server.Host != "" {
+		c.server = server
+	}
+	return c
+}
+
+// Discover checks the credentials and returns the principal URL of the
+// account, which identifies it across reconnects.
+func (s *CalendarService) Discover(ctx context.Context, creds provider.Credentials) (string, error) {
+	return s.findPrincipal(ctx, s.getClient(creds), creds.ServerURL)
+}
+
+func (s *CalendarService) findPrincipal(ctx context.Context, c *client, serverURL string) (string, error) {
+	base, err := url.Parse(strings.TrimSpace(serverURL))
+	if err != nil || base.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q", serverURL)
+	}
+
+	candidates := []string{base.String()}
+	if base.Path == "" || base.Path == "/" {
+		// RFC 6764: bare hosts advertise their context path here.
+		wellKnown := *base
+		wellKnown.Path = "/.well-known/caldav"
+		candidates = []string{wellKnown.String(), base.String()}
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:current-user-principal/></d:prop></d:propfind>`
+
+	var lastErr error
+	for _, candidate := range candidates {
+		ms, err := c.multistatus(ctx, "PROPFIND", candidate, "0", body)
+		if err != nil {
+			lastErr = err
+			if isStatus(err, http.StatusUnauthorized, http.StatusForbidden) {
+				break
+			}
+			continue
+		}
+		for _, r := range ms.Responses {
+			if p := r.okProp(); p != nil && p.CurrentUserPrincipal != nil && p.CurrentUserPrincipal.Href != "" {
+				return p.CurrentUserPrincipal.Href, nil
+			}
+		}
+		lastErr = fmt.Errorf("%s did not report a current-user-principal", candidate)
+	}
+
+	return "", fmt.Errorf("failed to discover principal: %w", lastErr)
+}
+
+func (s *CalendarService) findCalendarHome(ctx context.Context, c *client, principal string) (string, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><c:calendar-home-set/></d:prop></d:propfind>`
+
+	ms, err := c.multistatus(ctx, "PROPFIND", principal, "0", body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get calendar home: %w", err)
+	}
+	for _, r := range ms.Responses {
+		if p := r.okProp(); p != nil && p.CalendarHomeSet != nil && p.CalendarHomeSet.Href != "" {
+			return p.CalendarHomeSet.Href, nil
+		}
+	}
+	return "", fmt.Errorf("principal %s has no calendar-home-set", principal)
+}
+
+// ListCalendars returns the collections in the calendar home that can hold
+// events. Calendar IDs are the absolute collection URLs.
+func (s *CalendarService) ListCalendars(ctx context.Context, creds provider.Credentials) ([]provider.AvailableCalendar, error) {
+	c := s.getClient(creds)
+
+	principal, err := s.findPrincipal(ctx, c, creds.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	home, err := s.findCalendarHome(ctx, c, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:a="http://apple.com/ns/ical/">
+  <d:prop><d:resourcetype/><d:displayname/><a:calendar-color/><c:supported-calendar-component-set/></d:prop>
+</d:propfind>`
+
+	ms, err := c.multistatus(ctx, "PROPFIND", home, "1", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendars: %w", err)
+	}
+
+	var calendars []provider.AvailableCalendar
+	for _, r := range ms.Responses {
+		p := r.okProp()
+		if p == nil || p.ResourceType.Calendar == nil || !supportsEvents(p) {
+			continue
+		}
+
+		cal := provider.AvailableCalendar{
+			ProviderCalendarID: r.Href,
+			Name:               p.DisplayName,
+			IsPrimary:          len(calendars) == 0,
+		}
+		if cal.Name == "" {
+			cal.Name = lastSegment(r.Href)
+		}
+		if p.CalendarColor != "" {
+			// Apple stores #RRGGBBAA; the rest of the app expects #RRGGBB.
+			color := p.CalendarColor
+			if len(color) == 9 {
+				color = color[:7]
+			}
+			cal.Color = &color
+		}
+		calendars = append(calendars, cal)
+	}
+
+	return calendars, nil
+}
+
+func supportsEvents(p *prop) bool {
+	// Servers that don't report the set accept every component type.
+	if len(p.SupportedComponents.Comps) == 0 {
+		return true
+	}
+	for _, comp := range p.SupportedComponents.Comps {
+		if strings.EqualFold(comp.Name, "VEVENT") {
+			return true
+		}
+	}
+	return false
+}
+
+func lastSegment(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	name, _ := url.PathUnescape(segments[len(segments)-1])
+	return name
+}
+
+// ListEvents uses a sync-collection REPORT when the server supports it and
+// falls back to comparing the collection ctag and event etags otherwise.
+// Event IDs are the absolute URLs of the event resources.
+func (s *CalendarService) ListEvents(ctx context.Context, creds provider.Credentials, calendarID string, syncToken *string) (*provider.EventsResult, error) {
+	c := s.getClient(creds)
+
+	token := ""
+	if syncToken != nil {
+		token = *syncToken
+	}
+
+	switch {
+	case strings.HasPrefix(token, syncTokenPrefix):
+		result, err := s.syncCollection(ctx, c, calendarID, strings.TrimPrefix(token, syncTokenPrefix))
+		// An expired or unknown token is reported as 403 (valid-sync-token
+		// precondition), 409 or 410 depending on the server.
+		if isStatus(err, http.StatusForbidden, http.StatusConflict, http.StatusGone) {
+			return s.fullSync(ctx, c, calendarID)
+		}
+		return result, err
+
+	case strings.HasPrefix(token, etagTokenPrefix):
+		state, err := decodeEtagState(strings.TrimPrefix(token, etagTokenPrefix))
+		if err != nil {
+			return s.fullSync(ctx, c, calendarID)
+		}
+		return s.etagSync(ctx, c, calendarID, state)
+	}
+
+	return s.fullSync(ctx, c, calendarID)
+}
+
+func (s *CalendarService) collectionState(ctx context.Context, c *client, calendarURL string) (*prop, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:prop><d:sync-token/><cs:getctag/></d:prop></d:propfind>`
+
+	ms, err := c.multistatus(ctx, "PROPFIND", calendarURL, "0", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get calendar state: %w", err)
+	}
+	for _, r := range ms.Responses {
+		if p := r.okProp(); p != nil {
+			return p, nil
+		}
+	}
+	return &prop{}, nil
+}
+
+// fullSync fetches every event and picks the cheapest strategy the server
+// supports for the next run.
+func (s *CalendarService) fullSync(ctx context.Context, c *client, calendarURL string) (*provider.EventsResult, error) {
+	state, err := s.collectionState(ctx, c, calendarURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/><c:calendar-data/></d:prop>
+  <c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT"/></c:comp-filter></c:filter>
+</c:calendar-query>`
+
+	ms, err := c.multistatus(ctx, "REPORT", calendarURL, "1", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch events: %w", err)
+	}
+
+	result := &provider.EventsResult{
+		Events: []provider.CalendarEvent{},
+		Full:   true,
+	}
+	etags := make(map[string]string)
+
+	for _, r := range ms.Responses {
+		p := r.okProp()
+		if p == nil || sameResource(r.Href, calendarURL) {
+			continue
+		}
+		etags[r.Href] = p.ETag
+
+		event, err := convertResource(r.Href, p.ETag, p.CalendarData)
+		if err != nil {
+			logSkipped(err)
+			continue
+		}
+		result.Events = append(result.Events, event)
+	}
+
+	if state.SyncToken != "" {
+		result.NextSyncToken = syncTokenPrefix + state.SyncToken
+	} else {
+		result.NextSyncToken = etagTokenPrefix + encodeEtagState(etagState{CTag: state.CTag, ETags: etags})
+	}
+
+	return result, nil
+}
+
+func (s *CalendarService) syncCollection(ctx context.Context, c *client, calendarURL, token string) (*provider.EventsResult, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:sync-token>`)
+	xmlEscape(&body, token)
+	body.WriteString(`</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/><c:calendar-data/></d:prop></d:sync-collection>`)
+
+	ms, err := c.multistatus(ctx, "REPORT", calendarURL, "0", body.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync collection: %w", err)
+	}
+
+	result := &provider.EventsResult{
+		Events:        []provider.CalendarEvent{},
+		NextSyncToken: syncTokenPrefix + ms.SyncToken,
+	}
+	if ms.SyncToken == "" {
+		result.NextSyncToken = syncTokenPrefix + token
+	}
+
+	var missing []string
+	for _, r := range ms.Responses {
+		if sameResource(r.Href, calendarURL) {
+			continue
+		}
+		if statusNotFound(r.Status) {
+			result.Events = append(result.Events, cancelledEvent(r.Href))
+			continue
+		}
+
+		p := r.okProp()
+		if p == nil {
+			continue
+		}
+		// Some servers (iCloud among them) only return etags here.
+		if p.CalendarData == "" {
+			missing = append(missing, r.Href)
+			continue
+		}
+
+		event, err := convertResource(r.Href, p.ETag, p.CalendarData)
+		if err != nil {
+			logSkipped(err)
+			continue
+		}
+		result.Events = append(result.Events, event)
+	}
+
+	fetched, err := s.multiget(ctx, c, calendarURL, missing)
+	if err != nil {
+		return nil, err
+	}
+	result.Events = append(result.Events, fetched...)
+
+	return result, nil
+}
+
+func (s *CalendarService) etagSync(ctx context.Context, c *client, calendarURL string, previous *etagState) (*provider.EventsResult, error) {
+	state, err := s.collectionState(ctx, c, calendarURL)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &provider.EventsResult{
+		Events: []provider.CalendarEvent{},
+	}
+
+	if state.CTag != "" && state.CTag == previous.CTag {
+		result.NextSyncToken = etagTokenPrefix + encodeEtagState(*previous)
+		return result, nil
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`
+
+	ms, err := c.multistatus(ctx, "PROPFIND", calendarURL, "1", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list event etags: %w", err)
+	}
+
+	current := make(map[string]string)
+	var changed []string
+	for _, r := range ms.Responses {
+		p := r.okProp()
+		if p == nil || sameResource(r.Href, calendarURL) {
+			continue
+		}
+		current[r.Href] = p.ETag
+		if previous.ETags[r.Href] != p.ETag || p.ETag == "" {
+			changed = append(changed, r.Href)
+		}
+	}
+
+	for href := range previous.ETags {
+		if _, ok := current[href]; !ok {
+			result.Events = append(result.Events, cancelledEvent(href))
+		}
+	}
+
+	fetched, err := s.multiget(ctx, c, calendarURL, changed)
+	if err != nil {
+		return nil, err
+	}
+	result.Events = append(result.Events, fetched...)
+
+	result.NextSyncToken = etagTokenPrefix + encodeEtagState(etagState{CTag: state.CTag, ETags: current})
+	return result, nil
+}
+
+func (s *CalendarService) multiget(ctx context.Context, c *client, calendarURL string, hrefs []string) ([]provider.CalendarEvent, error) {
+	if len(hrefs) == 0 {
+		return nil, nil
+	}
+
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/><c:calendar-data/></d:prop>`)
+	for _, href := range hrefs {
+		body.WriteString("<d:href>")
+		xmlEscape(&body, hrefPath(href))
+		body.WriteString("</d:href>")
+	}
+	body.WriteString(`</c:calendar-multiget>`)
+
+	ms, err := c.multistatus(ctx, "REPORT", calendarURL, "1", body.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch changed events: %w", err)
+	}
+
+	var events []provider.CalendarEvent
+	for _, r := range ms.Responses {
+		if statusNotFound(r.Status) {
+			events = append(events, cancelledEvent(r.Href))
+			continue
+		}
+		p := r.okProp()
+		if p == nil {
+			continue
+		}
+		event, err := convertResource(r.Href, p.ETag, p.CalendarData)
+		if err != nil {
+			logSkipped(err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *CalendarService) Watch(ctx context.Context, creds provider.Credentials, calendarID string, req provider.WatchRequest) (*provider.Channel, error) {
+	// CalDAV has no push channels; eventsync polls these calendars.
+	return nil, provider.ErrNotSupported
+}
+
+func (s *CalendarService) CreateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	uid, err := newUID()
+	if err != nil {
+		return nil, err
+	}
+
+	cal := ical.NewCalendar(prodID)
+	vevent := toICalEvent(event)
+	vevent.UID = uid
+	cal.Children = append(cal.Children, ical.EventComponent(vevent))
+
+	href := strings.TrimSuffix(calendarID, "/") + "/" + uid + ".ics"
+
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+
+	etag, err := s.put(ctx, s.getClient(creds), href, header, cal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event: %w", err)
+	}
+
+	created := event
+	created.ProviderEventID = href
+	created.Etag = etag
+	return &created, nil
+}
+
+// UpdateEvent rewrites the main VEVENT of the resource and keeps everything
+// else in it, such as time zones and modified occurrences.
+func (s *CalendarService) UpdateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	c := s.getClient(creds)
+
+	resp, _, err := c.do(ctx, http.MethodGet, event.ProviderEventID, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w", err)
+	}
+	cal, err := ical.Parse(resp.Body)
+	currentEtag := resp.Header.Get("ETag")
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+
+	master := masterEvent(cal)
+	if master == nil {
+		return nil, fmt.Errorf("event %s has no VEVENT", event.ProviderEventID)
+	}
+	existing, err := ical.ParseEvent(cal, master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+
+	vevent := toICalEvent(event)
+	vevent.UID = existing.UID
+	vevent.Sequence = existing.Sequence + 1
+	for i, child := range cal.Children {
+		if child == master {
+			cal.Children[i] = ical.EventComponent(vevent)
+		}
+	}
+
+	ifMatch := event.Etag
+	if ifMatch == "" {
+		ifMatch = currentEtag
+	}
+	header := http.Header{}
+	if ifMatch != "" {
+		header.Set("If-Match", ifMatch)
+	}
+
+	etag, err := s.put(ctx, c, event.ProviderEventID, header, cal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+
+	updated := event
+	updated.Etag = etag
+	return &updated, nil
+}
+
+func (s *CalendarService) DeleteEvent(ctx context.Context, creds provider.Credentials, calendarID, eventID string) error {
+	resp, _, err := s.getClient(creds).do(ctx, http.MethodDelete, eventID, nil, "")
+	if err != nil {
+		if isStatus(err, http.StatusNotFound, http.StatusGone) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RefreshCredentials is a no-op: app passwords don't expire.
+func (s *CalendarService) RefreshCredentials(ctx context.Context, creds provider.Credentials) (*provider.Credentials, error) {
+	return &creds, nil
+}
+
+func (s *CalendarService) put(ctx context.Context, c *client, href string, header http.Header, cal *ical.Component) (string, error) {
+	var body bytes.Buffer
+	if err := ical.Encode(&body, cal); err != nil {
+		return "", err
+	}
+
+	header.Set("Content-Type", "text/calendar; charset=utf-8")
+
+	resp, _, err := c.do(ctx, http.MethodPut, href, header, body.String())
+	if err != nil {
+		return "", err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// masterEvent returns the VEVENT without a RECURRENCE-ID, or the first one
+// when every VEVENT is an override.
+func masterEvent(cal *ical.Component) *ical.Component {
+	vevents := cal.ChildrenNamed("VEVENT")
+	for _, vevent := range vevents {
+		if vevent.Prop("RECURRENCE-ID") == nil {
+			return vevent
+		}
+	}
+	if len(vevents) > 0 {
+		return vevents[0]
+	}
+	return nil
+}
+
+func convertResource(href, etag, data string) (provider.CalendarEvent, error) {
+	cal, err := ical.Parse(strings.NewReader(data))
+	if err != nil {
+		return provider.CalendarEvent{}, fmt.Errorf("failed to parse %s: %w", href, err)
+	}
+
+	master := masterEvent(cal)
+	if master == nil {
+		return provider.CalendarEvent{}, fmt.Errorf("%s has no VEVENT", href)
+	}
+	event, err := ical.ParseEvent(cal, master)
+	if err != nil {
+		return provider.CalendarEvent{}, fmt.Errorf("failed to parse %s: %w", href, err)
+	}
+
+	calEvent := provider.CalendarEvent{
+		ProviderEventID: href,
+		Title:           event.Summary,
+		Description:     event.Description,
+		Location:        event.Location,
+		StartTime:       event.Start.Unix(),
+		EndTime:         event.End.Unix(),
+		IsAllDay:        event.AllDay,
+		Status:          event.Status,
+		Recurrence:      strings.Join(event.Recurrence, "\n"),
+		Etag:            etag,
+		RawData:         data,
+	}
+
+	if len(event.Attendees) > 0 {
+		if encoded, err := json.Marshal(event.Attendees); err == nil {
+			calEvent.Attendees = string(encoded)
+		}
+	}
+
+	return calEvent, nil
+}
+
+// logSkipped reports an event left out of a sync because it couldn't be
+// parsed. One bad resource shouldn't keep the rest of the calendar from
+// syncing; it's picked up again once its etag changes.
+func logSkipped(err error) {
+	log.Printf("Skipping CalDAV event: %v", err)
+}
+
+func cancelledEvent(href string) provider.CalendarEvent {
+	return provider.CalendarEvent{
+		ProviderEventID: href,
+		Status:          "cancelled",
+	}
+}
+
+func toICalEvent(event provider.CalendarEvent) ical.Event {
+	vevent := ical.Event{
+		Summary:     event.Title,
+		Description: event.Description,
+		Location:    event.Location,
+		Status:      event.Status,
+		Start:       time.Unix(event.StartTime, 0),
+		End:         time.Unix(event.EndTime, 0),
+		AllDay:      event.IsAllDay,
+	}
+	if event.Recurrence != "" {
+		vevent.Recurrence = strings.Split(event.Recurrence, "\n")
+	}
+	if event.Attendees != "" {
+		json.Unmarshal([]byte(event.Attendees), &vevent.Attendees)
+	}
+	return vevent
+}
+
+func newUID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func encodeEtagState(state etagState) string {
+	data, _ := json.Marshal(state)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeEtagState(token string) (*etagState, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var state etagState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// hrefPath strips scheme and host from a resolved href; servers expect
+// multiget hrefs as absolute paths.
+func hrefPath(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Host == "" {
+		return href
+	}
+	return u.EscapedPath()
+}
+
+func xmlEscape(b *bytes.Buffer, s string) {
+	xml.EscapeText(b, []byte(s))
+}
@@ -0,0 +1,256 @@
+package caldav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"calendar-backend/provider"
+	"shared/ratelimit"
+)
+
+const maxRedirects = 5
+
+// StatusError is a non-2xx response from the CalDAV server.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned %d", e.Method, e.URL, e.StatusCode)
+}
+
+func isStatus(err error, codes ...int) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	for _, code := range codes {
+		if statusErr.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
+	SyncToken string     `xml:"DAV: sync-token"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Status    string     `xml:"DAV: status"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type hrefProp struct {
+	Href string `xml:"DAV: href"`
+}
+
+type compProp struct {
+	Name string `xml:"name,attr"`
+}
+
+type prop struct {
+	CurrentUserPrincipal *hrefProp `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      *hrefProp `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	ResourceType         struct {
+		Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+	} `xml:"DAV: resourcetype"`
+	SupportedComponents struct {
+		Comps []compProp `xml:"urn:ietf:params:xml:ns:caldav comp"`
+	} `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-component-set"`
+	DisplayName   string `xml:"DAV: displayname"`
+	CalendarColor string `xml:"http://apple.com/ns/ical/ calendar-color"`
+	CTag          string `xml:"http://calendarserver.org/ns/ getctag"`
+	SyncToken     string `xml:"DAV: sync-token"`
+	ETag          string `xml:"DAV: getetag"`
+	CalendarData  string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+// okProp returns the properties the server reported with a 2xx status.
+func (r *response) okProp() *prop {
+	for i := range r.Propstats {
+		if statusOK(r.Propstats[i].Status) {
+			return &r.Propstats[i].Prop
+		}
+	}
+	return nil
+}
+
+// statusOK reads an "HTTP/1.1 200 OK" status line.
+func statusOK(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && strings.HasPrefix(fields[1], "2")
+}
+
+func statusNotFound(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && fields[1] == "404"
+}
+
+type client struct {
+	http     *http.Client
+	username string
+	password string
+	// server is the account's configured server URL. Credentials are only
+	// sent to its origin.
+	server *url.URL
+}
+
+// trusted reports whether u is on the configured server's origin, or on
+// another host of a known provider that spreads accounts over several.
+func (c *client) trusted(u *url.URL) bool {
+	if c.server == nil || u.Scheme != c.server.Scheme || port(u) != port(c.server) {
+		return false
+	}
+	host, server := strings.ToLower(u.Hostname()), strings.ToLower(c.server.Hostname())
+	if host == server {
+		return true
+	}
+	domain := knownServerDomains[server]
+	return domain != "" && strings.HasSuffix(host, "."+domain)
+}
+
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// do sends a request, with basic auth when it goes to the configured
+// server. Redirects are followed with the original method and body, which
+// net/http would turn into a GET for PROPFIND and REPORT, but only within
+// the server's origin. The returned URL is where the response came from.
+func (c *client) do(ctx context.Context, method, target string, header http.Header, body string) (*http.Response, *url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := 0; ; i++ {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, nil, fmt.Errorf("%s %s: unsupported URL scheme", method, u.Redacted())
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if body != "" && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+		}
+		if c.trusted(u) {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			resp.Body.Close()
+			if i == maxRedirects {
+				return nil, nil, fmt.Errorf("%s %s: too many redirects", method, target)
+			}
+			next, err := u.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid redirect from %s: %w", u, err)
+			}
+			if !c.trusted(next) {
+				return nil, nil, fmt.Errorf("%s %s: refusing redirect to %s", method, u, next.Redacted())
+			}
+			u = next
+			continue
+		}
+
+		if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			statusErr := &StatusError{Method: method, URL: u.String(), StatusCode: resp.StatusCode}
+			if resp.StatusCode == http.StatusTooManyRequests ||
+				resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "" {
+				return nil, nil, &provider.RateLimitError{RetryAfter: ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After")), Err: statusErr}
+			}
+			return nil, nil, statusErr
+		}
+
+		return resp, u, nil
+	}
+}
+
+// multistatus sends a PROPFIND or REPORT and decodes the 207 response. Hrefs
+// are resolved to absolute URLs.
+func (c *client) multistatus(ctx context.Context, method, target, depth, body string) (*multistatus, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+
+	resp, base, err := c.do(ctx, method, target, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms multistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("invalid multistatus from %s: %w", base, err)
+	}
+
+	for i := range ms.Responses {
+		ms.Responses[i].Href = resolve(base, ms.Responses[i].Href)
+		if p := ms.Responses[i].okProp(); p != nil {
+			if p.CurrentUserPrincipal != nil {
+				p.CurrentUserPrincipal.Href = resolve(base, p.CurrentUserPrincipal.Href)
+			}
+			if p.CalendarHomeSet != nil {
+				p.CalendarHomeSet.Href = resolve(base, p.CalendarHomeSet.Href)
+			}
+		}
+	}
+
+	return &ms, nil
+}
+
+func resolve(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// sameResource compares two resolved hrefs, ignoring a trailing slash.
+func sameResource(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
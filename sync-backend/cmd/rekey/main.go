@@ -0,0 +1,46 @@
+// Command rekey re-encrypts the stored OAuth tokens and CalDAV account
+// passwords with the first key in TOKEN_ENCRYPTION_KEYS. Run it after adding
+// a new key in front of the list; once it reports nothing left to do, the old
+// keys can be removed. It also encrypts rows stored before encryption was
+// configured.
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+
+	"github.com/joho/godotenv"
+
+	"calendar-backend/database"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count the rows that would be re-encrypted")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found, using environment variables")
+	}
+
+	counts, err := database.RekeyTokens(*dryRun)
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		n := counts[table]
+		if *dryRun {
+			log.Printf("%s: %d rows to re-encrypt", table, n)
+		} else {
+			log.Printf("%s: %d rows re-encrypted", table, n)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Rekey failed: %v", err)
+	}
+	if len(counts) == 0 {
+		log.Printf("All tokens are encrypted with the current key")
+	}
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"calendar-backend/config"
+	"calendar-backend/eventsync"
+	"calendar-backend/freebusy"
 	"calendar-backend/handler"
+	"calendar-backend/ics"
+	"calendar-backend/netguard"
 	"calendar-backend/router"
+	shareddb "shared/database"
+	"shared/jwt"
+	"shared/ratelimit"
 )
 
 func main() {
@@ -16,8 +24,24 @@ func main() {
 	}
 
 	cfg := config.LoadConfig()
+	netguard.AllowPrivate(cfg.AllowPrivateURLs)
 
-	handler.InitCalendarService()
+	if err := jwt.Setup(); err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+
+	db, err := shareddb.GetDB()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	jwt.SetRevocationStore(jwt.NewSQLRevocationStore(db))
+
+	handler.InitProviders()
+
+	go ics.RunPoller(cfg.ICSPollInterval)
+	go freebusy.RunPoller(cfg.FreeBusyPollInterval)
+	go eventsync.RunPoller(cfg.EventPollInterval)
+	go ratelimit.RunFlusher(time.Minute)
 
 	r := router.SetupRouter()
 	log.Printf("Server running on port %s", cfg.Port)
@@ -1,19 +1,47 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	Port               string
+	PublicURL          string
 	AllowedOrigins     []string
 	DatabasePath       string
 	Environment        string
 	FrontendDir        string
 	FrontendURL        string
+	AuthServerURL      string
 	GoogleClientID     string
 	GoogleClientSecret string
+
+	OutlookClientID     string
+	OutlookClientSecret string
+	OutlookRedirectURL  string
+	OutlookAuthURL      string
+	OutlookTokenURL     string
+	GraphBaseURL        string
+
+	// AllowPrivateURLs lets feed subscriptions and CalDAV accounts use
+	// servers on the backend's own network.
+	AllowPrivateURLs bool
+
+	// WatcherSecret is the bearer token the watcher sends with the event
+	// changes it forwards. The routes are disabled when it is empty.
+	WatcherSecret string
+
+	ICSPollInterval      time.Duration
+	FreeBusyPollInterval time.Duration
+	EventPollInterval    time.Duration
+
+	ProviderRateLimit    float64
+	ProviderRateBurst    int
+	ProviderMaxRetryWait time.Duration
 }
 
 var Cfg *Config
@@ -25,16 +53,75 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s must be a positive duration, got %q", key, val)
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s must be a positive integer, got %q", key, val)
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f <= 0 {
+		log.Fatalf("%s must be a positive number, got %q", key, val)
+	}
+	return f
+}
+
 func LoadConfig() *Config {
+	port := getEnv("SYNC_BACKEND_PORT", "8080")
+
 	Cfg = &Config{
-		Port:               getEnv("SYNC_BACKEND_PORT", "8080"),
+		Port:               port,
+		PublicURL:          strings.TrimRight(getEnv("SYNC_BACKEND_PUBLIC_URL", "http://localhost:"+port), "/"),
 		AllowedOrigins:     strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:5173"), ","),
 		DatabasePath:       getEnv("DATABASE_PATH", "../data.db"),
 		Environment:        getEnv("GO_ENV", "development"),
 		FrontendDir:        getEnv("FRONTEND_DIR", "../frontend/dist"),
 		FrontendURL:        getEnv("FRONTEND_URL", "http://localhost:5173"),
+		AuthServerURL:      strings.TrimRight(getEnv("AUTH_SERVER_URL", "http://localhost:3000"), "/"),
 		GoogleClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
 		GoogleClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+
+		OutlookClientID:     os.Getenv("OUTLOOK_CLIENT_ID"),
+		OutlookClientSecret: os.Getenv("OUTLOOK_CLIENT_SECRET"),
+		OutlookRedirectURL:  getEnv("OUTLOOK_REDIRECT_URL", "http://localhost:8080/api/connect/outlook/callback"),
+		OutlookAuthURL:      getEnv("OUTLOOK_AUTH_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/authorize"),
+		OutlookTokenURL:     getEnv("OUTLOOK_TOKEN_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/token"),
+		GraphBaseURL:        strings.TrimRight(getEnv("GRAPH_BASE_URL", "https://graph.microsoft.com/v1.0"), "/"),
+
+		AllowPrivateURLs: getEnv("ALLOW_PRIVATE_URLS", "false") == "true",
+
+		WatcherSecret: os.Getenv("WATCHER_BACKEND_SECRET"),
+
+		ICSPollInterval:      getEnvDuration("ICS_POLL_INTERVAL", 30*time.Minute),
+		FreeBusyPollInterval: getEnvDuration("FREEBUSY_POLL_INTERVAL", 15*time.Minute),
+		EventPollInterval:    getEnvDuration("EVENT_POLL_INTERVAL", 5*time.Minute),
+
+		ProviderRateLimit:    getEnvFloat("PROVIDER_RATE_LIMIT", 5),
+		ProviderRateBurst:    getEnvInt("PROVIDER_RATE_BURST", 10),
+		ProviderMaxRetryWait: getEnvDuration("PROVIDER_MAX_RETRY_WAIT", 30*time.Second),
 	}
 	return Cfg
 }
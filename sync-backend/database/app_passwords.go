@@ -0,0 +1,184 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	shareddb "shared/database"
+)
+
+// AppPassword lets a CalDAV client sign in as the user. Like feed tokens,
+// passwords are random and only their hash is stored; the password is shown
+// once, when it is created.
+type AppPassword struct {
+	ID         string
+	UserID     string
+	Name       string
+	LastUsedAt *int64
+	CreatedAt  int64
+}
+
+const appPasswordColumns = `id, user_id, name, last_used_at, created_at`
+
+// hashAppPassword ignores the dashes and case of the displayed form, so the
+// password can be typed either way.
+func hashAppPassword(password string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(password), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanAppPassword(row rowScanner) (*AppPassword, error) {
+	var ap AppPassword
+	var lastUsedAt sql.NullInt64
+
+	err := row.Scan(&ap.ID, &ap.UserID, &ap.Name, &lastUsedAt, &ap.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		ap.LastUsedAt = &lastUsedAt.Int64
+	}
+
+	return &ap, nil
+}
+
+// CreateAppPassword stores a new app password and returns its ID and the
+// password, formatted as four dash-separated groups.
+func CreateAppPassword(userId, name string) (string, string, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate app password: %w", err)
+	}
+	raw := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	password := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+	id := generateID()
+	now := time.Now().Unix()
+
+	_, err = db.Exec(`
+		INSERT INTO app_passwords (id, user_id, name, password_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, userId, name, hashAppPassword(password), now)
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create app password: %w", err)
+	}
+
+	return id, password, nil
+}
+
+// CheckAppPassword returns the user's app password matching password, or nil
+// if there is none.
+func CheckAppPassword(userId, password string) (*AppPassword, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ap, err := scanAppPassword(db.QueryRow(`
+		SELECT `+appPasswordColumns+`
+		FROM app_passwords
+		WHERE user_id = ? AND password_hash = ?
+	`, userId, hashAppPassword(password)))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get app password: %w", err)
+	}
+
+	return ap, nil
+}
+
+func GetAppPasswordById(id string) (*AppPassword, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ap, err := scanAppPassword(db.QueryRow(`
+		SELECT `+appPasswordColumns+`
+		FROM app_passwords
+		WHERE id = ?
+	`, id))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get app password: %w", err)
+	}
+
+	return ap, nil
+}
+
+func GetAppPasswordsByUserId(userId string) ([]AppPassword, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+appPasswordColumns+`
+		FROM app_passwords
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query app passwords: %w", err)
+	}
+	defer rows.Close()
+
+	var passwords []AppPassword
+	for rows.Next() {
+		ap, err := scanAppPassword(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan app password: %w", err)
+		}
+		passwords = append(passwords, *ap)
+	}
+
+	return passwords, rows.Err()
+}
+
+func TouchAppPassword(id string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec("UPDATE app_passwords SET last_used_at = ? WHERE id = ?", time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update app password: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteAppPassword(id string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec("DELETE FROM app_passwords WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete app password: %w", err)
+	}
+
+	return nil
+}
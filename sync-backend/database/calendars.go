@@ -21,25 +21,87 @@ type Calendar struct {
 	WebhookChannelID   *string
 	WebhookExpiry      *int64
 	SyncToken          *string
+	SyncMode           *string
+	FeedETag           *string
+	FeedLastModified   *string
+	FeedCheckedAt      *int64
+	FeedError          *string
 	IsActive           bool
 	CreatedAt          int64
 	UpdatedAt          int64
 }
 
-func GetCalendarsByUserId(userId string) ([]Calendar, error) {
+const calendarColumns = `
+	id, user_id, connected_account_id, provider, provider_calendar_id,
+	name, color, is_primary, webhook_resource_id, webhook_channel_id,
+	webhook_expiry, sync_token, sync_mode, feed_etag, feed_last_modified,
+	feed_checked_at, feed_error, is_active, created_at, updated_at
+`
+
+func scanCalendar(row rowScanner) (*Calendar, error) {
+	var cal Calendar
+	var connectedAccountID, color, webhookResourceID, webhookChannelID, syncToken, syncMode sql.NullString
+	var feedETag, feedLastModified, feedError sql.NullString
+	var webhookExpiry, feedCheckedAt sql.NullInt64
+	var isPrimary, isActive int
+
+	err := row.Scan(
+		&cal.ID, &cal.UserID, &connectedAccountID, &cal.Provider, &cal.ProviderCalendarID,
+		&cal.Name, &color, &isPrimary, &webhookResourceID, &webhookChannelID,
+		&webhookExpiry, &syncToken, &syncMode, &feedETag, &feedLastModified,
+		&feedCheckedAt, &feedError, &isActive, &cal.CreatedAt, &cal.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cal.IsPrimary = isPrimary == 1
+	cal.IsActive = isActive == 1
+
+	if connectedAccountID.Valid {
+		cal.ConnectedAccountID = &connectedAccountID.String
+	}
+	if color.Valid {
+		cal.Color = &color.String
+	}
+	if webhookResourceID.Valid {
+		cal.WebhookResourceID = &webhookResourceID.String
+	}
+	if webhookChannelID.Valid {
+		cal.WebhookChannelID = &webhookChannelID.String
+	}
+	if webhookExpiry.Valid {
+		cal.WebhookExpiry = &webhookExpiry.Int64
+	}
+	if syncToken.Valid {
+		cal.SyncToken = &syncToken.String
+	}
+	if syncMode.Valid {
+		cal.SyncMode = &syncMode.String
+	}
+	if feedETag.Valid {
+		cal.FeedETag = &feedETag.String
+	}
+	if feedLastModified.Valid {
+		cal.FeedLastModified = &feedLastModified.String
+	}
+	if feedCheckedAt.Valid {
+		cal.FeedCheckedAt = &feedCheckedAt.Int64
+	}
+	if feedError.Valid {
+		cal.FeedError = &feedError.String
+	}
+
+	return &cal, nil
+}
+
+func queryCalendars(query string, args ...any) ([]Calendar, error) {
 	db, err := shareddb.GetDB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
 
-	rows, err := db.Query(`
-		SELECT id, user_id, connected_account_id, provider, provider_calendar_id,
-		       name, color, is_primary, webhook_resource_id, webhook_channel_id,
-		       webhook_expiry, sync_token, is_active, created_at, updated_at
-		FROM calendars
-		WHERE user_id = ? AND is_active = 1
-		ORDER BY is_primary DESC, name ASC
-	`, userId)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query calendars: %w", err)
 	}
@@ -47,46 +109,33 @@ func GetCalendarsByUserId(userId string) ([]Calendar, error) {
 
 	var calendars []Calendar
 	for rows.Next() {
-		var cal Calendar
-		var connectedAccountID, color, webhookResourceID, webhookChannelID, syncToken sql.NullString
-		var webhookExpiry sql.NullInt64
-		var isPrimary, isActive int
-
-		err := rows.Scan(
-			&cal.ID, &cal.UserID, &connectedAccountID, &cal.Provider, &cal.ProviderCalendarID,
-			&cal.Name, &color, &isPrimary, &webhookResourceID, &webhookChannelID,
-			&webhookExpiry, &syncToken, &isActive, &cal.CreatedAt, &cal.UpdatedAt,
-		)
+		cal, err := scanCalendar(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan calendar: %w", err)
 		}
+		calendars = append(calendars, *cal)
+	}
 
-		cal.IsPrimary = isPrimary == 1
-		cal.IsActive = isActive == 1
-
-		if connectedAccountID.Valid {
-			cal.ConnectedAccountID = &connectedAccountID.String
-		}
-		if color.Valid {
-			cal.Color = &color.String
-		}
-		if webhookResourceID.Valid {
-			cal.WebhookResourceID = &webhookResourceID.String
-		}
-		if webhookChannelID.Valid {
-			cal.WebhookChannelID = &webhookChannelID.String
-		}
-		if webhookExpiry.Valid {
-			cal.WebhookExpiry = &webhookExpiry.Int64
-		}
-		if syncToken.Valid {
-			cal.SyncToken = &syncToken.String
-		}
+	return calendars, rows.Err()
+}
 
-		calendars = append(calendars, cal)
-	}
+func GetCalendarsByUserId(userId string) ([]Calendar, error) {
+	return queryCalendars(`
+		SELECT `+calendarColumns+`
+		FROM calendars
+		WHERE user_id = ? AND is_active = 1
+		ORDER BY is_primary DESC, name ASC
+	`, userId)
+}
 
-	return calendars, nil
+// GetCalendarsByProvider returns the active calendars of one provider across
+// all users.
+func GetCalendarsByProvider(provider string) ([]Calendar, error) {
+	return queryCalendars(`
+		SELECT `+calendarColumns+`
+		FROM calendars
+		WHERE provider = ? AND is_active = 1
+	`, provider)
 }
 
 func GetCalendarById(id string) (*Calendar, error) {
@@ -95,22 +144,11 @@ func GetCalendarById(id string) (*Calendar, error) {
 		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
 
-	var cal Calendar
-	var connectedAccountID, color, webhookResourceID, webhookChannelID, syncToken sql.NullString
-	var webhookExpiry sql.NullInt64
-	var isPrimary, isActive int
-
-	err = db.QueryRow(`
-		SELECT id, user_id, connected_account_id, provider, provider_calendar_id,
-		       name, color, is_primary, webhook_resource_id, webhook_channel_id,
-		       webhook_expiry, sync_token, is_active, created_at, updated_at
+	cal, err := scanCalendar(db.QueryRow(`
+		SELECT `+calendarColumns+`
 		FROM calendars
 		WHERE id = ?
-	`, id).Scan(
-		&cal.ID, &cal.UserID, &connectedAccountID, &cal.Provider, &cal.ProviderCalendarID,
-		&cal.Name, &color, &isPrimary, &webhookResourceID, &webhookChannelID,
-		&webhookExpiry, &syncToken, &isActive, &cal.CreatedAt, &cal.UpdatedAt,
-	)
+	`, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -119,31 +157,11 @@ func GetCalendarById(id string) (*Calendar, error) {
 		return nil, fmt.Errorf("failed to get calendar: %w", err)
 	}
 
-	cal.IsPrimary = isPrimary == 1
-	cal.IsActive = isActive == 1
-
-	if connectedAccountID.Valid {
-		cal.ConnectedAccountID = &connectedAccountID.String
-	}
-	if color.Valid {
-		cal.Color = &color.String
-	}
-	if webhookResourceID.Valid {
-		cal.WebhookResourceID = &webhookResourceID.String
-	}
-	if webhookChannelID.Valid {
-		cal.WebhookChannelID = &webhookChannelID.String
-	}
-	if webhookExpiry.Valid {
-		cal.WebhookExpiry = &webhookExpiry.Int64
-	}
-	if syncToken.Valid {
-		cal.SyncToken = &syncToken.String
-	}
-
-	return &cal, nil
+	return cal, nil
 }
 
+// CreateCalendar adds a calendar. Calendars without a provider calendar ID,
+// such as local ones, use their own ID.
 func CreateCalendar(cal Calendar) (string, error) {
 	db, err := shareddb.GetDB()
 	if err != nil {
@@ -153,6 +171,10 @@ func CreateCalendar(cal Calendar) (string, error) {
 	id := generateID()
 	now := time.Now().Unix()
 
+	if cal.ProviderCalendarID == "" {
+		cal.ProviderCalendarID = id
+	}
+
 	isPrimary := 0
 	if cal.IsPrimary {
 		isPrimary = 1
@@ -228,28 +250,38 @@ func UpdateCalendarSyncToken(id, syncToken string) error {
 	return nil
 }
 
+func UpdateCalendarSyncMode(id string, syncMode *string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	now := time.Now().Unix()
+
+	_, err = db.Exec(`
+		UPDATE calendars
+		SET sync_mode = ?, updated_at = ?
+		WHERE id = ?
+	`, syncMode, now, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update calendar sync mode: %w", err)
+	}
+
+	return nil
+}
+
 func GetCalendarByProviderCalendarId(connectedAccountId, providerCalendarId string) (*Calendar, error) {
 	db, err := shareddb.GetDB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
 
-	var cal Calendar
-	var connectedAccountID, color, webhookResourceID, webhookChannelID, syncToken sql.NullString
-	var webhookExpiry sql.NullInt64
-	var isPrimary, isActive int
-
-	err = db.QueryRow(`
-		SELECT id, user_id, connected_account_id, provider, provider_calendar_id,
-		       name, color, is_primary, webhook_resource_id, webhook_channel_id,
-		       webhook_expiry, sync_token, is_active, created_at, updated_at
+	cal, err := scanCalendar(db.QueryRow(`
+		SELECT `+calendarColumns+`
 		FROM calendars
 		WHERE connected_account_id = ? AND provider_calendar_id = ?
-	`, connectedAccountId, providerCalendarId).Scan(
-		&cal.ID, &cal.UserID, &connectedAccountID, &cal.Provider, &cal.ProviderCalendarID,
-		&cal.Name, &color, &isPrimary, &webhookResourceID, &webhookChannelID,
-		&webhookExpiry, &syncToken, &isActive, &cal.CreatedAt, &cal.UpdatedAt,
-	)
+	`, connectedAccountId, providerCalendarId))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -258,27 +290,52 @@ func GetCalendarByProviderCalendarId(connectedAccountId, providerCalendarId stri
 		return nil, fmt.Errorf("failed to get calendar by provider id: %w", err)
 	}
 
-	cal.IsPrimary = isPrimary == 1
-	cal.IsActive = isActive == 1
+	return cal, nil
+}
 
-	if connectedAccountID.Valid {
-		cal.ConnectedAccountID = &connectedAccountID.String
-	}
-	if color.Valid {
-		cal.Color = &color.String
-	}
-	if webhookResourceID.Valid {
-		cal.WebhookResourceID = &webhookResourceID.String
+// GetUserCalendarByProviderCalendarId finds a calendar that isn't tied to a
+// connected account, such as an ICS subscription.
+func GetUserCalendarByProviderCalendarId(userId, provider, providerCalendarId string) (*Calendar, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
-	if webhookChannelID.Valid {
-		cal.WebhookChannelID = &webhookChannelID.String
+
+	cal, err := scanCalendar(db.QueryRow(`
+		SELECT `+calendarColumns+`
+		FROM calendars
+		WHERE user_id = ? AND provider = ? AND provider_calendar_id = ?
+	`, userId, provider, providerCalendarId))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get calendar by provider id: %w", err)
 	}
-	if webhookExpiry.Valid {
-		cal.WebhookExpiry = &webhookExpiry.Int64
+
+	return cal, nil
+}
+
+// UpdateCalendarFeedState records the outcome of polling a subscribed feed.
+// The validators are kept for the next conditional GET.
+func UpdateCalendarFeedState(id string, etag, lastModified *string, checkedAt int64, feedError *string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
 	}
-	if syncToken.Valid {
-		cal.SyncToken = &syncToken.String
+
+	now := time.Now().Unix()
+
+	_, err = db.Exec(`
+		UPDATE calendars
+		SET feed_etag = ?, feed_last_modified = ?, feed_checked_at = ?, feed_error = ?, updated_at = ?
+		WHERE id = ?
+	`, etag, lastModified, checkedAt, feedError, now, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update calendar feed state: %w", err)
 	}
 
-	return &cal, nil
+	return nil
 }
@@ -19,8 +19,56 @@ type ConnectedAccount struct {
 	AccessToken       string
 	RefreshToken      string
 	TokenExpiry       *int64
-	CreatedAt         int64
-	UpdatedAt         int64
+	// ServerURL and Username are set for password-based accounts such as
+	// CalDAV, whose app password is kept in AccessToken.
+	ServerURL string
+	Username  string
+	// NeedsReauth is set when the provider revoked the account's grant.
+	// Its calendars aren't synced until the user consents again.
+	NeedsReauth  bool
+	ReauthReason string
+	CreatedAt    int64
+	UpdatedAt    int64
+}
+
+const connectedAccountColumns = `
+	id, user_id, provider, provider_account_id, email,
+	access_token, refresh_token, token_expiry, server_url, username,
+	created_at, updated_at, token_key_id, needs_reauth, reauth_reason
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanConnectedAccount(row rowScanner) (*ConnectedAccount, error) {
+	var acc ConnectedAccount
+	var tokenExpiry sql.NullInt64
+	var refreshToken, serverURL, username, tokenKeyID, reauthReason sql.NullString
+
+	err := row.Scan(
+		&acc.ID, &acc.UserID, &acc.Provider, &acc.ProviderAccountID,
+		&acc.Email, &acc.AccessToken, &refreshToken, &tokenExpiry,
+		&serverURL, &username, &acc.CreatedAt, &acc.UpdatedAt, &tokenKeyID,
+		&acc.NeedsReauth, &reauthReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenExpiry.Valid {
+		acc.TokenExpiry = &tokenExpiry.Int64
+	}
+	acc.RefreshToken = refreshToken.String
+	acc.ServerURL = serverURL.String
+	acc.Username = username.String
+	acc.ReauthReason = reauthReason.String
+
+	if err := shareddb.OpenTokens(tokenKeyID, &acc.AccessToken, &acc.RefreshToken); err != nil {
+		return nil, err
+	}
+
+	return &acc, nil
 }
 
 func generateID() string {
@@ -36,8 +84,7 @@ func GetConnectedAccountsByUserId(userId string) ([]ConnectedAccount, error) {
 	}
 
 	rows, err := db.Query(`
-		SELECT id, user_id, provider, provider_account_id, email,
-		       access_token, refresh_token, token_expiry, created_at, updated_at
+		SELECT `+connectedAccountColumns+`
 		FROM connected_accounts
 		WHERE user_id = ?
 		ORDER BY created_at DESC
@@ -49,27 +96,11 @@ func GetConnectedAccountsByUserId(userId string) ([]ConnectedAccount, error) {
 
 	var accounts []ConnectedAccount
 	for rows.Next() {
-		var acc ConnectedAccount
-		var tokenExpiry sql.NullInt64
-		var refreshToken sql.NullString
-
-		err := rows.Scan(
-			&acc.ID, &acc.UserID, &acc.Provider, &acc.ProviderAccountID,
-			&acc.Email, &acc.AccessToken, &refreshToken, &tokenExpiry,
-			&acc.CreatedAt, &acc.UpdatedAt,
-		)
+		acc, err := scanConnectedAccount(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan connected account: %w", err)
 		}
-
-		if tokenExpiry.Valid {
-			acc.TokenExpiry = &tokenExpiry.Int64
-		}
-		if refreshToken.Valid {
-			acc.RefreshToken = refreshToken.String
-		}
-
-		accounts = append(accounts, acc)
+		accounts = append(accounts, *acc)
 	}
 
 	return accounts, nil
@@ -81,20 +112,11 @@ func GetConnectedAccountById(id string) (*ConnectedAccount, error) {
 		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
 
-	var acc ConnectedAccount
-	var tokenExpiry sql.NullInt64
-	var refreshToken sql.NullString
-
-	err = db.QueryRow(`
-		SELECT id, user_id, provider, provider_account_id, email,
-		       access_token, refresh_token, token_expiry, created_at, updated_at
+	acc, err := scanConnectedAccount(db.QueryRow(`
+		SELECT `+connectedAccountColumns+`
 		FROM connected_accounts
 		WHERE id = ?
-	`, id).Scan(
-		&acc.ID, &acc.UserID, &acc.Provider, &acc.ProviderAccountID,
-		&acc.Email, &acc.AccessToken, &refreshToken, &tokenExpiry,
-		&acc.CreatedAt, &acc.UpdatedAt,
-	)
+	`, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -103,14 +125,7 @@ func GetConnectedAccountById(id string) (*ConnectedAccount, error) {
 		return nil, fmt.Errorf("failed to get connected account: %w", err)
 	}
 
-	if tokenExpiry.Valid {
-		acc.TokenExpiry = &tokenExpiry.Int64
-	}
-	if refreshToken.Valid {
-		acc.RefreshToken = refreshToken.String
-	}
-
-	return &acc, nil
+	return acc, nil
 }
 
 func GetConnectedAccountByProviderAccountId(userId, provider, providerAccountId string) (*ConnectedAccount, error) {
@@ -119,20 +134,11 @@ func GetConnectedAccountByProviderAccountId(userId, provider, providerAccountId
 		return nil, fmt.Errorf("failed to get database: %w", err)
 	}
 
-	var acc ConnectedAccount
-	var tokenExpiry sql.NullInt64
-	var refreshToken sql.NullString
-
-	err = db.QueryRow(`
-		SELECT id, user_id, provider, provider_account_id, email,
-		       access_token, refresh_token, token_expiry, created_at, updated_at
+	acc, err := scanConnectedAccount(db.QueryRow(`
+		SELECT `+connectedAccountColumns+`
 		FROM connected_accounts
 		WHERE user_id = ? AND provider = ? AND provider_account_id = ?
-	`, userId, provider, providerAccountId).Scan(
-		&acc.ID, &acc.UserID, &acc.Provider, &acc.ProviderAccountID,
-		&acc.Email, &acc.AccessToken, &refreshToken, &tokenExpiry,
-		&acc.CreatedAt, &acc.UpdatedAt,
-	)
+	`, userId, provider, providerAccountId))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -141,14 +147,7 @@ func GetConnectedAccountByProviderAccountId(userId, provider, providerAccountId
 		return nil, fmt.Errorf("failed to get connected account by provider: %w", err)
 	}
 
-	if tokenExpiry.Valid {
-		acc.TokenExpiry = &tokenExpiry.Int64
-	}
-	if refreshToken.Valid {
-		acc.RefreshToken = refreshToken.String
-	}
-
-	return &acc, nil
+	return acc, nil
 }
 
 func CreateConnectedAccount(acc ConnectedAccount) (string, error) {
@@ -165,17 +164,32 @@ func CreateConnectedAccount(acc ConnectedAccount) (string, error) {
 		tokenExpiry = *acc.TokenExpiry
 	}
 
+	accessToken, refresh := acc.AccessToken, acc.RefreshToken
+	tokenKeyID, err := shareddb.SealTokens(&accessToken, &refresh)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
 	var refreshToken interface{} = nil
-	if acc.RefreshToken != "" {
-		refreshToken = acc.RefreshToken
+	if refresh != "" {
+		refreshToken = refresh
+	}
+
+	var serverURL, username interface{} = nil, nil
+	if acc.ServerURL != "" {
+		serverURL = acc.ServerURL
+	}
+	if acc.Username != "" {
+		username = acc.Username
 	}
 
 	_, err = db.Exec(`
 		INSERT INTO connected_accounts
-		(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry,
+		 server_url, username, token_key_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, id, acc.UserID, acc.Provider, acc.ProviderAccountID, acc.Email,
-		acc.AccessToken, refreshToken, tokenExpiry, now, now)
+		accessToken, refreshToken, tokenExpiry, serverURL, username, tokenKeyID, now, now)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to create connected account: %w", err)
@@ -197,6 +211,11 @@ func UpdateConnectedAccountTokens(id, accessToken, refreshToken string, tokenExp
 		expiryVal = *tokenExpiry
 	}
 
+	tokenKeyID, err := shareddb.SealTokens(&accessToken, &refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
 	var refreshVal interface{} = nil
 	if refreshToken != "" {
 		refreshVal = refreshToken
@@ -204,9 +223,10 @@ func UpdateConnectedAccountTokens(id, accessToken, refreshToken string, tokenExp
 
 	_, err = db.Exec(`
 		UPDATE connected_accounts
-		SET access_token = ?, refresh_token = ?, token_expiry = ?, updated_at = ?
+		SET access_token = ?, refresh_token = ?, token_key_id = ?, token_expiry = ?,
+		    needs_reauth = 0, reauth_reason = NULL, updated_at = ?
 		WHERE id = ?
-	`, accessToken, refreshVal, expiryVal, now, id)
+	`, accessToken, refreshVal, tokenKeyID, expiryVal, now, id)
 
 	if err != nil {
 		return fmt.Errorf("failed to update connected account tokens: %w", err)
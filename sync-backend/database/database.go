@@ -12,6 +12,7 @@ type User struct {
 	Email        string
 	Token        string
 	RefreshToken string
+	TokenExpiry  *int64
 	CreatedAt    int64
 }
 
@@ -26,10 +27,12 @@ func GetUser(email string) (*User, error) {
 	}
 
 	var user User
+	var tokenKeyID sql.NullString
+	var tokenExpiry sql.NullInt64
 	err = db.QueryRow(
-		"SELECT id, email, token, refresh_token, created_at FROM users WHERE email = ?",
+		"SELECT id, email, token, refresh_token, token_key_id, token_expiry, created_at FROM users WHERE email = ?",
 		email,
-	).Scan(&user.ID, &user.Email, &user.Token, &user.RefreshToken, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.Token, &user.RefreshToken, &tokenKeyID, &tokenExpiry, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -38,6 +41,14 @@ func GetUser(email string) (*User, error) {
 		return nil, fmt.Errorf("failed to get user %s: %w", email, err)
 	}
 
+	if tokenExpiry.Valid {
+		user.TokenExpiry = &tokenExpiry.Int64
+	}
+
+	if err := shareddb.OpenTokens(tokenKeyID, &user.Token, &user.RefreshToken); err != nil {
+		return nil, fmt.Errorf("failed to get user %s: %w", email, err)
+	}
+
 	return &user, nil
 }
 
@@ -48,10 +59,12 @@ func GetUserById(id string) (*User, error) {
 	}
 
 	var user User
+	var tokenKeyID sql.NullString
+	var tokenExpiry sql.NullInt64
 	err = db.QueryRow(
-		"SELECT id, email, token, refresh_token, created_at FROM users WHERE id = ?",
+		"SELECT id, email, token, refresh_token, token_key_id, token_expiry, created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Email, &user.Token, &user.RefreshToken, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.Token, &user.RefreshToken, &tokenKeyID, &tokenExpiry, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -60,5 +73,13 @@ func GetUserById(id string) (*User, error) {
 		return nil, fmt.Errorf("failed to get user by id %s: %w", id, err)
 	}
 
+	if tokenExpiry.Valid {
+		user.TokenExpiry = &tokenExpiry.Int64
+	}
+
+	if err := shareddb.OpenTokens(tokenKeyID, &user.Token, &user.RefreshToken); err != nil {
+		return nil, fmt.Errorf("failed to get user by id %s: %w", id, err)
+	}
+
 	return &user, nil
 }
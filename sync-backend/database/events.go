@@ -0,0 +1,468 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	shareddb "shared/database"
+)
+
+type Event struct {
+	ID              string
+	CalendarID      string
+	ProviderEventID string
+	Title           string
+	Description     string
+	Location        string
+	StartTime       int64
+	EndTime         int64
+	IsAllDay        bool
+	Status          string
+	Recurrence      string
+	Attendees       string
+	Etag            string
+	RawData         string
+	// DavName and DavUID are the resource name and UID a CalDAV client
+	// created the event under; empty for events from anywhere else.
+	DavName   string
+	DavUID    string
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+const eventColumns = `
+	id, calendar_id, provider_event_id, title, description, location,
+	start_time, end_time, is_all_day, status, recurrence, attendees,
+	etag, raw_data, dav_name, dav_uid, created_at, updated_at
+`
+
+func scanEvent(row rowScanner) (*Event, error) {
+	var ev Event
+	var title, description, location, status, recurrence, attendees, etag, rawData sql.NullString
+	var davName, davUID sql.NullString
+	var isAllDay int
+
+	err := row.Scan(
+		&ev.ID, &ev.CalendarID, &ev.ProviderEventID, &title, &description, &location,
+		&ev.StartTime, &ev.EndTime, &isAllDay, &status, &recurrence, &attendees,
+		&etag, &rawData, &davName, &davUID, &ev.CreatedAt, &ev.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.IsAllDay = isAllDay == 1
+	ev.Title = title.String
+	ev.Description = description.String
+	ev.Location = location.String
+	ev.Status = status.String
+	ev.Recurrence = recurrence.String
+	ev.Attendees = attendees.String
+	ev.Etag = etag.String
+	ev.RawData = rawData.String
+	ev.DavName = davName.String
+	ev.DavUID = davUID.String
+
+	return &ev, nil
+}
+
+func GetEventsByCalendarId(calendarId string) ([]Event, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE calendar_id = ?
+		ORDER BY start_time ASC
+	`, calendarId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		ev, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, *ev)
+	}
+
+	return events, rows.Err()
+}
+
+func GetEventById(id string) (*Event, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ev, err := scanEvent(db.QueryRow(`
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE id = ?
+	`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+
+	return ev, nil
+}
+
+func GetEventByProviderEventId(calendarId, providerEventId string) (*Event, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ev, err := scanEvent(db.QueryRow(`
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE calendar_id = ? AND provider_event_id = ?
+	`, calendarId, providerEventId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+
+	return ev, nil
+}
+
+// EventDiff counts what ReplaceCalendarEvents or ApplyEventChanges changed.
+type EventDiff struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+}
+
+// ReplaceCalendarEvents makes the calendar's events match the given
+// snapshot, keyed by provider_event_id. Rows whose etag is unchanged are
+// left alone, so local IDs and created_at survive repeated imports.
+func ReplaceCalendarEvents(calendarId string, events []Event) (*EventDiff, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing := make(map[string]Event)
+	rows, err := tx.Query(`
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE calendar_id = ?
+	`, calendarId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	for rows.Next() {
+		ev, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		existing[ev.ProviderEventID] = *ev
+	}
+	rows.Close()
+
+	now := time.Now().Unix()
+	diff := &EventDiff{}
+	seen := make(map[string]bool, len(events))
+
+	for _, ev := range events {
+		if seen[ev.ProviderEventID] {
+			continue
+		}
+		seen[ev.ProviderEventID] = true
+
+		current, ok := existing[ev.ProviderEventID]
+		if !ok {
+			if err := insertEvent(tx, calendarId, ev, now); err != nil {
+				return nil, err
+			}
+			diff.Added++
+			continue
+		}
+
+		if ev.Etag != "" && ev.Etag == current.Etag {
+			diff.Unchanged++
+			continue
+		}
+
+		if err := updateEvent(tx, current.ID, ev, now); err != nil {
+			return nil, err
+		}
+		diff.Updated++
+	}
+
+	for providerEventID, current := range existing {
+		if seen[providerEventID] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM events WHERE id = ?", current.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete event: %w", err)
+		}
+		diff.Removed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit events: %w", err)
+	}
+
+	return diff, nil
+}
+
+// ApplyEventChanges applies incremental changes from a provider, keyed by
+// provider_event_id: cancelled events are deleted, the others inserted or
+// updated. Rows whose etag is unchanged are left alone, so applying the same
+// changes twice is harmless.
+func ApplyEventChanges(calendarId string, events []Event) (*EventDiff, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	diff := &EventDiff{}
+
+	for _, ev := range events {
+		var id string
+		var etag sql.NullString
+		err := tx.QueryRow(
+			"SELECT id, etag FROM events WHERE calendar_id = ? AND provider_event_id = ?",
+			calendarId, ev.ProviderEventID,
+		).Scan(&id, &etag)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up event: %w", err)
+		}
+		found := err == nil
+
+		switch {
+		case ev.Status == "cancelled":
+			if !found {
+				continue
+			}
+			if _, err := tx.Exec("DELETE FROM events WHERE id = ?", id); err != nil {
+				return nil, fmt.Errorf("failed to delete event: %w", err)
+			}
+			diff.Removed++
+		case !found:
+			if err := insertEvent(tx, calendarId, ev, now); err != nil {
+				return nil, err
+			}
+			diff.Added++
+		case ev.Etag != "" && ev.Etag == etag.String:
+			diff.Unchanged++
+		default:
+			if err := updateEvent(tx, id, ev, now); err != nil {
+				return nil, err
+			}
+			diff.Updated++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit events: %w", err)
+	}
+
+	return diff, nil
+}
+
+func insertEvent(tx *sql.Tx, calendarId string, ev Event, now int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO events
+		(id, calendar_id, provider_event_id, title, description, location, start_time, end_time,
+		 is_all_day, status, recurrence, attendees, etag, raw_data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, generateID(), calendarId, ev.ProviderEventID, ev.Title, ev.Description, ev.Location,
+		ev.StartTime, ev.EndTime, boolToInt(ev.IsAllDay), ev.Status, ev.Recurrence,
+		ev.Attendees, ev.Etag, ev.RawData, now, now)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+	return nil
+}
+
+func updateEvent(tx *sql.Tx, id string, ev Event, now int64) error {
+	_, err := tx.Exec(`
+		UPDATE events
+		SET title = ?, description = ?, location = ?, start_time = ?, end_time = ?,
+		    is_all_day = ?, status = ?, recurrence = ?, attendees = ?, etag = ?,
+		    raw_data = ?, updated_at = ?
+		WHERE id = ?
+	`, ev.Title, ev.Description, ev.Location, ev.StartTime, ev.EndTime,
+		boolToInt(ev.IsAllDay), ev.Status, ev.Recurrence, ev.Attendees, ev.Etag,
+		ev.RawData, now, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update event: %w", err)
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// UpsertEvent inserts or updates a single event, keyed by calendar and
+// provider_event_id.
+func UpsertEvent(calendarId string, ev Event) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(
+		"SELECT id FROM events WHERE calendar_id = ? AND provider_event_id = ?",
+		calendarId, ev.ProviderEventID,
+	).Scan(&id)
+
+	now := time.Now().Unix()
+
+	switch {
+	case err == sql.ErrNoRows:
+		err = insertEvent(tx, calendarId, ev, now)
+	case err != nil:
+		err = fmt.Errorf("failed to look up event: %w", err)
+	default:
+		err = updateEvent(tx, id, ev, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event: %w", err)
+	}
+	return nil
+}
+
+func DeleteEventByProviderEventId(calendarId, providerEventId string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec(
+		"DELETE FROM events WHERE calendar_id = ? AND provider_event_id = ?",
+		calendarId, providerEventId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+
+	return nil
+}
+
+// SetEventDavResource records the name and UID a CalDAV client gave an
+// event, so the client finds it under the same resource.
+func SetEventDavResource(id, name, uid string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec("UPDATE events SET dav_name = ?, dav_uid = ? WHERE id = ?", name, uid, id)
+	if err != nil {
+		return fmt.Errorf("failed to update event: %w", err)
+	}
+
+	return nil
+}
+
+// GetEventTombstones returns the resource names of the calendar's events
+// deleted at or after since. A trigger records every deletion, whatever
+// removed the event.
+func GetEventTombstones(calendarId string, since int64) ([]string, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT name
+		FROM event_tombstones
+		WHERE calendar_id = ? AND deleted_at >= ?
+	`, calendarId, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// LatestEventTombstone returns when an event of the calendar was last
+// deleted, or 0.
+func LatestEventTombstone(calendarId string) (int64, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var latest sql.NullInt64
+	err = db.QueryRow(
+		"SELECT MAX(deleted_at) FROM event_tombstones WHERE calendar_id = ?", calendarId,
+	).Scan(&latest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+
+	return latest.Int64, nil
+}
+
+// PruneEventTombstones forgets deletions older than before. Clients holding a
+// sync token from before then have to resync in full.
+func PruneEventTombstones(before int64) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM event_tombstones WHERE deleted_at < ?", before); err != nil {
+		return fmt.Errorf("failed to prune tombstones: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	shareddb "shared/database"
+)
+
+// FeedToken grants read access to an ICS feed of one calendar, or of all the
+// user's calendars when CalendarID is nil. Only a hash of the token is
+// stored; the token itself is shown once, when it is created.
+type FeedToken struct {
+	ID         string
+	UserID     string
+	CalendarID *string
+	BusyOnly   bool
+	LastUsedAt *int64
+	CreatedAt  int64
+}
+
+const feedTokenColumns = `id, user_id, calendar_id, busy_only, last_used_at, created_at`
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanFeedToken(row rowScanner) (*FeedToken, error) {
+	var ft FeedToken
+	var calendarID sql.NullString
+	var lastUsedAt sql.NullInt64
+	var busyOnly int
+
+	err := row.Scan(&ft.ID, &ft.UserID, &calendarID, &busyOnly, &lastUsedAt, &ft.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	ft.BusyOnly = busyOnly == 1
+	if calendarID.Valid {
+		ft.CalendarID = &calendarID.String
+	}
+	if lastUsedAt.Valid {
+		ft.LastUsedAt = &lastUsedAt.Int64
+	}
+
+	return &ft, nil
+}
+
+// CreateFeedToken stores a new feed token and returns its ID and the token.
+func CreateFeedToken(userId string, calendarId *string, busyOnly bool) (string, string, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get database: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate feed token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	id := generateID()
+	now := time.Now().Unix()
+
+	_, err = db.Exec(`
+		INSERT INTO feed_tokens (id, user_id, calendar_id, token_hash, busy_only, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, userId, calendarId, hashFeedToken(token), boolToInt(busyOnly), now)
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create feed token: %w", err)
+	}
+
+	return id, token, nil
+}
+
+func GetFeedTokenByToken(token string) (*FeedToken, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ft, err := scanFeedToken(db.QueryRow(`
+		SELECT `+feedTokenColumns+`
+		FROM feed_tokens
+		WHERE token_hash = ?
+	`, hashFeedToken(token)))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get feed token: %w", err)
+	}
+
+	return ft, nil
+}
+
+func GetFeedTokenById(id string) (*FeedToken, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	ft, err := scanFeedToken(db.QueryRow(`
+		SELECT `+feedTokenColumns+`
+		FROM feed_tokens
+		WHERE id = ?
+	`, id))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get feed token: %w", err)
+	}
+
+	return ft, nil
+}
+
+func GetFeedTokensByUserId(userId string) ([]FeedToken, error) {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+feedTokenColumns+`
+		FROM feed_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feed tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []FeedToken
+	for rows.Next() {
+		ft, err := scanFeedToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan feed token: %w", err)
+		}
+		tokens = append(tokens, *ft)
+	}
+
+	return tokens, rows.Err()
+}
+
+func TouchFeedToken(id string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec("UPDATE feed_tokens SET last_used_at = ? WHERE id = ?", time.Now().Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update feed token: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteFeedToken(id string) error {
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec("DELETE FROM feed_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete feed token: %w", err)
+	}
+
+	return nil
+}
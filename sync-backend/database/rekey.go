@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	shareddb "shared/database"
+)
+
+// tokenTables are the tables holding encrypted tokens, with their token
+// columns.
+var tokenTables = []struct {
+	table   string
+	columns [2]string
+}{
+	{"users", [2]string{"token", "refresh_token"}},
+	{"connected_accounts", [2]string{"access_token", "refresh_token"}},
+}
+
+type tokenRow struct {
+	id     string
+	tokens [2]sql.NullString
+	keyID  sql.NullString
+}
+
+// RekeyTokens re-encrypts the tokens of every row that isn't encrypted with
+// the current key, including plaintext rows from before encryption was set
+// up. It returns the number of rows per table it rewrote, or would rewrite
+// when dryRun is set.
+func RekeyTokens(dryRun bool) (map[string]int, error) {
+	current, err := shareddb.CurrentTokenKeyID()
+	if err != nil {
+		return nil, err
+	}
+	if current == "" {
+		return nil, fmt.Errorf("no token encryption key is configured")
+	}
+
+	db, err := shareddb.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, t := range tokenTables {
+		rows, err := staleTokenRows(db, t.table, t.columns, current)
+		if err != nil {
+			return counts, err
+		}
+
+		for _, row := range rows {
+			if !dryRun {
+				if err := rekeyRow(db, t.table, t.columns, row); err != nil {
+					return counts, fmt.Errorf("failed to rekey %s %s: %w", t.table, row.id, err)
+				}
+			}
+			counts[t.table]++
+		}
+	}
+	return counts, nil
+}
+
+func staleTokenRows(db *sql.DB, table string, columns [2]string, current string) ([]tokenRow, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT id, %s, %s, token_key_id
+		FROM %s
+		WHERE token_key_id IS NULL OR token_key_id != ?
+	`, columns[0], columns[1], table), current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var result []tokenRow
+	for rows.Next() {
+		var row tokenRow
+		if err := rows.Scan(&row.id, &row.tokens[0], &row.tokens[1], &row.keyID); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// rekeyRow rewrites one row, unless it changed since it was read.
+func rekeyRow(db *sql.DB, table string, columns [2]string, row tokenRow) error {
+	first, second := row.tokens[0].String, row.tokens[1].String
+	if err := shareddb.OpenTokens(row.keyID, &first, &second); err != nil {
+		return err
+	}
+	keyID, err := shareddb.SealTokens(&first, &second)
+	if err != nil {
+		return err
+	}
+
+	values := [2]any{nil, nil}
+	if row.tokens[0].Valid {
+		values[0] = first
+	}
+	if row.tokens[1].Valid {
+		values[1] = second
+	}
+
+	var oldKeyID any
+	if row.keyID.Valid {
+		oldKeyID = row.keyID.String
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`
+		UPDATE %s SET %s = ?, %s = ?, token_key_id = ?
+		WHERE id = ? AND token_key_id IS ?
+	`, table, columns[0], columns[1]), values[0], values[1], keyID, row.id, oldKeyID)
+	return err
+}
@@ -0,0 +1,383 @@
+package davserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/feed"
+	"calendar-backend/ical"
+	"calendar-backend/ics"
+	"calendar-backend/local"
+	"calendar-backend/writethrough"
+	"shared/logger"
+)
+
+const syncTokenPrefix = "urn:calendar-backend:sync:"
+
+// tombstoneRetention is how long deletions are remembered for
+// sync-collection. Older sync tokens are refused and the client resyncs.
+const tombstoneRetention = 30 * 24 * time.Hour
+
+// object is one calendar object resource: an event and the overrides of
+// its occurrences, which CalDAV keeps in the same resource.
+type object struct {
+	name   string
+	uid    string
+	events []database.Event
+	// stamp is the last update of any of the events.
+	stamp int64
+}
+
+func (o *object) master() *database.Event {
+	return &o.events[0]
+}
+
+// loadObjects groups the calendar's events into resources. Events keep the
+// name a client created them under, the others are named after their ID.
+func loadObjects(calendar *database.Calendar) ([]*object, int64, error) {
+	events, err := database.GetEventsByCalendarId(calendar.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var objects []*object
+	byUID := make(map[string]*object)
+	var overrides []database.Event
+	var latest int64
+
+	for _, ev := range events {
+		if ev.UpdatedAt > latest {
+			latest = ev.UpdatedAt
+		}
+		if ev.Status == "cancelled" {
+			continue
+		}
+
+		uid, override := feed.Identity(*calendar, ev)
+		if override {
+			overrides = append(overrides, ev)
+			continue
+		}
+
+		obj := &object{name: ev.DavName, uid: ev.DavUID, events: []database.Event{ev}, stamp: ev.UpdatedAt}
+		if obj.name == "" {
+			obj.name = ev.ID + ".ics"
+		}
+		objects = append(objects, obj)
+		if _, ok := byUID[uid]; !ok {
+			byUID[uid] = obj
+		}
+	}
+
+	for _, ev := range overrides {
+		uid, _ := feed.Identity(*calendar, ev)
+		if obj, ok := byUID[uid]; ok {
+			obj.events = append(obj.events, ev)
+			if ev.UpdatedAt > obj.stamp {
+				obj.stamp = ev.UpdatedAt
+			}
+			continue
+		}
+		objects = append(objects, &object{name: ev.ID + ".ics", events: []database.Event{ev}, stamp: ev.UpdatedAt})
+	}
+
+	return objects, latest, nil
+}
+
+func findObject(objects []*object, name string) *object {
+	for _, obj := range objects {
+		if obj.name == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+// render returns the iCalendar data of the object and its ETag. The ETag
+// covers the VEVENTs only, since the VTIMEZONEs reach further ahead as time
+// passes.
+func render(calendar *database.Calendar, obj *object) (string, string) {
+	cal := feed.Resource(*calendar, obj.events, obj.uid)
+
+	hash := sha256.New()
+	for _, vevent := range cal.ChildrenNamed("VEVENT") {
+		ical.Encode(hash, vevent)
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+
+	var body bytes.Buffer
+	ical.Encode(&body, cal)
+	return body.String(), etag
+}
+
+// syncToken returns the calendar's current sync token. It is the time of
+// the last change, or the second before it while changes can still arrive in
+// the same second, so a token never hides a later change.
+func syncToken(calendar *database.Calendar, latestEvent int64) (string, error) {
+	latest, err := database.LatestEventTombstone(calendar.ID)
+	if err != nil {
+		return "", err
+	}
+	if latestEvent > latest {
+		latest = latestEvent
+	}
+	if latest >= time.Now().Unix() {
+		latest--
+	}
+	return syncTokenPrefix + strconv.FormatInt(latest, 10), nil
+}
+
+func parseSyncToken(token string) (int64, bool) {
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(strings.TrimPrefix(token, syncTokenPrefix), 10, 64)
+	return value, err == nil
+}
+
+// checkPreconditions applies If-Match and If-None-Match to the current ETag
+// of the resource, which is empty when it doesn't exist.
+func (req *request) checkPreconditions(etag string) bool {
+	if match := req.r.Header.Get("If-Match"); match != "" {
+		if etag == "" || (match != "*" && !strings.Contains(match, etag)) {
+			return false
+		}
+	}
+	if noneMatch := req.r.Header.Get("If-None-Match"); noneMatch != "" {
+		if etag != "" && (noneMatch == "*" || strings.Contains(noneMatch, etag)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (req *request) get() {
+	if req.kind != kindObject {
+		http.Error(req.w, "Not a calendar object", http.StatusMethodNotAllowed)
+		return
+	}
+
+	objects, _, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	obj := findObject(objects, req.name)
+	if obj == nil {
+		http.NotFound(req.w, req.r)
+		return
+	}
+
+	body, etag := render(req.calendar, obj)
+	req.w.Header().Set("ETag", etag)
+	req.w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	req.w.WriteHeader(http.StatusOK)
+	if req.r.Method != http.MethodHead {
+		io.WriteString(req.w, body)
+	}
+}
+
+// put creates or replaces an event from the client. Local calendars keep
+// the iCalendar data as sent, overrides included. Other calendars get the
+// event through their provider, which has no place for modified single
+// occurrences, so those are refused.
+func (req *request) put() {
+	if req.kind != kindObject {
+		http.Error(req.w, "Not a calendar object", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, err := writethrough.Resolve(req.calendar)
+	switch {
+	case err == writethrough.ErrReadOnly:
+		writeError(req.w, http.StatusForbidden, davName("need-privileges"))
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to resolve provider for calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Calendar service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	objects, _, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	existing := findObject(objects, req.name)
+
+	var currentETag string
+	if existing != nil {
+		_, currentETag = render(req.calendar, existing)
+	}
+	if !req.checkPreconditions(currentETag) {
+		http.Error(req.w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	imp, err := ics.ReadImport(req.r.Body)
+	if err != nil {
+		writeError(req.w, http.StatusBadRequest, calDAVName("valid-calendar-data"))
+		return
+	}
+	if len(imp.Items) == 0 {
+		// Tasks and journal entries have nowhere to go.
+		writeError(req.w, http.StatusForbidden, calDAVName("supported-calendar-component"))
+		return
+	}
+
+	uid := imp.Items[0].UID
+	var master *ics.ImportItem
+	for _, item := range imp.Items {
+		if item.UID != uid {
+			writeError(req.w, http.StatusForbidden, calDAVName("valid-calendar-object-resource"))
+			return
+		}
+		if item.RecurrenceID == "" && master == nil {
+			master = item
+		}
+	}
+
+	var stored *database.Event
+	if req.calendar.Provider == local.ProviderName {
+		stored, err = req.putLocal(imp, existing, uid)
+	} else {
+		if master == nil || len(imp.Items) > 1 {
+			http.Error(req.w, "Changing single occurrences is only supported in local calendars", http.StatusForbidden)
+			return
+		}
+		if existing != nil {
+			stored, err = target.Update(req.r.Context(), existing.master(), master.ProviderEvent())
+		} else {
+			stored, err = target.Create(req.r.Context(), master.ProviderEvent())
+		}
+	}
+	if err != nil {
+		logger.Error.Printf("CalDAV write to calendar %s failed: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to save event", http.StatusBadGateway)
+		return
+	}
+
+	if err := database.SetEventDavResource(stored.ID, req.name, uid); err != nil {
+		logger.Error.Printf("Failed to record CalDAV resource of event %s: %v", stored.ID, err)
+	}
+
+	objects, _, err = loadObjects(req.calendar)
+	if err == nil {
+		if obj := findObject(objects, req.name); obj != nil {
+			_, etag := render(req.calendar, obj)
+			req.w.Header().Set("ETag", etag)
+		}
+	}
+
+	if existing != nil {
+		req.w.WriteHeader(http.StatusNoContent)
+	} else {
+		req.w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// putLocal stores the events of a local calendar as sent and removes
+// overrides the client dropped. Every row is recorded under the resource
+// name, so deleting any of them leaves a tombstone clients know. It returns
+// the master's row, or the first override when there is no master.
+func (req *request) putLocal(imp *ics.Import, existing *object, uid string) (*database.Event, error) {
+	written := make(map[string]bool)
+	var stored *database.Event
+
+	for _, item := range imp.Items {
+		row, err := item.Row()
+		if err != nil {
+			return nil, err
+		}
+		if err := database.UpsertEvent(req.calendar.ID, row); err != nil {
+			return nil, err
+		}
+		written[row.ProviderEventID] = true
+
+		ev, err := database.GetEventByProviderEventId(req.calendar.ID, row.ProviderEventID)
+		if err != nil {
+			return nil, err
+		}
+		if ev == nil {
+			return nil, errors.New("stored event not found")
+		}
+		if err := database.SetEventDavResource(ev.ID, req.name, uid); err != nil {
+			return nil, err
+		}
+		if stored == nil || item.RecurrenceID == "" {
+			stored = ev
+		}
+	}
+
+	if existing != nil {
+		for _, ev := range existing.events {
+			if !written[ev.ProviderEventID] {
+				if err := database.DeleteEventByProviderEventId(req.calendar.ID, ev.ProviderEventID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return stored, nil
+}
+
+func (req *request) delete() {
+	if req.kind != kindObject {
+		http.Error(req.w, "Calendars can only be deleted in the app", http.StatusForbidden)
+		return
+	}
+
+	target, err := writethrough.Resolve(req.calendar)
+	switch {
+	case err == writethrough.ErrReadOnly:
+		writeError(req.w, http.StatusForbidden, davName("need-privileges"))
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to resolve provider for calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Calendar service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	objects, _, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	obj := findObject(objects, req.name)
+	if obj == nil {
+		http.NotFound(req.w, req.r)
+		return
+	}
+
+	_, etag := render(req.calendar, obj)
+	if !req.checkPreconditions(etag) {
+		http.Error(req.w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	if err := target.Delete(req.r.Context(), obj.master()); err != nil {
+		logger.Error.Printf("CalDAV delete in calendar %s failed: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to delete event", http.StatusBadGateway)
+		return
+	}
+	// Overrides only exist locally; their series is gone with the master.
+	for _, ev := range obj.events[1:] {
+		if err := database.DeleteEventByProviderEventId(req.calendar.ID, ev.ProviderEventID); err != nil {
+			logger.Error.Printf("Failed to delete override %s: %v", ev.ID, err)
+		}
+	}
+
+	req.w.WriteHeader(http.StatusNoContent)
+}
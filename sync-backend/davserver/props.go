@@ -0,0 +1,164 @@
+package davserver
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+
+	"calendar-backend/database"
+	"calendar-backend/writethrough"
+	"shared/logger"
+)
+
+func davName(local string) xml.Name    { return xml.Name{Space: nsDAV, Local: local} }
+func calDAVName(local string) xml.Name { return xml.Name{Space: nsCalDAV, Local: local} }
+
+// calendarDataName is only returned when asked for by name, since it is the
+// whole event.
+var calendarDataName = calDAVName("calendar-data")
+
+func (req *request) propfind() {
+	depth := req.r.Header.Get("Depth")
+	if depth == "" {
+		depth = "1"
+	}
+	if depth != "0" && depth != "1" {
+		writeError(req.w, http.StatusForbidden, davName("propfind-finite-depth"))
+		return
+	}
+
+	// An empty body asks for all properties.
+	body, err := parseXML(req.r.Body)
+	if err != nil && err != io.EOF {
+		http.Error(req.w, "Malformed PROPFIND body", http.StatusBadRequest)
+		return
+	}
+	pr := readPropRequest(body)
+
+	var responses []response
+	switch req.kind {
+	case kindRoot, kindPrincipal:
+		responses = append(responses, propResponse(req.r.URL.Path, req.principalProps(req.kind == kindPrincipal), pr))
+	case kindHome:
+		responses = append(responses, propResponse(req.s.homeHref(req.user), req.principalProps(false), pr))
+		if depth == "1" {
+			calendars, err := database.GetCalendarsByUserId(req.user.ID)
+			if err != nil {
+				logger.Error.Printf("Failed to get calendars: %v", err)
+				http.Error(req.w, "Failed to get calendars", http.StatusInternalServerError)
+				return
+			}
+			for i := range calendars {
+				have, err := req.calendarProps(&calendars[i])
+				if err != nil {
+					logger.Error.Printf("Failed to load calendar %s: %v", calendars[i].ID, err)
+					continue
+				}
+				responses = append(responses, propResponse(req.s.calendarHref(req.user, &calendars[i]), have, pr))
+			}
+		}
+	case kindCalendar:
+		have, err := req.calendarProps(req.calendar)
+		if err != nil {
+			logger.Error.Printf("Failed to load calendar %s: %v", req.calendar.ID, err)
+			http.Error(req.w, "Failed to load calendar", http.StatusInternalServerError)
+			return
+		}
+		responses = append(responses, propResponse(req.s.calendarHref(req.user, req.calendar), have, pr))
+		if depth == "1" {
+			objects, _, err := loadObjects(req.calendar)
+			if err != nil {
+				logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+				http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+				return
+			}
+			for _, obj := range objects {
+				responses = append(responses, req.objectResponse(obj, pr))
+			}
+		}
+	case kindObject:
+		objects, _, err := loadObjects(req.calendar)
+		if err != nil {
+			logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+			http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+			return
+		}
+		obj := findObject(objects, req.name)
+		if obj == nil {
+			http.NotFound(req.w, req.r)
+			return
+		}
+		responses = append(responses, req.objectResponse(obj, pr))
+	}
+
+	writeMultistatus(req.w, responses, "")
+}
+
+// principalProps are the properties of the root, the principal and the
+// calendar home, which all point clients on to the calendars.
+func (req *request) principalProps(principal bool) props {
+	have := props{
+		davName("current-user-principal"): hrefXML(req.s.principalHref(req.user)),
+		calDAVName("calendar-home-set"):   hrefXML(req.s.homeHref(req.user)),
+		davName("displayname"):            escape(req.user.Email),
+		davName("resourcetype"):           "<d:collection/>",
+	}
+	if principal {
+		have[davName("resourcetype")] = "<d:principal/>"
+		have[davName("principal-URL")] = hrefXML(req.s.principalHref(req.user))
+		have[calDAVName("calendar-user-address-set")] = hrefXML("mailto:" + req.user.Email)
+	}
+	return have
+}
+
+func (req *request) calendarProps(calendar *database.Calendar) (props, error) {
+	_, latest, err := loadObjects(calendar)
+	if err != nil {
+		return nil, err
+	}
+	token, err := syncToken(calendar, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if writethrough.Writable(calendar) {
+		privileges += "<d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege>" +
+			"<d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>"
+	}
+
+	have := props{
+		davName("resourcetype"):                        "<d:collection/><c:calendar/>",
+		davName("displayname"):                         escape(calendar.Name),
+		davName("owner"):                               hrefXML(req.s.principalHref(req.user)),
+		davName("current-user-privilege-set"):          privileges,
+		davName("sync-token"):                          escape(token),
+		xml.Name{Space: nsCS, Local: "getctag"}:        escape(token),
+		calDAVName("supported-calendar-component-set"): `<c:comp name="VEVENT"/>`,
+		davName("supported-report-set"): "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>",
+	}
+	if calendar.Color != nil {
+		have[xml.Name{Space: nsApple, Local: "calendar-color"}] = escape(*calendar.Color)
+	}
+	return have, nil
+}
+
+// objectResponse answers a property request for one event resource.
+func (req *request) objectResponse(obj *object, pr propRequest) response {
+	data, etag := render(req.calendar, obj)
+	have := props{
+		davName("getetag"):        escape(etag),
+		davName("getcontenttype"): "text/calendar; charset=utf-8; component=VEVENT",
+		davName("resourcetype"):   "",
+	}
+	for _, name := range pr.names {
+		if name == calendarDataName {
+			have[calendarDataName] = escape(data)
+		}
+	}
+	return propResponse(req.s.objectHref(req.user, req.calendar, obj.name), have, pr)
+}
@@ -0,0 +1,196 @@
+package davserver
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"shared/logger"
+)
+
+func (req *request) report() {
+	if req.kind != kindCalendar {
+		writeError(req.w, http.StatusForbidden, davName("supported-report"))
+		return
+	}
+
+	body, err := parseXML(req.r.Body)
+	if err != nil {
+		http.Error(req.w, "Malformed REPORT body", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case body.is(nsCalDAV, "calendar-query"):
+		req.calendarQuery(body)
+	case body.is(nsCalDAV, "calendar-multiget"):
+		req.calendarMultiget(body)
+	case body.is(nsDAV, "sync-collection"):
+		req.syncCollection(body)
+	default:
+		writeError(req.w, http.StatusForbidden, davName("supported-report"))
+	}
+}
+
+// calendarQuery returns the events matching the query's filter. Only the
+// VEVENT component and time-range filters are understood; recurring events
+// are always returned, since the client expands them itself.
+func (req *request) calendarQuery(body *node) {
+	objects, _, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	var start, end int64
+	for _, comp := range body.find(nsCalDAV, "comp-filter") {
+		name := comp.attr("name")
+		if name != "VCALENDAR" && name != "VEVENT" {
+			// There are only events here.
+			writeMultistatus(req.w, nil, "")
+			return
+		}
+		if r := comp.child(nsCalDAV, "time-range"); r != nil {
+			start = parseUTCTime(r.attr("start"))
+			end = parseUTCTime(r.attr("end"))
+		}
+	}
+
+	pr := readPropRequest(body)
+	var responses []response
+	for _, obj := range objects {
+		if !obj.overlaps(start, end) {
+			continue
+		}
+		responses = append(responses, req.objectResponse(obj, pr))
+	}
+	writeMultistatus(req.w, responses, "")
+}
+
+// overlaps reports whether any of the object's events fall between start
+// and end, either of which may be zero for an open range.
+func (o *object) overlaps(start, end int64) bool {
+	for _, ev := range o.events {
+		if ev.Recurrence != "" {
+			return true
+		}
+		if (end == 0 || ev.StartTime < end) && (start == 0 || ev.EndTime > start) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseUTCTime(value string) int64 {
+	t, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (req *request) calendarMultiget(body *node) {
+	objects, _, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	pr := readPropRequest(body)
+	var responses []response
+	for _, href := range body.find(nsDAV, "href") {
+		target := strings.TrimSpace(href.Text)
+		if u, err := url.Parse(target); err == nil {
+			target = u.Path
+		}
+
+		var obj *object
+		if path.Dir(target)+"/" == req.s.calendarHref(req.user, req.calendar) {
+			obj = findObject(objects, path.Base(target))
+		}
+		if obj == nil {
+			responses = append(responses, response{href: target, status: http.StatusNotFound})
+			continue
+		}
+		responses = append(responses, req.objectResponse(obj, pr))
+	}
+	writeMultistatus(req.w, responses, "")
+}
+
+// syncCollection returns what changed since the client's sync token: events
+// updated after it, and the names of resources deleted since.
+func (req *request) syncCollection(body *node) {
+	objects, latest, err := loadObjects(req.calendar)
+	if err != nil {
+		logger.Error.Printf("Failed to load events of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	token, err := syncToken(req.calendar, latest)
+	if err != nil {
+		logger.Error.Printf("Failed to get sync token of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	pr := readPropRequest(body)
+	var responses []response
+
+	clientToken := strings.TrimSpace(body.child(nsDAV, "sync-token").textOrEmpty())
+	if clientToken == "" {
+		for _, obj := range objects {
+			responses = append(responses, req.objectResponse(obj, pr))
+		}
+		writeMultistatus(req.w, responses, token)
+		return
+	}
+
+	since, ok := parseSyncToken(clientToken)
+	cutoff := time.Now().Add(-tombstoneRetention).Unix()
+	if !ok || since < cutoff {
+		writeError(req.w, http.StatusForbidden, davName("valid-sync-token"))
+		return
+	}
+	if err := database.PruneEventTombstones(cutoff); err != nil {
+		logger.Warn.Printf("Failed to prune event tombstones: %v", err)
+	}
+
+	current := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		current[obj.name] = true
+		if obj.stamp > since {
+			responses = append(responses, req.objectResponse(obj, pr))
+		}
+	}
+
+	deleted, err := database.GetEventTombstones(req.calendar.ID, since+1)
+	if err != nil {
+		logger.Error.Printf("Failed to get event tombstones of calendar %s: %v", req.calendar.ID, err)
+		http.Error(req.w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	for _, name := range deleted {
+		if current[name] {
+			continue
+		}
+		current[name] = true
+		responses = append(responses, response{
+			href:   req.s.objectHref(req.user, req.calendar, name),
+			status: http.StatusNotFound,
+		})
+	}
+
+	writeMultistatus(req.w, responses, token)
+}
+
+func (n *node) textOrEmpty() string {
+	if n == nil {
+		return ""
+	}
+	return n.Text
+}
@@ -0,0 +1,205 @@
+// Package davserver exposes the user's calendars over CalDAV, so desktop and
+// mobile clients such as Thunderbird, DAVx5 and macOS Calendar can read and
+// edit them. Clients sign in with the user's email and an app password;
+// their changes go through the same write path as the REST API.
+//
+// The URL space below the prefix is:
+//
+//	/principals/{user}/                   the user's principal
+//	/calendars/{user}/                    calendar home
+//	/calendars/{user}/{calendar}/         one calendar
+//	/calendars/{user}/{calendar}/{name}   one event, with its overrides
+package davserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"shared/logger"
+)
+
+// Methods are the HTTP methods the server handles.
+var Methods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "REPORT", "MKCALENDAR",
+}
+
+// touchInterval limits how often an app password's last use is written.
+const touchInterval = time.Minute
+
+type Server struct {
+	prefix string
+}
+
+// New returns a server for the URL space below prefix, such as "/dav".
+func New(prefix string) *Server {
+	return &Server{prefix: strings.TrimRight(prefix, "/")}
+}
+
+// request is one authenticated request and the resource it addresses.
+type request struct {
+	w        http.ResponseWriter
+	r        *http.Request
+	s        *Server
+	user     *database.User
+	kind     resourceKind
+	calendar *database.Calendar
+	name     string
+}
+
+type resourceKind int
+
+const (
+	kindRoot resourceKind = iota
+	kindPrincipal
+	kindHome
+	kindCalendar
+	kindObject
+)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, calendar-access")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", strings.Join(Methods, ", "))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user := s.authenticate(r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Calendars", charset="UTF-8"`)
+		http.Error(w, "Sign in with your email and an app password", http.StatusUnauthorized)
+		return
+	}
+
+	req := &request{w: w, r: r, s: s, user: user}
+	if code := req.resolve(); code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		req.propfind()
+	case "REPORT":
+		req.report()
+	case http.MethodGet, http.MethodHead:
+		req.get()
+	case http.MethodPut:
+		req.put()
+	case http.MethodDelete:
+		req.delete()
+	default:
+		// Calendars are managed in the app; clients can't create, rename
+		// or recolor them.
+		http.Error(w, "Calendars can only be changed in the app", http.StatusForbidden)
+	}
+}
+
+// authenticate checks basic auth against the user's app passwords.
+func (s *Server) authenticate(r *http.Request) *database.User {
+	email, password, ok := r.BasicAuth()
+	if !ok || email == "" || password == "" {
+		return nil
+	}
+
+	user, err := database.GetUser(email)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	ap, err := database.CheckAppPassword(user.ID, password)
+	if err != nil {
+		logger.Error.Printf("Failed to check app password: %v", err)
+		return nil
+	}
+	if ap == nil {
+		logger.Warn.Printf("CalDAV sign-in with a wrong app password for %s", email)
+		return nil
+	}
+
+	if ap.LastUsedAt == nil || time.Since(time.Unix(*ap.LastUsedAt, 0)) > touchInterval {
+		if err := database.TouchAppPassword(ap.ID); err != nil {
+			logger.Warn.Printf("Failed to record app password use: %v", err)
+		}
+	}
+
+	return user
+}
+
+// resolve maps the URL to a resource of the signed-in user. It returns an
+// HTTP status when there is no such resource.
+func (req *request) resolve() int {
+	path := strings.TrimPrefix(req.r.URL.Path, req.s.prefix)
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	if len(segments) == 0 {
+		req.kind = kindRoot
+		return 0
+	}
+	if len(segments) < 2 {
+		return http.StatusNotFound
+	}
+	if segments[1] != req.user.ID {
+		return http.StatusForbidden
+	}
+
+	switch {
+	case segments[0] == "principals" && len(segments) == 2:
+		req.kind = kindPrincipal
+		return 0
+	case segments[0] != "calendars" || len(segments) > 4:
+		return http.StatusNotFound
+	case len(segments) == 2:
+		req.kind = kindHome
+		return 0
+	}
+
+	calendar, err := database.GetCalendarById(segments[2])
+	if err != nil {
+		logger.Error.Printf("Failed to get calendar: %v", err)
+		return http.StatusInternalServerError
+	}
+	if calendar == nil || calendar.UserID != req.user.ID || !calendar.IsActive {
+		return http.StatusNotFound
+	}
+	req.calendar = calendar
+
+	if len(segments) == 3 {
+		req.kind = kindCalendar
+		return 0
+	}
+	req.kind = kindObject
+	req.name = segments[3]
+	return 0
+}
+
+func (s *Server) principalHref(user *database.User) string {
+	return s.prefix + "/principals/" + user.ID + "/"
+}
+
+func (s *Server) homeHref(user *database.User) string {
+	return s.prefix + "/calendars/" + user.ID + "/"
+}
+
+func (s *Server) calendarHref(user *database.User, calendar *database.Calendar) string {
+	return s.homeHref(user) + calendar.ID + "/"
+}
+
+func (s *Server) objectHref(user *database.User, calendar *database.Calendar, name string) string {
+	return s.calendarHref(user, calendar) + name
+}
+
+// WellKnown redirects /.well-known/caldav to the server root, where clients
+// find the principal.
+func (s *Server) WellKnown(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, s.prefix+"/", http.StatusMovedPermanently)
+}
@@ -0,0 +1,226 @@
+package davserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"calendar-backend/database"
+	"calendar-backend/local"
+	"calendar-backend/provider"
+	shareddb "shared/database"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "davserver")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	provider.Register(local.ProviderName, local.NewCalendarService())
+
+	code := m.Run()
+	shareddb.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// davUser is a user with an app password and a local calendar.
+type davUser struct {
+	id         string
+	email      string
+	password   string
+	calendarID string
+}
+
+func createUser(t *testing.T, id string) davUser {
+	t.Helper()
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	email := id + "@example.com"
+	if _, err := db.Exec(`INSERT INTO users (id, email, token, refresh_token) VALUES (?, ?, '', '')`, id, email); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	_, password, err := database.CreateAppPassword(id, "Laptop")
+	if err != nil {
+		t.Fatalf("CreateAppPassword failed: %v", err)
+	}
+	calendarID, err := database.CreateCalendar(database.Calendar{UserID: id, Provider: local.ProviderName, Name: "Personal"})
+	if err != nil {
+		t.Fatalf("CreateCalendar failed: %v", err)
+	}
+	return davUser{id: id, email: email, password: password, calendarID: calendarID}
+}
+
+func (u davUser) calendarPath() string {
+	return "/dav/calendars/" + u.id + "/" + u.calendarID + "/"
+}
+
+func serve(method, path string, header map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	New("/dav").ServeHTTP(w, req)
+	return w
+}
+
+func (u davUser) serve(method, path string, header map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.SetBasicAuth(u.email, u.password)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	New("/dav").ServeHTTP(w, req)
+	return w
+}
+
+func vcalendar(uid, summary string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\n" +
+		"UID:" + uid + "\r\nSUMMARY:" + summary + "\r\n" +
+		"DTSTART:20260301T090000Z\r\nDTEND:20260301T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+}
+
+func TestAuthentication(t *testing.T) {
+	alice := createUser(t, "auth-alice")
+	bob := createUser(t, "auth-bob")
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		status   int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", alice.email, "abcd-efgh-ijkl-mnop", http.StatusUnauthorized},
+		{"unknown user", "nobody@example.com", alice.password, http.StatusUnauthorized},
+		{"another user's password", alice.email, bob.password, http.StatusUnauthorized},
+		{"app password", alice.email, alice.password, http.StatusMultiStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PROPFIND", alice.calendarPath(), nil)
+			req.Header.Set("Depth", "0")
+			if tt.email != "" {
+				req.SetBasicAuth(tt.email, tt.password)
+			}
+			w := httptest.NewRecorder()
+			New("/dav").ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+				t.Errorf("Expected a Basic challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// Clients probe OPTIONS before signing in.
+	if w := serve(http.MethodOptions, "/dav/", nil, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected OPTIONS to be answered without credentials, got %d", w.Code)
+	}
+}
+
+func TestOtherUsersCalendar(t *testing.T) {
+	alice := createUser(t, "other-alice")
+	bob := createUser(t, "other-bob")
+	bobEvent := bob.calendarPath() + "lunch.ics"
+	if w := bob.serve(http.MethodPut, bobEvent, nil, vcalendar("lunch", "Lunch")); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"principal", "PROPFIND", "/dav/principals/" + bob.id + "/", "", http.StatusForbidden},
+		{"calendar home", "PROPFIND", "/dav/calendars/" + bob.id + "/", "", http.StatusForbidden},
+		{"calendar", "PROPFIND", bob.calendarPath(), "", http.StatusForbidden},
+		{"event", http.MethodGet, bobEvent, "", http.StatusForbidden},
+		{"calendar below own home", "PROPFIND", "/dav/calendars/" + alice.id + "/" + bob.calendarID + "/", "", http.StatusNotFound},
+		{"event below own home", http.MethodGet, "/dav/calendars/" + alice.id + "/" + bob.calendarID + "/lunch.ics", "", http.StatusNotFound},
+		{"put below own home", http.MethodPut, "/dav/calendars/" + alice.id + "/" + bob.calendarID + "/new.ics", vcalendar("new", "Intruder"), http.StatusNotFound},
+		{"delete below own home", http.MethodDelete, "/dav/calendars/" + alice.id + "/" + bob.calendarID + "/lunch.ics", "", http.StatusNotFound},
+		{"delete", http.MethodDelete, bobEvent, "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := alice.serve(tt.method, tt.path, map[string]string{"Depth": "0"}, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	events, err := database.GetEventsByCalendarId(bob.calendarID)
+	if err != nil {
+		t.Fatalf("GetEventsByCalendarId failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Title != "Lunch" {
+		t.Errorf("Expected Bob's calendar to be untouched, got %+v", events)
+	}
+}
+
+func TestPutAndDeletePreconditions(t *testing.T) {
+	user := createUser(t, "preconditions")
+	path := user.calendarPath() + "review.ics"
+
+	w := user.serve(http.MethodPut, path, map[string]string{"If-None-Match": "*"}, vcalendar("review", "Review"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	created := w.Header().Get("ETag")
+	if created == "" {
+		t.Fatal("Expected an ETag for the created event")
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		header map[string]string
+		body   string
+		status int
+	}{
+		{"create over existing", http.MethodPut, map[string]string{"If-None-Match": "*"}, vcalendar("review", "Again"), http.StatusPreconditionFailed},
+		{"update with stale etag", http.MethodPut, map[string]string{"If-Match": `"stale"`}, vcalendar("review", "Stale"), http.StatusPreconditionFailed},
+		{"update with current etag", http.MethodPut, map[string]string{"If-Match": created}, vcalendar("review", "Review v2"), http.StatusNoContent},
+		{"delete with replaced etag", http.MethodDelete, map[string]string{"If-Match": created}, "", http.StatusPreconditionFailed},
+	}
+	for _, step := range steps {
+		if w := user.serve(step.method, path, step.header, step.body); w.Code != step.status {
+			t.Fatalf("%s: expected %d, got %d", step.name, step.status, w.Code)
+		}
+	}
+
+	w = user.serve(http.MethodGet, path, nil, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "SUMMARY:Review v2") {
+		t.Fatalf("Expected the updated event, got %d: %s", w.Code, w.Body.String())
+	}
+	current := w.Header().Get("ETag")
+	if current == created {
+		t.Error("Expected the ETag to change with the update")
+	}
+
+	if w := user.serve(http.MethodDelete, path, map[string]string{"If-Match": current}, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if w := user.serve(http.MethodGet, path, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the deleted event to be gone, got %d", w.Code)
+	}
+	if w := user.serve(http.MethodPut, path, map[string]string{"If-Match": current}, vcalendar("review", "Back")); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected If-Match on a missing event to fail, got %d", w.Code)
+	}
+}
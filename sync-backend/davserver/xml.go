@@ -0,0 +1,253 @@
+package davserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+	nsApple  = "http://apple.com/ns/ical/"
+)
+
+// prefixes are the namespace prefixes used in responses.
+var prefixes = map[string]string{
+	nsDAV:    "d",
+	nsCalDAV: "c",
+	nsCS:     "cs",
+	nsApple:  "a",
+}
+
+// node is a parsed XML element. Request bodies are small, so they are read
+// into a tree instead of being mapped onto structs for every REPORT variant.
+type node struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []*node
+	Text     string
+}
+
+func parseXML(r io.Reader) (*node, error) {
+	dec := xml.NewDecoder(r)
+	var stack []*node
+	var root *node
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{Name: t.Name, Attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, io.EOF
+	}
+	return root, nil
+}
+
+func (n *node) is(space, local string) bool {
+	return n.Name.Space == space && n.Name.Local == local
+}
+
+// child returns the first child with the given name, or nil.
+func (n *node) child(space, local string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.is(space, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns every descendant with the given name, depth first.
+func (n *node) find(space, local string) []*node {
+	var found []*node
+	for _, c := range n.Children {
+		if c.is(space, local) {
+			found = append(found, c)
+		}
+		found = append(found, c.find(space, local)...)
+	}
+	return found
+}
+
+func (n *node) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// propRequest is the <prop> list of a PROPFIND or REPORT. With all set, every
+// property the resource has is returned.
+type propRequest struct {
+	all   bool
+	names []xml.Name
+}
+
+func readPropRequest(parent *node) propRequest {
+	if parent == nil || parent.child(nsDAV, "allprop") != nil {
+		return propRequest{all: true}
+	}
+	prop := parent.child(nsDAV, "prop")
+	if prop == nil {
+		return propRequest{all: true}
+	}
+
+	var req propRequest
+	for _, c := range prop.Children {
+		req.names = append(req.names, c.Name)
+	}
+	return req
+}
+
+// props maps property names to their rendered XML content.
+type props map[xml.Name]string
+
+// response is one <response> of a multistatus.
+type response struct {
+	href   string
+	status int
+	found  props
+	// missing are requested properties the resource doesn't have.
+	missing []xml.Name
+}
+
+func propResponse(href string, have props, req propRequest) response {
+	resp := response{href: href, found: props{}}
+	if req.all {
+		resp.found = have
+		return resp
+	}
+	for _, name := range req.names {
+		if value, ok := have[name]; ok {
+			resp.found[name] = value
+		} else {
+			resp.missing = append(resp.missing, name)
+		}
+	}
+	return resp
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// element renders an empty or text element with its namespace prefix, or
+// with an inline namespace declaration when the namespace has no prefix.
+func element(name xml.Name, content string) string {
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag := prefix + ":" + name.Local
+		if content == "" {
+			return "<" + tag + "/>"
+		}
+		return "<" + tag + ">" + content + "</" + tag + ">"
+	}
+	open := "<x:" + name.Local + ` xmlns:x="` + escape(name.Space) + `"`
+	if content == "" {
+		return open + "/>"
+	}
+	return open + ">" + content + "</x:" + name.Local + ">"
+}
+
+func hrefXML(href string) string {
+	return "<d:href>" + escape(href) + "</d:href>"
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []response, syncToken string) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/" xmlns:a="http://apple.com/ns/ical/">`)
+
+	for _, resp := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(hrefXML(resp.href))
+
+		if resp.status != 0 {
+			b.WriteString("<d:status>" + statusLine(resp.status) + "</d:status>")
+		} else {
+			if len(resp.found) > 0 {
+				names := make([]xml.Name, 0, len(resp.found))
+				for name := range resp.found {
+					names = append(names, name)
+				}
+				sort.Slice(names, func(i, j int) bool {
+					if names[i].Space != names[j].Space {
+						return names[i].Space < names[j].Space
+					}
+					return names[i].Local < names[j].Local
+				})
+
+				b.WriteString("<d:propstat><d:prop>")
+				for _, name := range names {
+					b.WriteString(element(name, resp.found[name]))
+				}
+				b.WriteString("</d:prop><d:status>" + statusLine(http.StatusOK) + "</d:status></d:propstat>")
+			}
+			if len(resp.missing) > 0 {
+				b.WriteString("<d:propstat><d:prop>")
+				for _, name := range resp.missing {
+					b.WriteString(element(name, ""))
+				}
+				b.WriteString("</d:prop><d:status>" + statusLine(http.StatusNotFound) + "</d:status></d:propstat>")
+			}
+		}
+
+		b.WriteString("</d:response>")
+	}
+
+	if syncToken != "" {
+		b.WriteString("<d:sync-token>" + escape(syncToken) + "</d:sync-token>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// writeError sends a WebDAV error body naming the failed precondition.
+func writeError(w http.ResponseWriter, code int, condition xml.Name) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(code)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+		`<d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`+element(condition, "")+`</d:error>`)
+}
@@ -0,0 +1,218 @@
+// Package eventsync keeps the events of CalDAV and Outlook calendars in step
+// with their provider. Neither is synced by the watcher, so each calendar is
+// polled with its stored sync token and only fetches what changed. Outlook
+// change notifications forwarded by the watcher trigger a poll right away.
+package eventsync
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"calendar-backend/caldav"
+	"calendar-backend/database"
+	"calendar-backend/outlook"
+	"calendar-backend/provider"
+	"calendar-backend/writethrough"
+)
+
+// Providers are the providers whose calendars are polled here. Google
+// calendars are synced by the watcher, feeds and free/busy calendars by
+// their own pollers.
+var Providers = []string{caldav.ProviderName, outlook.ProviderName}
+
+// Polled reports whether calendars of the provider are synced here.
+func Polled(providerName string) bool {
+	for _, name := range Providers {
+		if name == providerName {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// locks holds a mutex per calendar, so a triggered poll and the poller
+	// don't apply the same changes or race to store the sync token.
+	locks sync.Map
+	// pending holds calendars with a triggered poll that hasn't started.
+	pending sync.Map
+)
+
+func lock(calendarID string) func() {
+	mu, _ := locks.LoadOrStore(calendarID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Poll applies what changed in the calendar since its stored sync token and
+// advances the token. The outcome, including failures, is recorded on the
+// calendar row the same way feed polls are. The calendar is read again
+// under its lock, so the token is the one the last poll stored.
+func Poll(ctx context.Context, calendarID string) (*database.EventDiff, error) {
+	unlock := lock(calendarID)
+	defer unlock()
+	return pollLocked(ctx, calendarID)
+}
+
+func pollLocked(ctx context.Context, calendarID string) (*database.EventDiff, error) {
+	cal, err := database.GetCalendarById(calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if cal == nil || !cal.IsActive {
+		return &database.EventDiff{}, nil
+	}
+
+	checkedAt := time.Now().Unix()
+
+	diff, err := poll(ctx, cal)
+	if err != nil {
+		message := err.Error()
+		if stateErr := database.UpdateCalendarFeedState(cal.ID, nil, nil, checkedAt, &message); stateErr != nil {
+			log.Printf("Failed to record sync error for calendar %s: %v", cal.ID, stateErr)
+		}
+		return nil, err
+	}
+
+	if err := database.UpdateCalendarFeedState(cal.ID, nil, nil, checkedAt, nil); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func poll(ctx context.Context, cal *database.Calendar) (*database.EventDiff, error) {
+	if cal.ConnectedAccountID == nil {
+		return nil, writethrough.ErrAccountMissing
+	}
+	account, err := database.GetConnectedAccountById(*cal.ConnectedAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, writethrough.ErrAccountMissing
+	}
+
+	p, err := provider.Get(cal.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.ListEvents(ctx, writethrough.Credentials(account), cal.ProviderCalendarID, cal.SyncToken)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]database.Event, 0, len(result.Events))
+	for _, event := range result.Events {
+		if result.Full && event.Status == "cancelled" {
+			continue
+		}
+		rows = append(rows, writethrough.StoredEvent(event))
+	}
+
+	var diff *database.EventDiff
+	if result.Full {
+		diff, err = database.ReplaceCalendarEvents(cal.ID, rows)
+	} else {
+		diff, err = database.ApplyEventChanges(cal.ID, rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes are applied before the token moves on. Should storing it
+	// fail, the next poll fetches and applies them again, which is harmless.
+	if result.NextSyncToken != "" {
+		if err := database.UpdateCalendarSyncToken(cal.ID, result.NextSyncToken); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+// Trigger polls the calendar in the background, as when the watcher
+// forwards a change notification for it. Notifications arriving before the
+// poll starts are covered by it.
+func Trigger(calendarID string) {
+	if _, queued := pending.LoadOrStore(calendarID, true); queued {
+		return
+	}
+
+	go func() {
+		unlock := lock(calendarID)
+		defer unlock()
+		pending.Delete(calendarID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		diff, err := pollLocked(ctx, calendarID)
+		report(calendarID, "triggered", diff, err)
+	}()
+}
+
+// RunPoller polls every CalDAV and Outlook calendar once per interval. It
+// never returns.
+func RunPoller(interval time.Duration) {
+	tick := time.Minute
+	if interval < tick {
+		tick = interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		pollDue(interval)
+		<-ticker.C
+	}
+}
+
+func pollDue(interval time.Duration) {
+	due := time.Now().Add(-interval).Unix()
+
+	for _, name := range Providers {
+		if _, err := provider.Get(name); err != nil {
+			// Not configured, as Outlook is without a client ID.
+			continue
+		}
+
+		calendars, err := database.GetCalendarsByProvider(name)
+		if err != nil {
+			log.Printf("Failed to list %s calendars: %v", name, err)
+			continue
+		}
+
+		for i := range calendars {
+			cal := &calendars[i]
+			if cal.FeedCheckedAt != nil && *cal.FeedCheckedAt > due {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			diff, err := Poll(ctx, cal.ID)
+			cancel()
+			report(cal.ID, "polled", diff, err)
+		}
+	}
+}
+
+func report(calendarID, how string, diff *database.EventDiff, err error) {
+	if err != nil {
+		// Accounts waiting to be reconnected fail without calling the
+		// provider, and were logged when they were marked.
+		var reauth *provider.ReauthError
+		if !errors.As(err, &reauth) {
+			log.Printf("Failed to sync calendar %s: %v", calendarID, err)
+		}
+		return
+	}
+	if diff.Added+diff.Updated+diff.Removed > 0 {
+		log.Printf("Calendar %s (%s): %d added, %d updated, %d removed",
+			calendarID, how, diff.Added, diff.Updated, diff.Removed)
+	}
+}
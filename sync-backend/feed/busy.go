@@ -0,0 +1,142 @@
+package feed
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/ical"
+)
+
+// maxOccurrences bounds how many occurrences of one recurring event count
+// towards busy time in a single query.
+const maxOccurrences = 5000
+
+// Interval is a span of time in Unix seconds.
+type Interval struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Busy returns the times the calendar's events keep its owner busy between
+// from and to, clipped to the range and unmerged. Cancelled and transparent
+// events are free time. Recurring events are expanded in the time zone they
+// were created in; rules that can't be expanded count their first occurrence
+// only.
+func Busy(cal database.Calendar, events []database.Event, from, to time.Time) []Interval {
+	// Overridden occurrences are rows of their own; the master's expansion
+	// must skip them.
+	overridden := make(map[string]map[int64]bool)
+	for _, ev := range events {
+		raw := rawVEvent(ev)
+		if raw == nil || raw.Prop("RECURRENCE-ID") == nil {
+			continue
+		}
+		t, _, err := ical.TimeZones(nil).Time(raw.Prop("RECURRENCE-ID"))
+		if err != nil {
+			continue
+		}
+		uid, _ := Identity(cal, ev)
+		if overridden[uid] == nil {
+			overridden[uid] = make(map[int64]bool)
+		}
+		overridden[uid][t.Unix()] = true
+	}
+
+	var busy []Interval
+	add := func(start, end int64) {
+		if end <= from.Unix() || start >= to.Unix() {
+			return
+		}
+		busy = append(busy, Interval{Start: max(start, from.Unix()), End: min(end, to.Unix())})
+	}
+
+	for _, ev := range events {
+		if ev.Status == "cancelled" {
+			continue
+		}
+		raw := rawVEvent(ev)
+		if transparent(ev, raw) {
+			continue
+		}
+
+		if ev.Recurrence == "" {
+			add(ev.StartTime, ev.EndTime)
+			continue
+		}
+
+		loc := eventLocation(ev, raw)
+		if loc == nil {
+			loc = time.UTC
+		}
+		start := time.Unix(ev.StartTime, 0).In(loc)
+		duration := time.Duration(ev.EndTime-ev.StartTime) * time.Second
+
+		occurrences, err := ical.Occurrences(start, strings.Split(ev.Recurrence, "\n"), from.Add(-duration), to, maxOccurrences)
+		if err != nil {
+			add(ev.StartTime, ev.EndTime)
+			continue
+		}
+
+		uid, _ := Identity(cal, ev)
+		for _, t := range occurrences {
+			if overridden[uid][t.Unix()] {
+				continue
+			}
+			add(t.Unix(), t.Add(duration).Unix())
+		}
+	}
+
+	return busy
+}
+
+// transparent reports whether the event is marked as not blocking time,
+// as all-day reminders often are.
+func transparent(ev database.Event, raw *ical.Component) bool {
+	if raw != nil {
+		return strings.EqualFold(raw.Value("TRANSP"), "TRANSPARENT")
+	}
+	if strings.HasPrefix(strings.TrimSpace(ev.RawData), "{") {
+		var google struct {
+			Transparency string `json:"transparency"`
+		}
+		if json.Unmarshal([]byte(ev.RawData), &google) == nil {
+			return google.Transparency == "transparent"
+		}
+	}
+	return false
+}
+
+// Merge sorts intervals and joins the ones that overlap or touch.
+func Merge(intervals []Interval) []Interval {
+	sorted := append([]Interval(nil), intervals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var merged []Interval
+	for _, iv := range sorted {
+		if n := len(merged); n > 0 && iv.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, iv.End)
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// Free returns the gaps between merged busy intervals within from and to.
+func Free(busy []Interval, from, to time.Time) []Interval {
+	var free []Interval
+	cursor := from.Unix()
+	for _, iv := range busy {
+		if iv.Start > cursor {
+			free = append(free, Interval{Start: cursor, End: iv.Start})
+		}
+		cursor = max(cursor, iv.End)
+	}
+	if cursor < to.Unix() {
+		free = append(free, Interval{Start: cursor, End: to.Unix()})
+	}
+	return free
+}
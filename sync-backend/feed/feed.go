@@ -0,0 +1,268 @@
+// Package feed renders local calendars as iCalendar feeds and works out the
+// busy time their events add up to.
+package feed
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/ical"
+)
+
+const prodID = "-//calendar-backend//Feed//EN"
+
+// How far VTIMEZONE definitions reach past the last event, so recurring
+// events keep correct local times in the years ahead.
+const zoneHorizon = 5 * 365 * 24 * time.Hour
+
+type Options struct {
+	Name string
+	// BusyOnly hides everything but the time slots.
+	BusyOnly bool
+}
+
+// Source is one calendar and its events.
+type Source struct {
+	Calendar database.Calendar
+	Events   []database.Event
+}
+
+// Build renders the events of the sources as a VCALENDAR. Cancelled events
+// are left out. Timed events keep the time zone they were created in when it
+// is known, so recurrences expand correctly across DST changes; a VTIMEZONE
+// is included for every zone used.
+func Build(sources []Source, opts Options) *ical.Component {
+	cal := ical.NewCalendar(prodID)
+	cal.Add("METHOD", "PUBLISH")
+	if opts.Name != "" {
+		cal.Add("X-WR-CALNAME", ical.EscapeText(opts.Name))
+	}
+
+	zones := make(map[string]*time.Location)
+	var vevents []*ical.Component
+	var earliest, latest time.Time
+
+	for _, source := range sources {
+		for _, ev := range source.Events {
+			if ev.Status == "cancelled" {
+				continue
+			}
+
+			raw := rawVEvent(ev)
+			loc := eventLocation(ev, raw)
+			if loc != nil {
+				zones[loc.String()] = loc
+			}
+
+			start, end := time.Unix(ev.StartTime, 0), time.Unix(ev.EndTime, 0)
+			if earliest.IsZero() || start.Before(earliest) {
+				earliest = start
+			}
+			if end.After(latest) {
+				latest = end
+			}
+
+			vevents = append(vevents, eventComponent(source.Calendar, ev, raw, loc, opts, zones))
+		}
+	}
+
+	if len(zones) > 0 {
+		from := earliest.AddDate(-1, 0, 0)
+		to := time.Now().Add(zoneHorizon)
+		if latest.After(to) {
+			to = latest.Add(zoneHorizon)
+		}
+		for _, loc := range zones {
+			cal.Children = append(cal.Children, ical.TimeZoneComponent(loc, from, to))
+		}
+	}
+
+	cal.Children = append(cal.Children, vevents...)
+	return cal
+}
+
+// Resource renders events as one CalDAV calendar object: a VCALENDAR with
+// the VEVENTs and the VTIMEZONEs they use. DTSTAMP is each event's last
+// update, so a row always renders the same way. A non-empty uid replaces the
+// events' UID.
+func Resource(cal database.Calendar, events []database.Event, uid string) *ical.Component {
+	out := Build([]Source{{Calendar: cal, Events: events}}, Options{})
+
+	vevents := out.ChildrenNamed("VEVENT")
+	i := 0
+	for _, ev := range events {
+		if ev.Status == "cancelled" {
+			continue
+		}
+		vevents[i].Set("DTSTAMP", time.Unix(ev.UpdatedAt, 0).UTC().Format("20060102T150405Z"))
+		if uid != "" {
+			vevents[i].Set("UID", uid)
+		}
+		i++
+	}
+
+	return out
+}
+
+// Identity returns the UID an event gets in feeds, and whether it overrides
+// a single occurrence of a recurring event with that UID.
+func Identity(cal database.Calendar, ev database.Event) (string, bool) {
+	raw := rawVEvent(ev)
+	if raw != nil && raw.Value("UID") != "" {
+		return raw.Value("UID"), raw.Prop("RECURRENCE-ID") != nil
+	}
+	return ev.ProviderEventID + "@" + cal.ID, false
+}
+
+func eventComponent(cal database.Calendar, ev database.Event, raw *ical.Component, loc *time.Location, opts Options, zones map[string]*time.Location) *ical.Component {
+	// Keep the original UID of events that came from iCalendar data, so
+	// subscribers see the same event across sources.
+	uid := ev.ProviderEventID + "@" + cal.ID
+	if raw != nil && raw.Value("UID") != "" {
+		uid = raw.Value("UID")
+	}
+
+	vevent := ical.EventComponent(ical.Event{
+		UID:    uid,
+		Start:  time.Unix(ev.StartTime, 0),
+		End:    time.Unix(ev.EndTime, 0),
+		AllDay: ev.IsAllDay,
+		Status: ev.Status,
+	})
+
+	if loc != nil && !ev.IsAllDay {
+		setLocalTime(vevent, "DTSTART", time.Unix(ev.StartTime, 0), loc)
+		setLocalTime(vevent, "DTEND", time.Unix(ev.EndTime, 0), loc)
+	}
+
+	if raw != nil {
+		if recurrenceID := raw.Prop("RECURRENCE-ID"); recurrenceID != nil {
+			if tzid := recurrenceID.Param("TZID"); tzid == "" || addZone(zones, tzid) {
+				vevent.Props = append(vevent.Props, recurrenceID)
+			}
+		}
+	}
+
+	for _, line := range recurrenceLines(ev.Recurrence) {
+		// Every TZID a line refers to needs a VTIMEZONE; lines with zones
+		// Go doesn't know would make the feed invalid and are dropped.
+		if tzid := line.Param("TZID"); tzid != "" && !addZone(zones, tzid) {
+			continue
+		}
+		vevent.Props = append(vevent.Props, line)
+	}
+
+	if opts.BusyOnly {
+		vevent.Add("SUMMARY", "Busy")
+		vevent.Add("CLASS", "PRIVATE")
+		return vevent
+	}
+
+	if ev.Title != "" {
+		vevent.Add("SUMMARY", ical.EscapeText(ev.Title))
+	}
+	if ev.Description != "" {
+		vevent.Add("DESCRIPTION", ical.EscapeText(ev.Description))
+	}
+	if ev.Location != "" {
+		vevent.Add("LOCATION", ical.EscapeText(ev.Location))
+	}
+
+	var attendees []string
+	if ev.Attendees != "" && json.Unmarshal([]byte(ev.Attendees), &attendees) == nil {
+		for _, email := range attendees {
+			vevent.Add("ATTENDEE", "mailto:"+email)
+		}
+	}
+
+	return vevent
+}
+
+func setLocalTime(vevent *ical.Component, name string, t time.Time, loc *time.Location) {
+	p := vevent.Set(name, t.In(loc).Format("20060102T150405"))
+	p.SetParam("TZID", loc.String())
+}
+
+func addZone(zones map[string]*time.Location, tzid string) bool {
+	if _, ok := zones[tzid]; ok {
+		return true
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return false
+	}
+	zones[tzid] = loc
+	return true
+}
+
+func recurrenceLines(recurrence string) []*ical.Property {
+	if recurrence == "" {
+		return nil
+	}
+
+	wrapped := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n" + recurrence + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	cal, err := ical.Parse(strings.NewReader(wrapped))
+	if err != nil {
+		return nil
+	}
+	return cal.Children[0].Props
+}
+
+// rawVEvent parses the stored iCalendar data of events synced from CalDAV or
+// ICS feeds. It returns nil for events from other providers.
+func rawVEvent(ev database.Event) *ical.Component {
+	raw := strings.TrimSpace(ev.RawData)
+	if !strings.HasPrefix(raw, "BEGIN:") {
+		return nil
+	}
+	if strings.HasPrefix(raw, "BEGIN:VEVENT") {
+		raw = "BEGIN:VCALENDAR\r\n" + raw + "\r\nEND:VCALENDAR\r\n"
+	}
+
+	cal, err := ical.Parse(strings.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	vevents := cal.ChildrenNamed("VEVENT")
+	if len(vevents) == 0 {
+		return nil
+	}
+	for _, vevent := range vevents {
+		if vevent.Prop("RECURRENCE-ID") == nil {
+			return vevent
+		}
+	}
+	return vevents[0]
+}
+
+// eventLocation returns the IANA time zone the event was created in, taken
+// from its raw iCalendar or Google data.
+func eventLocation(ev database.Event, raw *ical.Component) *time.Location {
+	var tzid string
+
+	if raw != nil {
+		if start := raw.Prop("DTSTART"); start != nil {
+			tzid = start.Param("TZID")
+		}
+	} else if strings.HasPrefix(strings.TrimSpace(ev.RawData), "{") {
+		var google struct {
+			Start struct {
+				TimeZone string `json:"timeZone"`
+			} `json:"start"`
+		}
+		if json.Unmarshal([]byte(ev.RawData), &google) == nil {
+			tzid = google.Start.TimeZone
+		}
+	}
+
+	if tzid == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil || loc == time.UTC {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,154 @@
+// Package freebusy keeps calendars the user can only see free/busy
+// information of, such as a colleague's calendar shared that way. Their
+// events are opaque busy blocks, fetched with the provider's free/busy query
+// and replaced wholesale on every poll.
+package freebusy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/google"
+	"calendar-backend/provider"
+	"calendar-backend/writethrough"
+)
+
+// Google rejects free/busy queries spanning much more than two months.
+const (
+	lookBehind = 24 * time.Hour
+	lookAhead  = 56 * 24 * time.Hour
+)
+
+// ErrNotSupported is returned for accounts whose provider has no free/busy
+// query.
+var ErrNotSupported = errors.New("account's provider does not offer free/busy access")
+
+// Query fetches the busy blocks of a calendar through the account, for the
+// window a free/busy calendar keeps.
+func Query(ctx context.Context, account *database.ConnectedAccount, calendarID string) ([]provider.BusyBlock, error) {
+	p, err := provider.Get(account.Provider)
+	if err != nil {
+		return nil, err
+	}
+	querier, ok := p.(provider.FreeBusyQuerier)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	now := time.Now()
+	return querier.QueryFreeBusy(ctx, writethrough.Credentials(account), calendarID, now.Add(-lookBehind), now.Add(lookAhead))
+}
+
+// ToEvents converts busy blocks to event rows with no details. Blocks are
+// keyed by their times, so an unchanged block keeps its row.
+func ToEvents(blocks []provider.BusyBlock) []database.Event {
+	events := make([]database.Event, 0, len(blocks))
+	for _, block := range blocks {
+		id := fmt.Sprintf("busy-%d-%d", block.Start, block.End)
+		events = append(events, database.Event{
+			ProviderEventID: id,
+			StartTime:       block.Start,
+			EndTime:         block.End,
+			Status:          "confirmed",
+			Etag:            id,
+		})
+	}
+	return events
+}
+
+// Poll refreshes one free/busy calendar. The outcome, including failures,
+// is recorded on the calendar row the same way feed polls are.
+func Poll(ctx context.Context, cal *database.Calendar) (*database.EventDiff, error) {
+	checkedAt := time.Now().Unix()
+
+	diff, err := poll(ctx, cal)
+	if err != nil {
+		message := err.Error()
+		if stateErr := database.UpdateCalendarFeedState(cal.ID, nil, nil, checkedAt, &message); stateErr != nil {
+			log.Printf("Failed to record free/busy error for calendar %s: %v", cal.ID, stateErr)
+		}
+		return nil, err
+	}
+
+	if err := database.UpdateCalendarFeedState(cal.ID, nil, nil, checkedAt, nil); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func poll(ctx context.Context, cal *database.Calendar) (*database.EventDiff, error) {
+	if cal.ConnectedAccountID == nil {
+		return nil, writethrough.ErrAccountMissing
+	}
+	account, err := database.GetConnectedAccountById(*cal.ConnectedAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, writethrough.ErrAccountMissing
+	}
+
+	blocks, err := Query(ctx, account, cal.ProviderCalendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.ReplaceCalendarEvents(cal.ID, ToEvents(blocks))
+}
+
+// RunPoller refreshes every free/busy calendar once per interval. It never
+// returns.
+func RunPoller(interval time.Duration) {
+	tick := time.Minute
+	if interval < tick {
+		tick = interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		pollDue(interval)
+		<-ticker.C
+	}
+}
+
+func pollDue(interval time.Duration) {
+	calendars, err := database.GetCalendarsByProvider(google.FreeBusyProviderName)
+	if err != nil {
+		log.Printf("Failed to list free/busy calendars: %v", err)
+		return
+	}
+
+	due := time.Now().Add(-interval).Unix()
+
+	for i := range calendars {
+		cal := &calendars[i]
+		if cal.FeedCheckedAt != nil && *cal.FeedCheckedAt > due {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		diff, err := Poll(ctx, cal)
+		cancel()
+
+		if err != nil {
+			// Accounts waiting to be reconnected fail without calling the
+			// provider, and were logged when they were marked.
+			var reauth *provider.ReauthError
+			if !errors.As(err, &reauth) {
+				log.Printf("Failed to refresh free/busy calendar %s: %v", cal.ID, err)
+			}
+			continue
+		}
+		if diff.Added+diff.Updated+diff.Removed > 0 {
+			log.Printf("Free/busy calendar %s: %d added, %d updated, %d removed",
+				cal.ID, diff.Added, diff.Updated, diff.Removed)
+		}
+	}
+}
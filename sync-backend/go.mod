@@ -19,6 +19,8 @@ require (
 	shared/jwt v0.0.0
 	shared/logger v0.0.0
 	shared/middleware v0.0.0
+	shared/oauthtoken v0.0.0
+	shared/ratelimit v0.0.0
 )
 
 replace shared/jwt => ../shared/jwt
@@ -29,6 +31,10 @@ replace shared/logger => ../shared/logger
 
 replace shared/middleware => ../shared/middleware
 
+replace shared/oauthtoken => ../shared/oauthtoken
+
+replace shared/ratelimit => ../shared/ratelimit
+
 require (
 	cloud.google.com/go/auth v0.13.0 // indirect
 	cloud.google.com/go/auth/oauth2adapt v0.2.6 // indirect
@@ -2,46 +2,40 @@ package google
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+
+	"calendar-backend/provider"
+	"calendar-backend/tokensource"
+	"shared/ratelimit"
 )
 
+const ProviderName = "google"
+
+// FreeBusyProviderName marks calendars added by free/busy access only. Their
+// events are busy blocks from freebusy.query, kept by the freebusy poller.
+const FreeBusyProviderName = "google_freebusy"
+
 type CalendarService struct {
 	clientID     string
 	clientSecret string
 }
 
-type AvailableCalendar struct {
-	ProviderCalendarID string
-	Name               string
-	Color              *string
-	IsPrimary          bool
-}
-
-type CalendarEvent struct {
-	ProviderEventID string
-	Title           string
-	Description     string
-	Location        string
-	StartTime       int64
-	EndTime         int64
-	IsAllDay        bool
-	Status          string
-	Recurrence      string
-	Attendees       string
-	Etag            string
-	RawData         string
-}
-
-type EventsResult struct {
-	Events        []CalendarEvent
-	NextSyncToken string
-}
+var (
+	_ provider.Provider        = (*CalendarService)(nil)
+	_ provider.FreeBusyQuerier = (*CalendarService)(nil)
+)
 
 func NewCalendarService(clientID, clientSecret string) *CalendarService {
 	return &CalendarService{
@@ -59,25 +53,15 @@ func (s *CalendarService) getOAuthConfig() *oauth2.Config {
 	}
 }
 
-func (s *CalendarService) getClient(ctx context.Context, accessToken, refreshToken string) (*calendar.Service, error) {
-	config := s.getOAuthConfig()
-
-	// Set expiry to past so oauth2 will always try to refresh if refresh token is available
-	token := &oauth2.Token{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		TokenType:    "Bearer",
-	}
-
-	tokenSource := config.TokenSource(ctx, token)
+func (s *CalendarService) getClient(ctx context.Context, creds provider.Credentials) (*calendar.Service, error) {
+	tokenSource := tokensource.New(ctx, s.getOAuthConfig(), creds)
 
-	validToken, err := tokenSource.Token()
-	if err != nil {
+	if _, err := tokenSource.Token(); err != nil {
 		log.Printf("Failed to get valid token: %v", err)
 		return nil, fmt.Errorf("failed to get valid token: %w", err)
 	}
 
-	client := config.Client(ctx, validToken)
+	client := oauth2.NewClient(ctx, tokenSource)
 
 	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -87,21 +71,20 @@ func (s *CalendarService) getClient(ctx context.Context, accessToken, refreshTok
 	return srv, nil
 }
 
-func (s *CalendarService) ListCalendars(accessToken, refreshToken string) ([]AvailableCalendar, error) {
-	ctx := context.Background()
-	srv, err := s.getClient(ctx, accessToken, refreshToken)
+func (s *CalendarService) ListCalendars(ctx context.Context, creds provider.Credentials) ([]provider.AvailableCalendar, error) {
+	srv, err := s.getClient(ctx, creds)
 	if err != nil {
 		return nil, err
 	}
 
-	list, err := srv.CalendarList.List().Do()
+	list, err := srv.CalendarList.List().Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list calendars: %w", err)
+		return nil, fmt.Errorf("failed to list calendars: %w", rateLimited(err))
 	}
 
-	var calendars []AvailableCalendar
+	var calendars []provider.AvailableCalendar
 	for _, item := range list.Items {
-		cal := AvailableCalendar{
+		cal := provider.AvailableCalendar{
 			ProviderCalendarID: item.Id,
 			Name:               item.Summary,
 			IsPrimary:          item.Primary,
@@ -115,9 +98,8 @@ func (s *CalendarService) ListCalendars(accessToken, refreshToken string) ([]Ava
 	return calendars, nil
 }
 
-func (s *CalendarService) GetCalendarEvents(accessToken, refreshToken, calendarID string, syncToken *string) (*EventsResult, error) {
-	ctx := context.Background()
-	srv, err := s.getClient(ctx, accessToken, refreshToken)
+func (s *CalendarService) ListEvents(ctx context.Context, creds provider.Credentials, calendarID string, syncToken *string) (*provider.EventsResult, error) {
+	srv, err := s.getClient(ctx, creds)
 	if err != nil {
 		return nil, err
 	}
@@ -126,12 +108,13 @@ func (s *CalendarService) GetCalendarEvents(accessToken, refreshToken, calendarI
 		ShowDeleted(true).
 		SingleEvents(true)
 
+	result := &provider.EventsResult{
+		Events: []provider.CalendarEvent{},
+		Full:   true,
+	}
 	if syncToken != nil && *syncToken != "" {
 		call = call.SyncToken(*syncToken)
-	}
-
-	result := &EventsResult{
-		Events: []CalendarEvent{},
+		result.Full = false
 	}
 
 	err = call.Pages(ctx, func(events *calendar.Events) error {
@@ -147,14 +130,154 @@ func (s *CalendarService) GetCalendarEvents(accessToken, refreshToken, calendarI
 
 	if err != nil {
 		log.Printf("Error fetching events: %v", err)
-		return nil, fmt.Errorf("failed to fetch events: %w", err)
+		return nil, fmt.Errorf("failed to fetch events: %w", rateLimited(err))
 	}
 
 	return result, nil
 }
 
-func (s *CalendarService) convertEvent(event *calendar.Event) CalendarEvent {
-	calEvent := CalendarEvent{
+func (s *CalendarService) Watch(ctx context.Context, creds provider.Credentials, calendarID string, req provider.WatchRequest) (*provider.Channel, error) {
+	srv, err := s.getClient(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := srv.Events.Watch(calendarID, &calendar.Channel{
+		Id:      req.ChannelID,
+		Type:    "web_hook",
+		Address: req.Address,
+		Token:   req.Token,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch calendar: %w", rateLimited(err))
+	}
+
+	// Google reports the expiration in milliseconds.
+	return &provider.Channel{
+		ID:         channel.Id,
+		ResourceID: channel.ResourceId,
+		Expiry:     channel.Expiration / 1000,
+	}, nil
+}
+
+func (s *CalendarService) CreateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	srv, err := s.getClient(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := srv.Events.Insert(calendarID, toGoogleEvent(event)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event: %w", rateLimited(err))
+	}
+
+	result := s.convertEvent(created)
+	return &result, nil
+}
+
+func (s *CalendarService) UpdateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	srv, err := s.getClient(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := srv.Events.Update(calendarID, event.ProviderEventID, toGoogleEvent(event)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", rateLimited(err))
+	}
+
+	result := s.convertEvent(updated)
+	return &result, nil
+}
+
+func (s *CalendarService) DeleteEvent(ctx context.Context, creds provider.Credentials, calendarID, eventID string) error {
+	srv, err := s.getClient(ctx, creds)
+	if err != nil {
+		return err
+	}
+
+	if err := srv.Events.Delete(calendarID, eventID).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to delete event: %w", rateLimited(err))
+	}
+
+	return nil
+}
+
+// rateLimitReasons are the error reasons Google uses for quota errors it
+// sends as 403 instead of 429.
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// rateLimited turns Google's quota errors into provider.RateLimitError, so
+// callers can back off instead of failing.
+func rateLimited(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	limited := apiErr.Code == http.StatusTooManyRequests
+	if apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			if rateLimitReasons[item.Reason] {
+				limited = true
+			}
+		}
+	}
+	if !limited {
+		return err
+	}
+
+	return &provider.RateLimitError{RetryAfter: ratelimit.ParseRetryAfter(apiErr.Header.Get("Retry-After")), Err: err}
+}
+
+// QueryFreeBusy returns the busy blocks of a calendar the account may only
+// see free/busy information of, such as a colleague's primary calendar.
+func (s *CalendarService) QueryFreeBusy(ctx context.Context, creds provider.Credentials, calendarID string, from, to time.Time) ([]provider.BusyBlock, error) {
+	srv, err := s.getClient(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := srv.Freebusy.Query(&calendar.FreeBusyRequest{
+		TimeMin: from.UTC().Format(time.RFC3339),
+		TimeMax: to.UTC().Format(time.RFC3339),
+		Items:   []*calendar.FreeBusyRequestItem{{Id: calendarID}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query free/busy: %w", rateLimited(err))
+	}
+
+	fb, ok := resp.Calendars[calendarID]
+	if !ok {
+		return nil, fmt.Errorf("no free/busy information for %s", calendarID)
+	}
+	if len(fb.Errors) > 0 {
+		// notFound is also what Google reports when the calendar isn't
+		// shared with the account at all.
+		return nil, fmt.Errorf("free/busy of %s unavailable: %s", calendarID, fb.Errors[0].Reason)
+	}
+
+	blocks := make([]provider.BusyBlock, 0, len(fb.Busy))
+	for _, period := range fb.Busy {
+		start, err := time.Parse(time.RFC3339, period.Start)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, period.End)
+		if err != nil {
+			continue
+		}
+		blocks = append(blocks, provider.BusyBlock{Start: start.Unix(), End: end.Unix()})
+	}
+
+	return blocks, nil
+}
+
+func (s *CalendarService) convertEvent(event *calendar.Event) provider.CalendarEvent {
+	calEvent := provider.CalendarEvent{
 		ProviderEventID: event.Id,
 		Title:           event.Summary,
 		Description:     event.Description,
@@ -164,43 +287,87 @@ func (s *CalendarService) convertEvent(event *calendar.Event) CalendarEvent {
 	}
 
 	if event.Start != nil {
-		if event.Start.DateTime != "" {
-			calEvent.IsAllDay = false
-		} else if event.Start.Date != "" {
-			calEvent.IsAllDay = true
-		}
+		calEvent.StartTime, calEvent.IsAllDay = parseEventTime(event.Start)
+		calEvent.TimeZone = event.Start.TimeZone
 	}
 
 	if event.End != nil {
-		if event.End.DateTime != "" {
-		} else if event.End.Date != "" {
-		}
+		calEvent.EndTime, _ = parseEventTime(event.End)
 	}
 
 	if len(event.Recurrence) > 0 {
-		calEvent.Recurrence = fmt.Sprintf("%v", event.Recurrence)
+		calEvent.Recurrence = strings.Join(event.Recurrence, "\n")
 	}
 
 	if len(event.Attendees) > 0 {
-		calEvent.Attendees = fmt.Sprintf("%v", event.Attendees)
+		emails := make([]string, 0, len(event.Attendees))
+		for _, attendee := range event.Attendees {
+			emails = append(emails, attendee.Email)
+		}
+		if data, err := json.Marshal(emails); err == nil {
+			calEvent.Attendees = string(data)
+		}
+	}
+
+	if data, err := json.Marshal(event); err == nil {
+		calEvent.RawData = string(data)
 	}
 
 	return calEvent
 }
 
-func (s *CalendarService) RefreshAccessToken(refreshToken string) (string, error) {
-	ctx := context.Background()
-	config := s.getOAuthConfig()
+// parseEventTime returns the Unix time of an event boundary and whether it is
+// a date-only (all-day) value.
+func parseEventTime(t *calendar.EventDateTime) (int64, bool) {
+	if t.DateTime != "" {
+		if parsed, err := time.Parse(time.RFC3339, t.DateTime); err == nil {
+			return parsed.Unix(), false
+		}
+	} else if t.Date != "" {
+		if parsed, err := time.Parse("2006-01-02", t.Date); err == nil {
+			return parsed.Unix(), true
+		}
+	}
+	return 0, false
+}
 
-	token := &oauth2.Token{
-		RefreshToken: refreshToken,
+func toGoogleEvent(event provider.CalendarEvent) *calendar.Event {
+	gEvent := &calendar.Event{
+		Summary:     event.Title,
+		Description: event.Description,
+		Location:    event.Location,
+		Status:      event.Status,
 	}
 
-	tokenSource := config.TokenSource(ctx, token)
-	newToken, err := tokenSource.Token()
-	if err != nil {
-		return "", fmt.Errorf("failed to refresh token: %w", err)
+	if event.IsAllDay {
+		gEvent.Start = &calendar.EventDateTime{Date: time.Unix(event.StartTime, 0).UTC().Format("2006-01-02")}
+		gEvent.End = &calendar.EventDateTime{Date: time.Unix(event.EndTime, 0).UTC().Format("2006-01-02")}
+	} else {
+		// Google requires a time zone on recurring events and expands them
+		// in it, so a weekly 9:00 stays at 9:00 across DST changes.
+		loc, zone := time.UTC, "UTC"
+		if event.TimeZone != "" {
+			if l, err := time.LoadLocation(event.TimeZone); err == nil {
+				loc, zone = l, event.TimeZone
+			}
+		}
+		gEvent.Start = &calendar.EventDateTime{
+			DateTime: time.Unix(event.StartTime, 0).In(loc).Format(time.RFC3339),
+			TimeZone: zone,
+		}
+		gEvent.End = &calendar.EventDateTime{
+			DateTime: time.Unix(event.EndTime, 0).In(loc).Format(time.RFC3339),
+			TimeZone: zone,
+		}
+	}
+
+	if event.Recurrence != "" {
+		gEvent.Recurrence = strings.Split(event.Recurrence, "\n")
 	}
 
-	return newToken.AccessToken, nil
+	return gEvent
+}
+
+func (s *CalendarService) RefreshCredentials(ctx context.Context, creds provider.Credentials) (*provider.Credentials, error) {
+	return tokensource.Refresh(ctx, s.getOAuthConfig(), creds)
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/config"
+	"calendar-backend/database"
+	"shared/logger"
+)
+
+type CreateAppPasswordRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+func HandleCreateAppPassword(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req CreateAppPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	id, password, err := database.CreateAppPassword(user.ID, req.Name)
+	if err != nil {
+		logger.Error.Printf("Failed to create app password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create app password"})
+		return
+	}
+
+	// Like feed tokens, the password is only ever shown here.
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"name":       req.Name,
+		"password":   password,
+		"username":   user.Email,
+		"server_url": config.Cfg.PublicURL + "/dav/",
+	})
+}
+
+func HandleGetAppPasswords(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	passwords, err := database.GetAppPasswordsByUserId(user.ID)
+	if err != nil {
+		logger.Error.Printf("Failed to get app passwords: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get app passwords"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(passwords))
+	for _, ap := range passwords {
+		result = append(result, gin.H{
+			"id":           ap.ID,
+			"name":         ap.Name,
+			"last_used_at": ap.LastUsedAt,
+			"created_at":   ap.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"app_passwords": result})
+}
+
+func HandleDeleteAppPassword(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	ap, err := database.GetAppPasswordById(c.Param("id"))
+	if err != nil {
+		logger.Error.Printf("Failed to get app password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get app password"})
+		return
+	}
+	if ap == nil || ap.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "App password not found"})
+		return
+	}
+
+	if err := database.DeleteAppPassword(ap.ID); err != nil {
+		logger.Error.Printf("Failed to delete app password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete app password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/database"
+	"shared/jwt"
+)
+
+func appPasswordRouter() *gin.Engine {
+	r := gin.New()
+	r.GET("/api/app-passwords", HandleGetAppPasswords)
+	r.POST("/api/app-passwords", HandleCreateAppPassword)
+	r.DELETE("/api/app-passwords/:id", HandleDeleteAppPassword)
+	return r
+}
+
+// signIn stores a user and returns the session cookie the auth server would
+// have set.
+func signIn(t *testing.T, id string) *http.Cookie {
+	t.Helper()
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	email := id + "@example.com"
+	_, err = db.Exec(`INSERT INTO users (id, email, token, refresh_token) VALUES (?, ?, '', '')`, id, email)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	token, err := jwt.GenerateJWT(email)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+	return &http.Cookie{Name: "JWT", Value: token}
+}
+
+func serveAppPasswords(cookie *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	appPasswordRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestAppPasswords(t *testing.T) {
+	alice := signIn(t, "ap-alice")
+	bob := signIn(t, "ap-bob")
+
+	if w := serveAppPasswords(nil, http.MethodPost, "/api/app-passwords", `{"name":"Laptop"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a session, got %d", w.Code)
+	}
+	if w := serveAppPasswords(alice, http.MethodPost, "/api/app-passwords", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a name, got %d", w.Code)
+	}
+
+	w := serveAppPasswords(alice, http.MethodPost, "/api/app-passwords", `{"name":"Laptop"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID       string `json:"id"`
+		Password string `json:"password"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.Username != "ap-alice@example.com" || len(created.Password) != 19 {
+		t.Errorf("Expected a password for ap-alice@example.com, got %+v", created)
+	}
+
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	var hash string
+	if err := db.QueryRow(`SELECT password_hash FROM app_passwords WHERE id = ?`, created.ID).Scan(&hash); err != nil {
+		t.Fatalf("Failed to read app password: %v", err)
+	}
+	if hash == created.Password || strings.Contains(hash, created.Password) {
+		t.Error("Expected only a hash of the password to be stored")
+	}
+
+	w = serveAppPasswords(alice, http.MethodGet, "/api/app-passwords", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), created.ID) || strings.Contains(w.Body.String(), created.Password) {
+		t.Errorf("Expected the password to be listed without its value, got %s", w.Body.String())
+	}
+	if w := serveAppPasswords(bob, http.MethodGet, "/api/app-passwords", ""); strings.Contains(w.Body.String(), created.ID) {
+		t.Errorf("Expected other users not to see the password, got %s", w.Body.String())
+	}
+
+	if w := serveAppPasswords(bob, http.MethodDelete, "/api/app-passwords/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting another user's password, got %d", w.Code)
+	}
+	if ap, err := database.CheckAppPassword("ap-alice", created.Password); err != nil || ap == nil {
+		t.Fatalf("Expected the password to still work, got %v", err)
+	}
+
+	if w := serveAppPasswords(alice, http.MethodDelete, "/api/app-passwords/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if ap, err := database.CheckAppPassword("ap-alice", created.Password); err != nil || ap != nil {
+		t.Errorf("Expected the deleted password to be refused, got %+v, %v", ap, err)
+	}
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/caldav"
+	"calendar-backend/config"
+	"calendar-backend/database"
+	"calendar-backend/outlook"
+	"calendar-backend/provider"
+	"shared/logger"
+)
+
+const outlookStateCookie = "outlook_connect_state"
+
+func outlookService() *outlook.CalendarService {
+	p, err := provider.Get(outlook.ProviderName)
+	if err != nil {
+		return nil
+	}
+	svc, _ := provider.Unwrap(p).(*outlook.CalendarService)
+	return svc
+}
+
+// HandleConnectOutlook starts the Microsoft consent flow for the signed-in
+// user. The state is kept in a short-lived cookie and checked on callback.
+// An optional login_hint preselects the account to reconnect.
+func HandleConnectOutlook(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	svc := outlookService()
+	if svc == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Outlook is not configured"})
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start connect flow"})
+		return
+	}
+	state := hex.EncodeToString(buf)
+
+	c.SetCookie(outlookStateCookie, state, 600, "/api/connect/outlook", "", false, true)
+	c.Redirect(http.StatusFound, svc.AuthCodeURL(state, c.Query("login_hint")))
+}
+
+// HandleConnectOutlookCallback exchanges the authorization code and stores
+// the Outlook account as a connected account of the signed-in user.
+func HandleConnectOutlookCallback(c *gin.Context) {
+	cfg := config.Cfg
+
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	fail := func(message string) {
+		c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?error="+url.QueryEscape(message))
+	}
+
+	state, _ := c.Cookie(outlookStateCookie)
+	c.SetCookie(outlookStateCookie, "", -1, "/api/connect/outlook", "", false, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		logger.Warn.Printf("Outlook connect callback with invalid state")
+		fail("Invalid state")
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		logger.Warn.Printf("Outlook connect denied: %s %s", errCode, c.Query("error_description"))
+		fail("Outlook connection was cancelled")
+		return
+	}
+
+	svc := outlookService()
+	if svc == nil {
+		fail("Outlook is not configured")
+		return
+	}
+
+	ctx := c.Request.Context()
+	creds, err := svc.Exchange(ctx, c.Query("code"))
+	if err != nil {
+		logger.Error.Printf("Outlook code exchange failed: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	profile, err := svc.GetProfile(ctx, *creds)
+	if err != nil {
+		logger.Error.Printf("Failed to get Outlook profile: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	var tokenExpiry *int64
+	if creds.Expiry != nil {
+		expiry := creds.Expiry.Unix()
+		tokenExpiry = &expiry
+	}
+
+	existing, err := database.GetConnectedAccountByProviderAccountId(user.ID, outlook.ProviderName, profile.ID)
+	if err != nil {
+		logger.Error.Printf("Failed to check connected account: %v", err)
+		fail("Failed to connect account")
+		return
+	}
+
+	if existing != nil {
+		err = database.UpdateConnectedAccountTokens(existing.ID, creds.AccessToken, creds.RefreshToken, tokenExpiry)
+		if err != nil {
+			logger.Error.Printf("Failed to update connected account tokens: %v", err)
+		}
+	} else {
+		_, err = database.CreateConnectedAccount(database.ConnectedAccount{
+			UserID:            user.ID,
+			Provider:          outlook.ProviderName,
+			ProviderAccountID: profile.ID,
+			Email:             profile.Email(),
+			AccessToken:       creds.AccessToken,
+			RefreshToken:      creds.RefreshToken,
+			TokenExpiry:       tokenExpiry,
+		})
+		if err != nil {
+			logger.Error.Printf("Failed to create connected account: %v", err)
+			fail("Failed to connect account")
+			return
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?connected="+url.QueryEscape(profile.Email()))
+}
+
+// ConnectCalDAVRequest connects a CalDAV account with an app password.
+// Service picks a known server ("icloud", "fastmail"); otherwise ServerURL
+// is required.
+type ConnectCalDAVRequest struct {
+	Service   string `json:"service"`
+	ServerURL string `json:"server_url"`
+	Username  string `json:"username" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+}
+
+func HandleConnectCalDAV(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req ConnectCalDAVRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+
+	serverURL := strings.TrimSpace(req.ServerURL)
+	if req.Service != "" {
+		known, ok := caldav.KnownServers[strings.ToLower(req.Service)]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service " + req.Service})
+			return
+		}
+		serverURL = known
+	}
+	if serverURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server_url or service is required"})
+		return
+	}
+
+	p, err := provider.Get(caldav.ProviderName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Calendar service not configured"})
+		return
+	}
+	svc := provider.Unwrap(p).(*caldav.CalendarService)
+
+	creds := provider.Credentials{
+		AccessToken: req.Password,
+		ServerURL:   serverURL,
+		Username:    req.Username,
+	}
+
+	principal, err := svc.Discover(c.Request.Context(), creds)
+	if err != nil {
+		logger.Warn.Printf("CalDAV discovery failed for %s: %v", serverURL, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not connect to the CalDAV server - check the URL and app password"})
+		return
+	}
+
+	existing, err := database.GetConnectedAccountByProviderAccountId(user.ID, caldav.ProviderName, principal)
+	if err != nil {
+		logger.Error.Printf("Failed to check connected account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect account"})
+		return
+	}
+
+	if existing != nil {
+		// A new app password for an account that is already connected.
+		err = database.UpdateConnectedAccountTokens(existing.ID, req.Password, "", nil)
+		if err != nil {
+			logger.Error.Printf("Failed to update connected account tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect account"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": existing.ID, "message": "Account updated"})
+		return
+	}
+
+	accountID, err := database.CreateConnectedAccount(database.ConnectedAccount{
+		UserID:            user.ID,
+		Provider:          caldav.ProviderName,
+		ProviderAccountID: principal,
+		Email:             req.Username,
+		AccessToken:       req.Password,
+		ServerURL:         serverURL,
+		Username:          req.Username,
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to create connected account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect account"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": accountID, "message": "Account connected"})
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/database"
+	"calendar-backend/local"
+	"calendar-backend/provider"
+	"calendar-backend/writethrough"
+	"shared/logger"
+)
+
+// EventRequest creates or replaces an event. Start and end are RFC 3339
+// date-times, or plain dates for an all-day event with an exclusive end.
+// TimeZone is the IANA zone recurrences expand in.
+type EventRequest struct {
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description"`
+	Location    string   `json:"location"`
+	Start       string   `json:"start" binding:"required"`
+	End         string   `json:"end" binding:"required"`
+	TimeZone    string   `json:"time_zone"`
+	Recurrence  []string `json:"recurrence"`
+}
+
+type CreateLocalCalendarRequest struct {
+	Name  string  `json:"name" binding:"required"`
+	Color *string `json:"color"`
+}
+
+var recurrencePrefixes = []string{"RRULE:", "RDATE", "EXRULE:", "EXDATE"}
+
+// loadEventTarget loads one of the user's calendars for writing events,
+// writing the error response itself when it returns nil.
+func loadEventTarget(c *gin.Context, user *database.User, calendarID string) *writethrough.Target {
+	calendar, err := database.GetCalendarById(calendarID)
+	if err != nil {
+		logger.Error.Printf("Failed to get calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return nil
+	}
+	if calendar == nil || calendar.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return nil
+	}
+
+	target, err := writethrough.Resolve(calendar)
+	switch {
+	case err == writethrough.ErrReadOnly:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscribed and free/busy calendars are read-only"})
+		return nil
+	case err == writethrough.ErrAccountMissing:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Calendar's account is no longer connected"})
+		return nil
+	case err == writethrough.ErrNeedsReauth:
+		c.JSON(http.StatusConflict, gin.H{"error": "Calendar's account needs to be reconnected"})
+		return nil
+	case err != nil:
+		logger.Error.Printf("Failed to resolve provider for calendar %s: %v", calendar.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Calendar service not configured"})
+		return nil
+	}
+
+	return target
+}
+
+// loadTargetEvent loads an event of the target calendar by its ID, writing
+// the error response itself when it returns nil.
+func loadTargetEvent(c *gin.Context, target *writethrough.Target) *database.Event {
+	event, err := database.GetEventById(c.Param("eventId"))
+	if err != nil {
+		logger.Error.Printf("Failed to get event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event"})
+		return nil
+	}
+	if event == nil || event.CalendarID != target.Calendar.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return nil
+	}
+	return event
+}
+
+// toProviderEvent validates a request and converts it to a provider event.
+// It returns an error message for the client when the request is invalid.
+func (req *EventRequest) toProviderEvent() (provider.CalendarEvent, string) {
+	start, allDay, okStart := parseNotificationTime(req.Start)
+	end, endAllDay, okEnd := parseNotificationTime(req.End)
+	if !okStart || !okEnd {
+		return provider.CalendarEvent{}, "start and end must be RFC 3339 date-times or dates"
+	}
+	if allDay != endAllDay {
+		return provider.CalendarEvent{}, "start and end must both be dates or both be date-times"
+	}
+	if end <= start {
+		return provider.CalendarEvent{}, "end must be after start"
+	}
+
+	if req.TimeZone != "" {
+		if _, err := time.LoadLocation(req.TimeZone); err != nil {
+			return provider.CalendarEvent{}, "unknown time zone " + req.TimeZone
+		}
+	}
+
+	for _, line := range req.Recurrence {
+		valid := false
+		for _, prefix := range recurrencePrefixes {
+			if strings.HasPrefix(strings.ToUpper(line), prefix) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return provider.CalendarEvent{}, "invalid recurrence line " + line
+		}
+	}
+
+	return provider.CalendarEvent{
+		Title:       req.Title,
+		Description: req.Description,
+		Location:    req.Location,
+		StartTime:   start,
+		EndTime:     end,
+		IsAllDay:    allDay,
+		TimeZone:    req.TimeZone,
+		Status:      "confirmed",
+		Recurrence:  strings.Join(req.Recurrence, "\n"),
+	}, ""
+}
+
+func eventJSON(ev database.Event) gin.H {
+	start, end := time.Unix(ev.StartTime, 0).UTC(), time.Unix(ev.EndTime, 0).UTC()
+	layout := time.RFC3339
+	if ev.IsAllDay {
+		layout = "2006-01-02"
+	}
+
+	recurrence := []string{}
+	if ev.Recurrence != "" {
+		recurrence = strings.Split(ev.Recurrence, "\n")
+	}
+
+	attendees := []string{}
+	if ev.Attendees != "" {
+		json.Unmarshal([]byte(ev.Attendees), &attendees)
+	}
+
+	return gin.H{
+		"id":                ev.ID,
+		"calendar_id":       ev.CalendarID,
+		"provider_event_id": ev.ProviderEventID,
+		"title":             ev.Title,
+		"description":       ev.Description,
+		"location":          ev.Location,
+		"start":             start.Format(layout),
+		"end":               end.Format(layout),
+		"all_day":           ev.IsAllDay,
+		"status":            ev.Status,
+		"recurrence":        recurrence,
+		"attendees":         attendees,
+		"updated_at":        ev.UpdatedAt,
+	}
+}
+
+func HandleCreateLocalCalendar(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req CreateLocalCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+
+	calendarID, err := database.CreateCalendar(database.Calendar{
+		UserID:   user.ID,
+		Provider: local.ProviderName,
+		Name:     req.Name,
+		Color:    req.Color,
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to create calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add calendar"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      calendarID,
+		"message": "Calendar added successfully",
+	})
+}
+
+func HandleGetEvents(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	calendar, err := database.GetCalendarById(c.Param("id"))
+	if err != nil {
+		logger.Error.Printf("Failed to get calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return
+	}
+	if calendar == nil || calendar.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	events, err := database.GetEventsByCalendarId(calendar.ID)
+	if err != nil {
+		logger.Error.Printf("Failed to get events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get events"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(events))
+	for _, ev := range events {
+		if ev.Status == "cancelled" {
+			continue
+		}
+		result = append(result, eventJSON(ev))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": result})
+}
+
+func HandleCreateEvent(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	target := loadEventTarget(c, user, c.Param("id"))
+	if target == nil {
+		return
+	}
+
+	var req EventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+	event, problem := req.toProviderEvent()
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+
+	stored, err := target.Create(c.Request.Context(), event)
+	if err != nil {
+		logger.Error.Printf("Failed to create event in calendar %s: %v", target.Calendar.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create event"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, eventJSON(*stored))
+}
+
+func HandleUpdateEvent(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	target := loadEventTarget(c, user, c.Param("id"))
+	if target == nil {
+		return
+	}
+	existing := loadTargetEvent(c, target)
+	if existing == nil {
+		return
+	}
+
+	var req EventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+	event, problem := req.toProviderEvent()
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+
+	stored, err := target.Update(c.Request.Context(), existing, event)
+	if err != nil {
+		logger.Error.Printf("Failed to update event %s: %v", existing.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, eventJSON(*stored))
+}
+
+func HandleDeleteEvent(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	target := loadEventTarget(c, user, c.Param("id"))
+	if target == nil {
+		return
+	}
+	existing := loadTargetEvent(c, target)
+	if existing == nil {
+		return
+	}
+
+	if err := target.Delete(c.Request.Context(), existing); err != nil {
+		logger.Error.Printf("Failed to delete event %s: %v", existing.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to delete event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
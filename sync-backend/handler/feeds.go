@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/config"
+	"calendar-backend/database"
+	"calendar-backend/feed"
+	"calendar-backend/ical"
+	"shared/logger"
+)
+
+// CreateFeedRequest publishes one calendar, or all of the user's calendars
+// when CalendarID is empty.
+type CreateFeedRequest struct {
+	CalendarID string `json:"calendar_id"`
+	BusyOnly   bool   `json:"busy_only"`
+}
+
+func feedURLs(token string) gin.H {
+	url := config.Cfg.PublicURL + "/feeds/" + token + ".ics"
+	webcal := url
+	if i := strings.Index(webcal, "://"); i >= 0 {
+		webcal = "webcal" + webcal[i:]
+	}
+	return gin.H{"url": url, "webcal_url": webcal}
+}
+
+func HandleCreateFeed(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req CreateFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var calendarID *string
+	if req.CalendarID != "" {
+		calendar, err := database.GetCalendarById(req.CalendarID)
+		if err != nil {
+			logger.Error.Printf("Failed to get calendar: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+			return
+		}
+		if calendar == nil || calendar.UserID != user.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+			return
+		}
+		calendarID = &calendar.ID
+	}
+
+	id, token, err := database.CreateFeedToken(user.ID, calendarID, req.BusyOnly)
+	if err != nil {
+		logger.Error.Printf("Failed to create feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create feed"})
+		return
+	}
+
+	// The token is only ever shown here; the database keeps a hash.
+	response := feedURLs(token)
+	response["id"] = id
+	response["calendar_id"] = calendarID
+	response["busy_only"] = req.BusyOnly
+	c.JSON(http.StatusCreated, response)
+}
+
+func HandleGetFeeds(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	tokens, err := database.GetFeedTokensByUserId(user.ID)
+	if err != nil {
+		logger.Error.Printf("Failed to get feeds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get feeds"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(tokens))
+	for _, ft := range tokens {
+		result = append(result, gin.H{
+			"id":           ft.ID,
+			"calendar_id":  ft.CalendarID,
+			"busy_only":    ft.BusyOnly,
+			"last_used_at": ft.LastUsedAt,
+			"created_at":   ft.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feeds": result})
+}
+
+func HandleDeleteFeed(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	ft, err := database.GetFeedTokenById(c.Param("id"))
+	if err != nil {
+		logger.Error.Printf("Failed to get feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get feed"})
+		return
+	}
+	if ft == nil || ft.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+
+	if err := database.DeleteFeedToken(ft.ID); err != nil {
+		logger.Error.Printf("Failed to delete feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// HandleServeFeed serves a published feed. The token in the URL is the only
+// credential, since calendar apps can't send cookies or headers.
+func HandleServeFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	ft, err := database.GetFeedTokenByToken(token)
+	if err != nil {
+		logger.Error.Printf("Failed to look up feed token: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to load feed")
+		return
+	}
+	if ft == nil {
+		c.String(http.StatusNotFound, "Feed not found")
+		return
+	}
+
+	var calendars []database.Calendar
+	if ft.CalendarID != nil {
+		calendar, err := database.GetCalendarById(*ft.CalendarID)
+		if err != nil {
+			logger.Error.Printf("Failed to get calendar: %v", err)
+			c.String(http.StatusInternalServerError, "Failed to load feed")
+			return
+		}
+		if calendar == nil || calendar.UserID != ft.UserID {
+			c.String(http.StatusNotFound, "Feed not found")
+			return
+		}
+		calendars = append(calendars, *calendar)
+	} else {
+		calendars, err = database.GetCalendarsByUserId(ft.UserID)
+		if err != nil {
+			logger.Error.Printf("Failed to get calendars: %v", err)
+			c.String(http.StatusInternalServerError, "Failed to load feed")
+			return
+		}
+	}
+
+	sources := make([]feed.Source, 0, len(calendars))
+	for _, calendar := range calendars {
+		events, err := database.GetEventsByCalendarId(calendar.ID)
+		if err != nil {
+			logger.Error.Printf("Failed to get events for calendar %s: %v", calendar.ID, err)
+			c.String(http.StatusInternalServerError, "Failed to load feed")
+			return
+		}
+		sources = append(sources, feed.Source{Calendar: calendar, Events: events})
+	}
+
+	name := "All calendars"
+	if ft.CalendarID != nil {
+		name = calendars[0].Name
+	}
+
+	var body bytes.Buffer
+	if err := ical.Encode(&body, feed.Build(sources, feed.Options{Name: name, BusyOnly: ft.BusyOnly})); err != nil {
+		logger.Error.Printf("Failed to render feed %s: %v", ft.ID, err)
+		c.String(http.StatusInternalServerError, "Failed to render feed")
+		return
+	}
+
+	if err := database.TouchFeedToken(ft.ID); err != nil {
+		logger.Warn.Printf("Failed to record feed access: %v", err)
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", body.Bytes())
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/database"
+	"calendar-backend/feed"
+	"calendar-backend/freebusy"
+	"calendar-backend/google"
+	"shared/logger"
+)
+
+// maxAvailabilityRange limits how far one availability query reaches.
+const maxAvailabilityRange = 92 * 24 * time.Hour
+
+// AddFreeBusyCalendarRequest adds a calendar shared free/busy only, such as
+// a colleague's, through one of the user's Google accounts. CalendarID is
+// usually the colleague's email address.
+type AddFreeBusyCalendarRequest struct {
+	ConnectedAccountID string  `json:"connected_account_id" binding:"required"`
+	CalendarID         string  `json:"calendar_id" binding:"required"`
+	Name               string  `json:"name"`
+	Color              *string `json:"color"`
+}
+
+func HandleAddFreeBusyCalendar(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req AddFreeBusyCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+	calendarID := strings.TrimSpace(req.CalendarID)
+
+	account, err := database.GetConnectedAccountById(req.ConnectedAccountID)
+	if err != nil {
+		logger.Error.Printf("Failed to get connected account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify account"})
+		return
+	}
+	if account == nil || account.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connected account not found"})
+		return
+	}
+	if account.Provider != google.ProviderName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Free/busy calendars need a Google account"})
+		return
+	}
+
+	existing, err := database.GetUserCalendarByProviderCalendarId(user.ID, google.FreeBusyProviderName, calendarID)
+	if err != nil {
+		logger.Error.Printf("Failed to check existing calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check calendar"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Calendar already added"})
+		return
+	}
+
+	// Query once up front so a calendar that isn't shared with the account
+	// is reported now instead of as a polling error.
+	blocks, err := freebusy.Query(c.Request.Context(), account, calendarID)
+	if err != nil {
+		logger.Warn.Printf("Free/busy query for %s failed: %v", calendarID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not load free/busy information: " + err.Error()})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = calendarID
+	}
+
+	id, err := database.CreateCalendar(database.Calendar{
+		UserID:             user.ID,
+		ConnectedAccountID: &account.ID,
+		Provider:           google.FreeBusyProviderName,
+		ProviderCalendarID: calendarID,
+		Name:               name,
+		Color:              req.Color,
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to create calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add calendar"})
+		return
+	}
+
+	diff, err := database.ReplaceCalendarEvents(id, freebusy.ToEvents(blocks))
+	if err != nil {
+		// The poller fills the calendar on its next run.
+		logger.Error.Printf("Failed to store busy blocks of calendar %s: %v", id, err)
+		c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Calendar added, but storing busy times failed"})
+		return
+	}
+	if err := database.UpdateCalendarFeedState(id, nil, nil, time.Now().Unix(), nil); err != nil {
+		logger.Warn.Printf("Failed to record free/busy check of calendar %s: %v", id, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      id,
+		"message": "Calendar added successfully",
+		"busy":    diff.Added,
+	})
+}
+
+// HandleGetAvailability returns when the user is busy and free between
+// start and end (RFC 3339), across all calendars or the comma-separated
+// calendar_ids. Free/busy calendars count like any other.
+func HandleGetAvailability(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, c.Query("start"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be an RFC 3339 date-time"})
+		return
+	}
+	to, err := time.Parse(time.RFC3339, c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be an RFC 3339 date-time"})
+		return
+	}
+	if !to.After(from) || to.Sub(from) > maxAvailabilityRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be after start and at most 92 days later"})
+		return
+	}
+
+	calendars, err := database.GetCalendarsByUserId(user.ID)
+	if err != nil {
+		logger.Error.Printf("Failed to get calendars: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendars"})
+		return
+	}
+
+	var wanted map[string]bool
+	if ids := c.Query("calendar_ids"); ids != "" {
+		wanted = make(map[string]bool)
+		for _, id := range strings.Split(ids, ",") {
+			wanted[strings.TrimSpace(id)] = true
+		}
+	}
+
+	var busy []feed.Interval
+	used := make([]string, 0, len(calendars))
+	for _, cal := range calendars {
+		if wanted != nil && !wanted[cal.ID] {
+			continue
+		}
+		events, err := database.GetEventsByCalendarId(cal.ID)
+		if err != nil {
+			logger.Error.Printf("Failed to get events of calendar %s: %v", cal.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get events"})
+			return
+		}
+		busy = append(busy, feed.Busy(cal, events, from, to)...)
+		used = append(used, cal.ID)
+	}
+
+	merged := feed.Merge(busy)
+	c.JSON(http.StatusOK, gin.H{
+		"start":     from.Unix(),
+		"end":       to.Unix(),
+		"calendars": used,
+		"busy":      nonNil(merged),
+		"free":      nonNil(feed.Free(merged, from, to)),
+	})
+}
+
+func nonNil(intervals []feed.Interval) []feed.Interval {
+	if intervals == nil {
+		return []feed.Interval{}
+	}
+	return intervals
+}
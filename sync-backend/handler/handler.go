@@ -1,32 +1,64 @@
 package handler
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"calendar-backend/caldav"
 	"calendar-backend/config"
 	"calendar-backend/database"
+	"calendar-backend/eventsync"
 	"calendar-backend/google"
+	"calendar-backend/local"
+	"calendar-backend/outlook"
+	"calendar-backend/provider"
+	"calendar-backend/ratelimit"
+	"calendar-backend/writethrough"
 	"shared/jwt"
 	"shared/logger"
 )
 
-var calendarService *google.CalendarService
-
-func init() {
-}
-
-func InitCalendarService() {
+// InitProviders registers the configured providers. Remote ones are paced
+// per connected account, local calendars don't need it.
+func InitProviders() {
 	cfg := config.Cfg
+	limits := ratelimit.Config{
+		Rate:       cfg.ProviderRateLimit,
+		Burst:      cfg.ProviderRateBurst,
+		MaxRetries: 3,
+		MaxWait:    cfg.ProviderMaxRetryWait,
+	}
+
 	if cfg.GoogleClientID != "" && cfg.GoogleClientSecret != "" {
-		calendarService = google.NewCalendarService(cfg.GoogleClientID, cfg.GoogleClientSecret)
-		logger.Info.Printf("Google Calendar service initialized")
+		provider.Register(google.ProviderName, ratelimit.Wrap(google.ProviderName,
+			google.NewCalendarService(cfg.GoogleClientID, cfg.GoogleClientSecret), limits))
+		logger.Info.Printf("Google Calendar provider initialized")
+	} else {
+		logger.Warn.Printf("Google Calendar provider NOT initialized - missing GOOGLE_CLIENT_ID or GOOGLE_CLIENT_SECRET")
+	}
+
+	if cfg.OutlookClientID != "" && cfg.OutlookClientSecret != "" {
+		provider.Register(outlook.ProviderName, ratelimit.Wrap(outlook.ProviderName, outlook.NewCalendarService(outlook.Config{
+			ClientID:     cfg.OutlookClientID,
+			ClientSecret: cfg.OutlookClientSecret,
+			RedirectURL:  cfg.OutlookRedirectURL,
+			AuthURL:      cfg.OutlookAuthURL,
+			TokenURL:     cfg.OutlookTokenURL,
+			GraphBaseURL: cfg.GraphBaseURL,
+		}), limits))
+		logger.Info.Printf("Outlook Calendar provider initialized")
 	} else {
-		logger.Warn.Printf("Google Calendar service NOT initialized - missing GOOGLE_CLIENT_ID or GOOGLE_CLIENT_SECRET")
+		logger.Warn.Printf("Outlook Calendar provider NOT initialized - missing OUTLOOK_CLIENT_ID or OUTLOOK_CLIENT_SECRET")
 	}
+
+	provider.Register(caldav.ProviderName, ratelimit.Wrap(caldav.ProviderName, caldav.NewCalendarService(), limits))
+	provider.Register(local.ProviderName, local.NewCalendarService())
 }
 
 func getAuthenticatedUser(c *gin.Context) *database.User {
@@ -51,9 +83,70 @@ func getAuthenticatedUser(c *gin.Context) *database.User {
 	return user
 }
 
+type EventNotification struct {
+	ID                 string          `json:"id"`
+	Provider           string          `json:"provider"`
+	CalendarID         string          `json:"calendar_id"`
+	ProviderCalendarID string          `json:"provider_calendar_id"`
+	Status             string          `json:"status"`
+	Summary            string          `json:"summary"`
+	Description        string          `json:"description"`
+	Location           string          `json:"location"`
+	Attendees          []string        `json:"attendees"`
+	Start              string          `json:"start"`
+	End                string          `json:"end"`
+	Updated            string          `json:"updated"`
+	Etag               string          `json:"etag"`
+	RawData            json.RawMessage `json:"raw_data"`
+}
+
+// CalendarListing is every event of a calendar, sent by the watcher when it
+// had to list the calendar in full rather than read changes since a token.
+type CalendarListing struct {
+	ProviderCalendarID string              `json:"provider_calendar_id"`
+	Events             []EventNotification `json:"events"`
+}
+
+// RequireWatcherSecret admits requests that send WATCHER_BACKEND_SECRET as
+// a bearer token, so only the watcher can forward changes.
+func RequireWatcherSecret() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := config.Cfg.WatcherSecret
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HandleEvent stores an event change the watcher read from Google.
 func HandleEvent(c *gin.Context) {
 	eventId := c.Param("eventId")
-	logger.Info.Printf("Received event: %s", eventId)
+
+	var notification EventNotification
+	if err := c.ShouldBindJSON(&notification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event payload"})
+		return
+	}
+
+	logger.Info.Printf("Received event: %s (calendar %s, %s)", eventId, notification.CalendarID, notification.Status)
+
+	calendar := notifiedCalendar(c, notification.CalendarID, notification.ProviderCalendarID, isGoogle)
+	if calendar == nil {
+		return
+	}
+
+	// The watcher delivers changes at least once, so a change is applied by
+	// provider event ID and skipped when the stored etag matches.
+	if event, ok := notificationEvent(eventId, &notification); ok {
+		if _, err := database.ApplyEventChanges(calendar.ID, []database.Event{event}); err != nil {
+			logger.Error.Printf("Failed to store event %s: %v", eventId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store event"})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Event received",
@@ -61,113 +154,152 @@ func HandleEvent(c *gin.Context) {
 	})
 }
 
-func HandleTokens(c *gin.Context) {
-	user := getAuthenticatedUser(c)
-	if user == nil {
+// HandleCalendarSync passes on an Outlook change notification. These carry
+// no event details, so the calendar is synced through its provider instead.
+func HandleCalendarSync(c *gin.Context) {
+	var notification EventNotification
+	if err := c.ShouldBindJSON(&notification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event payload"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"access_token":  user.Token,
-		"refresh_token": user.RefreshToken,
-	})
+	calendar := notifiedCalendar(c, c.Param("calendarId"), notification.ProviderCalendarID, eventsync.Polled)
+	if calendar == nil {
+		return
+	}
+
+	eventsync.Trigger(calendar.ID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Sync triggered"})
 }
 
-func HandleAuthCallback(c *gin.Context) {
-	newToken := c.Query("token")
-	if newToken == "" {
-		logger.Warn.Printf("Auth callback missing token")
-		c.String(http.StatusBadRequest, "Missing token")
+// HandleCalendarEvents replaces a Google calendar's events with a full
+// listing from the watcher. Events missing from the listing were deleted
+// while there was no sync token to report them, so they are removed too.
+func HandleCalendarEvents(c *gin.Context) {
+	var listing CalendarListing
+	if err := c.ShouldBindJSON(&listing); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event payload"})
+		return
+	}
+
+	calendar := notifiedCalendar(c, c.Param("calendarId"), listing.ProviderCalendarID, isGoogle)
+	if calendar == nil {
 		return
 	}
 
-	newEmail, err := jwt.ParseJWT(newToken)
+	rows := make([]database.Event, 0, len(listing.Events))
+	for i := range listing.Events {
+		n := &listing.Events[i]
+		if n.Status == "cancelled" {
+			continue
+		}
+		if event, ok := notificationEvent(n.ID, n); ok {
+			rows = append(rows, event)
+		}
+	}
+
+	diff, err := database.ReplaceCalendarEvents(calendar.ID, rows)
 	if err != nil {
-		logger.Warn.Printf("Invalid token in callback: %v", err)
-		c.String(http.StatusBadRequest, "Invalid token")
+		logger.Error.Printf("Failed to replace events of calendar %s: %v", calendar.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store events"})
 		return
 	}
 
-	cfg := config.Cfg
+	logger.Info.Printf("Replaced events of calendar %s: %d added, %d updated, %d removed",
+		calendar.ID, diff.Added, diff.Updated, diff.Removed)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Events replaced",
+		"added":   diff.Added,
+		"updated": diff.Updated,
+		"removed": diff.Removed,
+	})
+}
 
-	existingJWT, _ := c.Cookie("JWT")
-	var existingUser *database.User
+// notifiedCalendar looks up the calendar a watcher notification is for and
+// checks that it belongs to an accepted provider and still points at the
+// same provider calendar. It answers the request itself when not.
+func notifiedCalendar(c *gin.Context, calendarId, providerCalendarId string, accept func(provider string) bool) *database.Calendar {
+	if calendarId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing calendar_id"})
+		return nil
+	}
 
-	if existingJWT != "" {
-		existingEmail, err := jwt.ParseJWT(existingJWT)
-		if err == nil {
-			existingUser, _ = database.GetUser(existingEmail)
-		}
+	calendar, err := database.GetCalendarById(calendarId)
+	if err != nil {
+		logger.Error.Printf("Failed to get calendar %s: %v", calendarId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return nil
+	}
+	if calendar == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return nil
 	}
 
-	if existingUser != nil && existingUser.Email != newEmail {
-		// CONNECT FLOW: User is adding another Google account
-		newUser, err := database.GetUser(newEmail)
-		if err != nil || newUser == nil {
-			logger.Error.Printf("New user not found: %s", newEmail)
-			c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?error=account_not_found")
-			return
-		}
+	if !accept(calendar.Provider) || calendar.ProviderCalendarID != providerCalendarId {
+		logger.Warn.Printf("Rejected notification for %s calendar %s (provider calendar %q)",
+			calendar.Provider, calendar.ID, providerCalendarId)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Calendar does not match the notification"})
+		return nil
+	}
 
-		// Check if this account is already connected
-		existing, err := database.GetConnectedAccountByProviderAccountId(
-			existingUser.ID, "google", newUser.ID,
-		)
+	return calendar
+}
 
-		if err != nil {
-			logger.Error.Printf("Failed to check connected account: %v", err)
-			c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?error="+url.QueryEscape("Failed to connect account"))
-			return
-		}
+func isGoogle(provider string) bool {
+	return provider == google.ProviderName
+}
 
-		if existing != nil {
-			// Update existing connected account tokens
-			err = database.UpdateConnectedAccountTokens(
-				existing.ID,
-				newUser.Token,
-				newUser.RefreshToken,
-				nil,
-			)
-			if err != nil {
-				logger.Error.Printf("Failed to update connected account tokens: %v", err)
-			}
-		} else {
-			// Create new connected account
-			_, err = database.CreateConnectedAccount(database.ConnectedAccount{
-				UserID:            existingUser.ID,
-				Provider:          "google",
-				ProviderAccountID: newUser.ID,
-				Email:             newEmail,
-				AccessToken:       newUser.Token,
-				RefreshToken:      newUser.RefreshToken,
-			})
-			if err != nil {
-				logger.Error.Printf("Failed to create connected account: %v", err)
-				c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?error="+url.QueryEscape("Failed to connect account"))
-				return
-			}
+// notificationEvent turns a forwarded change into an events row. It reports
+// false for an event whose times can't be read.
+func notificationEvent(eventId string, n *EventNotification) (database.Event, bool) {
+	event := database.Event{
+		ProviderEventID: eventId,
+		Title:           n.Summary,
+		Description:     n.Description,
+		Location:        n.Location,
+		Status:          n.Status,
+		Etag:            n.Etag,
+		RawData:         string(n.RawData),
+	}
+	if len(n.Attendees) > 0 {
+		if data, err := json.Marshal(n.Attendees); err == nil {
+			event.Attendees = string(data)
+		}
+	}
+	if n.Status != "cancelled" {
+		start, allDay, okStart := parseNotificationTime(n.Start)
+		end, _, okEnd := parseNotificationTime(n.End)
+		if !okStart || !okEnd {
+			return event, false
 		}
+		event.StartTime, event.EndTime, event.IsAllDay = start, end, allDay
+	}
+	return event, true
+}
 
-		// Keep existing JWT, redirect with success
-		c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home?connected="+url.QueryEscape(newEmail))
-		return
+// parseNotificationTime reads an RFC 3339 date-time or a plain date.
+func parseNotificationTime(value string) (int64, bool, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), false, true
 	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.Unix(), true, true
+	}
+	return 0, false, false
+}
 
-	// LOGIN FLOW: Set new JWT cookie
-	c.SetCookie(
-		"JWT",
-		newToken,
-		int(24*time.Hour.Seconds()),
-		"/",
-		"",
-		false,
-		true,
-	)
+func HandleTokens(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
 
-	c.Redirect(http.StatusSeeOther, cfg.FrontendURL+"/home")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  user.Token,
+		"refresh_token": user.RefreshToken,
+	})
 }
 
-
 func HandleGetConnectedAccounts(c *gin.Context) {
 	user := getAuthenticatedUser(c)
 	if user == nil {
@@ -183,17 +315,38 @@ func HandleGetConnectedAccounts(c *gin.Context) {
 
 	result := make([]gin.H, 0, len(accounts))
 	for _, acc := range accounts {
-		result = append(result, gin.H{
-			"id":         acc.ID,
-			"provider":   acc.Provider,
-			"email":      acc.Email,
-			"created_at": acc.CreatedAt,
-		})
+		account := gin.H{
+			"id":           acc.ID,
+			"provider":     acc.Provider,
+			"email":        acc.Email,
+			"needs_reauth": acc.NeedsReauth,
+			"created_at":   acc.CreatedAt,
+		}
+		if acc.NeedsReauth {
+			account["reauth_reason"] = acc.ReauthReason
+			account["reauth_url"] = reauthURL(&acc)
+		}
+		result = append(result, account)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"accounts": result})
 }
 
+// reauthURL is where the user consents again to an account whose grant was
+// revoked. Both flows update the existing account, so its calendars are
+// kept.
+func reauthURL(acc *database.ConnectedAccount) string {
+	cfg := config.Cfg
+	hint := "?login_hint=" + url.QueryEscape(acc.Email)
+	switch acc.Provider {
+	case google.ProviderName:
+		return cfg.AuthServerURL + "/connect/google" + hint
+	case outlook.ProviderName:
+		return cfg.PublicURL + "/api/connect/outlook" + hint
+	}
+	return ""
+}
+
 func HandleGetAvailableCalendars(c *gin.Context) {
 	user := getAuthenticatedUser(c)
 	if user == nil {
@@ -214,15 +367,16 @@ func HandleGetAvailableCalendars(c *gin.Context) {
 		return
 	}
 
-	if calendarService == nil {
+	p, err := provider.Get(account.Provider)
+	if err != nil {
+		logger.Error.Printf("No provider for account %s: %v", account.ID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Calendar service not configured"})
 		return
 	}
 
-	// Fetch calendars from Google
-	googleCalendars, err := calendarService.ListCalendars(account.AccessToken, account.RefreshToken)
+	providerCalendars, err := p.ListCalendars(c.Request.Context(), writethrough.Credentials(account))
 	if err != nil {
-		logger.Error.Printf("Failed to fetch calendars from Google: %v", err)
+		logger.Error.Printf("Failed to fetch calendars from %s: %v", account.Provider, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calendars"})
 		return
 	}
@@ -241,8 +395,8 @@ func HandleGetAvailableCalendars(c *gin.Context) {
 		}
 	}
 
-	result := make([]gin.H, 0, len(googleCalendars))
-	for _, cal := range googleCalendars {
+	result := make([]gin.H, 0, len(providerCalendars))
+	for _, cal := range providerCalendars {
 		localID, added := addedProviderIds[cal.ProviderCalendarID]
 		item := gin.H{
 			"provider_calendar_id": cal.ProviderCalendarID,
@@ -316,17 +470,33 @@ func HandleGetCalendars(c *gin.Context) {
 			"color":                cal.Color,
 			"is_primary":           cal.IsPrimary,
 			"webhook_active":       webhookActive,
+			"sync_mode":            cal.SyncMode,
+			"provider":             cal.Provider,
+			"feed_checked_at":      cal.FeedCheckedAt,
+			"feed_error":           cal.FeedError,
 		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"calendars": result})
 }
 
+var validSyncModes = map[string]bool{
+	"auto": true,
+	"push": true,
+	"poll": true,
+}
+
 type AddCalendarRequest struct {
 	ConnectedAccountID string `json:"connected_account_id" binding:"required"`
 	ProviderCalendarID string `json:"provider_calendar_id" binding:"required"`
 }
 
+// UpdateCalendarRequest changes per-calendar settings. A null sync_mode
+// reverts the calendar to the watcher's global WATCHER_SYNC_MODE.
+type UpdateCalendarRequest struct {
+	SyncMode *string `json:"sync_mode"`
+}
+
 func HandleAddCalendar(c *gin.Context) {
 	user := getAuthenticatedUser(c)
 	if user == nil {
@@ -363,20 +533,22 @@ func HandleAddCalendar(c *gin.Context) {
 		return
 	}
 
-	if calendarService == nil {
+	p, err := provider.Get(account.Provider)
+	if err != nil {
+		logger.Error.Printf("No provider for account %s: %v", account.ID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Calendar service not configured"})
 		return
 	}
 
-	googleCalendars, err := calendarService.ListCalendars(account.AccessToken, account.RefreshToken)
+	providerCalendars, err := p.ListCalendars(c.Request.Context(), writethrough.Credentials(account))
 	if err != nil {
-		logger.Error.Printf("Failed to fetch calendars from Google: %v", err)
+		logger.Error.Printf("Failed to fetch calendars from %s: %v", account.Provider, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch calendar details"})
 		return
 	}
 
-	var calendarToAdd *google.AvailableCalendar
-	for _, cal := range googleCalendars {
+	var calendarToAdd *provider.AvailableCalendar
+	for _, cal := range providerCalendars {
 		if cal.ProviderCalendarID == req.ProviderCalendarID {
 			calendarToAdd = &cal
 			break
@@ -384,14 +556,14 @@ func HandleAddCalendar(c *gin.Context) {
 	}
 
 	if calendarToAdd == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found in Google"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found in " + account.Provider})
 		return
 	}
 
 	calendarID, err := database.CreateCalendar(database.Calendar{
 		UserID:             user.ID,
 		ConnectedAccountID: &req.ConnectedAccountID,
-		Provider:           "google",
+		Provider:           account.Provider,
 		ProviderCalendarID: req.ProviderCalendarID,
 		Name:               calendarToAdd.Name,
 		Color:              calendarToAdd.Color,
@@ -410,6 +582,47 @@ func HandleAddCalendar(c *gin.Context) {
 	})
 }
 
+func HandleUpdateCalendar(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req UpdateCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.SyncMode != nil && !validSyncModes[*req.SyncMode] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sync_mode must be one of auto, push or poll"})
+		return
+	}
+
+	calendarID := c.Param("id")
+
+	calendar, err := database.GetCalendarById(calendarID)
+	if err != nil {
+		logger.Error.Printf("Failed to get calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get calendar"})
+		return
+	}
+
+	if calendar == nil || calendar.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return
+	}
+
+	err = database.UpdateCalendarSyncMode(calendarID, req.SyncMode)
+	if err != nil {
+		logger.Error.Printf("Failed to update calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update calendar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
 func HandleDeleteCalendar(c *gin.Context) {
 	user := getAuthenticatedUser(c)
 	if user == nil {
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/config"
+	"calendar-backend/database"
+	shareddb "shared/database"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handler")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	os.Setenv("JWT_SECRET", "test-secret-key-for-testing-purposes")
+	gin.SetMode(gin.TestMode)
+	config.Cfg = &config.Config{WatcherSecret: "watcher-secret"}
+
+	code := m.Run()
+	shareddb.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// createCalendar stores a calendar of the given provider for a test user.
+func createCalendar(t *testing.T, provider, providerCalendarID string) string {
+	t.Helper()
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO users (id, email) VALUES ('user', 'user@example.com')`)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	id, err := database.CreateCalendar(database.Calendar{
+		UserID:             "user",
+		Provider:           provider,
+		ProviderCalendarID: providerCalendarID,
+		Name:               "Calendar",
+	})
+	if err != nil {
+		t.Fatalf("CreateCalendar failed: %v", err)
+	}
+	return id
+}
+
+func watcherRouter() *gin.Engine {
+	r := gin.New()
+	watcher := r.Group("/", RequireWatcherSecret())
+	watcher.POST("/event/:eventId", HandleEvent)
+	watcher.POST("/calendar/:calendarId/events", HandleCalendarEvents)
+	return r
+}
+
+func postEvent(r *gin.Engine, token, eventId, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/event/"+eventId, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleEvent(t *testing.T) {
+	google := createCalendar(t, "google", "primary@example.com")
+	local := createCalendar(t, "local", "")
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		stored bool
+	}{
+		{
+			name:   "stored",
+			token:  "watcher-secret",
+			body:   `{"calendar_id":"` + google + `","provider_calendar_id":"primary@example.com","status":"confirmed","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z"}`,
+			status: http.StatusOK,
+			stored: true,
+		},
+		{
+			name:   "missing secret",
+			body:   `{"calendar_id":"` + google + `","provider_calendar_id":"primary@example.com","status":"confirmed","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong secret",
+			token:  "guess",
+			body:   `{"calendar_id":"` + google + `","provider_calendar_id":"primary@example.com","status":"confirmed","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "other provider calendar",
+			token:  "watcher-secret",
+			body:   `{"calendar_id":"` + google + `","provider_calendar_id":"other@example.com","status":"confirmed","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "local calendar",
+			token:  "watcher-secret",
+			body:   `{"calendar_id":"` + local + `","provider_calendar_id":"` + local + `","status":"confirmed","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown calendar",
+			token:  "watcher-secret",
+			body:   `{"calendar_id":"missing","provider_calendar_id":"primary@example.com","status":"confirmed"}`,
+			status: http.StatusNotFound,
+		},
+	}
+
+	r := watcherRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventId := strings.ReplaceAll(tt.name, " ", "-")
+			w := postEvent(r, tt.token, eventId, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			for _, calendarId := range []string{google, local} {
+				ev, err := database.GetEventByProviderEventId(calendarId, eventId)
+				if err != nil {
+					t.Fatalf("GetEventByProviderEventId failed: %v", err)
+				}
+				want := tt.stored && calendarId == google
+				if stored := ev != nil; stored != want {
+					t.Errorf("Expected stored %v in calendar %s, got %v", want, calendarId, stored)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEventCancelled(t *testing.T) {
+	calendarId := createCalendar(t, "google", "cancel@example.com")
+	r := watcherRouter()
+
+	confirmed := `{"calendar_id":"` + calendarId + `","provider_calendar_id":"cancel@example.com","status":"confirmed",` +
+		`"summary":"Lunch","start":"2026-03-01","end":"2026-03-02","etag":"\"1\""}`
+	if w := postEvent(r, "watcher-secret", "lunch", confirmed); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	ev, err := database.GetEventByProviderEventId(calendarId, "lunch")
+	if err != nil || ev == nil {
+		t.Fatalf("Expected the event to be stored, got %v", err)
+	}
+	if ev.Title != "Lunch" || !ev.IsAllDay {
+		t.Errorf("Expected an all day event titled Lunch, got %+v", ev)
+	}
+
+	cancelled := `{"calendar_id":"` + calendarId + `","provider_calendar_id":"cancel@example.com","status":"cancelled"}`
+	for i := 0; i < 2; i++ {
+		if w := postEvent(r, "watcher-secret", "lunch", cancelled); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+	}
+	ev, err = database.GetEventByProviderEventId(calendarId, "lunch")
+	if err != nil {
+		t.Fatalf("GetEventByProviderEventId failed: %v", err)
+	}
+	if ev != nil {
+		t.Errorf("Expected the cancelled event to be deleted, got %+v", ev)
+	}
+}
+
+func TestHandleEventKeepsDetails(t *testing.T) {
+	calendarId := createCalendar(t, "google", "details@example.com")
+	r := watcherRouter()
+
+	body := `{"calendar_id":"` + calendarId + `","provider_calendar_id":"details@example.com","status":"confirmed",` +
+		`"summary":"Review","description":"Agenda","location":"Room 4","attendees":["jane@example.com"],` +
+		`"start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z","etag":"\"1\"","raw_data":{"id":"review"}}`
+	if w := postEvent(r, "watcher-secret", "review", body); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	ev, err := database.GetEventByProviderEventId(calendarId, "review")
+	if err != nil || ev == nil {
+		t.Fatalf("Expected the event to be stored, got %v", err)
+	}
+	if ev.Description != "Agenda" || ev.Location != "Room 4" {
+		t.Errorf("Expected description and location to be stored, got %q and %q", ev.Description, ev.Location)
+	}
+	if ev.Attendees != `["jane@example.com"]` {
+		t.Errorf("Expected the attendees to be stored, got %q", ev.Attendees)
+	}
+	if ev.RawData != `{"id":"review"}` {
+		t.Errorf("Expected the raw data to be stored, got %q", ev.RawData)
+	}
+}
+
+func TestHandleCalendarEvents(t *testing.T) {
+	calendarId := createCalendar(t, "google", "listing@example.com")
+	r := watcherRouter()
+
+	for _, id := range []string{"kept", "deleted", "cancelled"} {
+		body := `{"calendar_id":"` + calendarId + `","provider_calendar_id":"listing@example.com","status":"confirmed",` +
+			`"summary":"` + id + `","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z","etag":"\"1\""}`
+		if w := postEvent(r, "watcher-secret", id, body); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+	}
+
+	listing := `{"provider_calendar_id":"listing@example.com","events":[` +
+		`{"id":"kept","status":"confirmed","summary":"kept","start":"2026-03-01T09:00:00Z","end":"2026-03-01T10:00:00Z","etag":"\"2\""},` +
+		`{"id":"cancelled","status":"cancelled"},` +
+		`{"id":"added","status":"confirmed","summary":"added","start":"2026-03-02","end":"2026-03-03"}]}`
+	post := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(listing))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/calendar/"+calendarId+"/events", "guess"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong secret, got %d", w.Code)
+	}
+	other := createCalendar(t, "google", "other-listing@example.com")
+	if w := post("/calendar/"+other+"/events", "watcher-secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another provider calendar, got %d", w.Code)
+	}
+
+	if w := post("/calendar/"+calendarId+"/events", "watcher-secret"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	events, err := database.GetEventsByCalendarId(calendarId)
+	if err != nil {
+		t.Fatalf("GetEventsByCalendarId failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, ev := range events {
+		got[ev.ProviderEventID] = ev.Etag
+	}
+	want := map[string]string{"kept": `"2"`, "added": ""}
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	for id, etag := range want {
+		if current, ok := got[id]; !ok || current != etag {
+			t.Errorf("Expected %s with etag %q, got %q (present %v)", id, etag, current, ok)
+		}
+	}
+}
+
+func TestRequireWatcherSecretUnset(t *testing.T) {
+	config.Cfg.WatcherSecret = ""
+	defer func() { config.Cfg.WatcherSecret = "watcher-secret" }()
+
+	if w := postEvent(watcherRouter(), "", "unset", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a configured secret, got %d", w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/database"
+	"calendar-backend/ics"
+	"calendar-backend/local"
+	"shared/logger"
+)
+
+// ImportResult reports what happened to one event of an import.
+type ImportResult struct {
+	Index           int    `json:"index"`
+	UID             string `json:"uid,omitempty"`
+	Title           string `json:"title,omitempty"`
+	Status          string `json:"status"`
+	ProviderEventID string `json:"provider_event_id,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// readImportFile parses the "file" field of a multipart upload, writing the
+// error response itself when it returns nil.
+func readImportFile(c *gin.Context) *ics.Import {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, ics.MaxImportSize+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing .ics file"})
+		return nil
+	}
+	if header.Size > ics.MaxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large"})
+		return nil
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		logger.Error.Printf("Failed to open uploaded file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return nil
+	}
+	defer file.Close()
+
+	imp, err := ics.ReadImport(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a valid iCalendar file: " + err.Error()})
+		return nil
+	}
+	return imp
+}
+
+// HandleImportPreview parses an uploaded .ics file and returns what importing
+// it would write, without writing anything.
+func HandleImportPreview(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	imp := readImportFile(c)
+	if imp == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, imp.Summary())
+}
+
+// HandleImportCalendar writes the events of an uploaded .ics file to one of
+// the user's calendars. Local calendars store the events as they are in the
+// file; the others get them through their provider. Each event succeeds or
+// fails on its own.
+func HandleImportCalendar(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	target := loadEventTarget(c, user, c.Param("id"))
+	if target == nil {
+		return
+	}
+	calendar := target.Calendar
+
+	imp := readImportFile(c)
+	if imp == nil {
+		return
+	}
+
+	results := make([]ImportResult, 0, len(imp.Items))
+	imported := 0
+
+	for _, item := range imp.Items {
+		result := ImportResult{Index: item.Index, UID: item.UID, Title: item.Title}
+
+		var err error
+		if calendar.Provider == local.ProviderName {
+			var row database.Event
+			row, err = item.Row()
+			if err == nil {
+				result.ProviderEventID = row.ProviderEventID
+				err = database.UpsertEvent(calendar.ID, row)
+			}
+		} else {
+			var created *database.Event
+			created, err = target.Create(c.Request.Context(), item.ProviderEvent())
+			if err == nil {
+				result.ProviderEventID = created.ProviderEventID
+			}
+		}
+
+		if err != nil {
+			logger.Warn.Printf("Failed to import event %q into calendar %s: %v", item.UID, calendar.ID, err)
+			result.Status = "failed"
+			result.Error = err.Error()
+		} else {
+			result.Status = "imported"
+			imported++
+		}
+		results = append(results, result)
+	}
+
+	logger.Info.Printf("Imported %d of %d event(s) into calendar %s", imported, len(imp.Items), calendar.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported,
+		"failed":   len(imp.Items) - imported,
+		"results":  results,
+		"skipped":  imp.Skipped,
+	})
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"calendar-backend/database"
+	"calendar-backend/ics"
+	"shared/logger"
+)
+
+// SubscribeCalendarRequest adds a read-only calendar from an ICS URL. Name
+// and color default to what the feed advertises.
+type SubscribeCalendarRequest struct {
+	URL   string  `json:"url" binding:"required"`
+	Name  string  `json:"name"`
+	Color *string `json:"color"`
+}
+
+func HandleSubscribeCalendar(c *gin.Context) {
+	user := getAuthenticatedUser(c)
+	if user == nil {
+		return
+	}
+
+	var req SubscribeCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+		return
+	}
+
+	feedURL, err := ics.NormalizeURL(req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := database.GetUserCalendarByProviderCalendarId(user.ID, ics.ProviderName, feedURL)
+	if err != nil {
+		logger.Error.Printf("Failed to check existing calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check calendar"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Calendar already added"})
+		return
+	}
+
+	// Fetch once up front so a bad URL is reported to the user instead of
+	// showing up later as a polling error.
+	result, err := ics.Fetch(c.Request.Context(), feedURL, "", "")
+	if err != nil {
+		logger.Warn.Printf("Failed to fetch feed %s: %v", feedURL, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not load calendar: " + err.Error()})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = ics.Name(result.Calendar)
+	}
+	if name == "" {
+		if u, err := url.Parse(feedURL); err == nil {
+			name = u.Host
+		}
+	}
+
+	color := req.Color
+	if color == nil {
+		if feedColor := ics.Color(result.Calendar); feedColor != "" {
+			color = &feedColor
+		}
+	}
+
+	calendarID, err := database.CreateCalendar(database.Calendar{
+		UserID:             user.ID,
+		Provider:           ics.ProviderName,
+		ProviderCalendarID: feedURL,
+		Name:               name,
+		Color:              color,
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to create calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add calendar"})
+		return
+	}
+
+	cal, err := database.GetCalendarById(calendarID)
+	if err != nil || cal == nil {
+		logger.Error.Printf("Failed to load new calendar %s: %v", calendarID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add calendar"})
+		return
+	}
+
+	diff, err := ics.Poll(c.Request.Context(), cal)
+	if err != nil {
+		// The calendar stays; the poller retries and the error is shown in
+		// GET /api/calendars.
+		logger.Warn.Printf("Initial import of feed %s failed: %v", feedURL, err)
+		c.JSON(http.StatusCreated, gin.H{
+			"id":      calendarID,
+			"message": "Calendar added, but the first import failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      calendarID,
+		"message": "Calendar added successfully",
+		"events":  diff.Added,
+	})
+}
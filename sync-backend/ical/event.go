@@ -0,0 +1,251 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout        = "20060102"
+	dateTimeLayout    = "20060102T150405"
+	utcDateTimeLayout = "20060102T150405Z"
+)
+
+// Event is the subset of a VEVENT the calendar providers work with.
+// Recurrence holds the RRULE, RDATE, EXRULE and EXDATE content lines
+// verbatim, in the same form Google uses ("RRULE:FREQ=WEEKLY;BYDAY=MO").
+// TimeZone is the IANA zone of DTSTART, when it has a TZID Go knows.
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	TimeZone     string
+	Recurrence   []string
+	RecurrenceID string
+	Attendees    []string
+	Sequence     int
+}
+
+var recurrenceProps = map[string]bool{
+	"RRULE":  true,
+	"RDATE":  true,
+	"EXRULE": true,
+	"EXDATE": true,
+}
+
+// NewCalendar returns an empty VCALENDAR with the required properties.
+func NewCalendar(prodID string) *Component {
+	cal := NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", prodID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	return cal
+}
+
+// Events returns the VEVENTs of a calendar, resolving TZIDs against its
+// VTIMEZONEs where Go doesn't know them.
+func Events(cal *Component) ([]Event, error) {
+	zones := ParseTimeZones(cal)
+
+	var events []Event
+	for _, comp := range cal.ChildrenNamed("VEVENT") {
+		event, err := parseEvent(comp, zones)
+		if err != nil {
+			return nil, fmt.Errorf("event %q: %w", comp.Value("UID"), err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// ParseEvent reads a single VEVENT of cal.
+func ParseEvent(cal, vevent *Component) (Event, error) {
+	return parseEvent(vevent, ParseTimeZones(cal))
+}
+
+// Event reads a VEVENT using these time zones, saving the VTIMEZONE parse
+// when converting many events of one calendar.
+func (zones TimeZones) Event(vevent *Component) (Event, error) {
+	return parseEvent(vevent, zones)
+}
+
+func parseEvent(comp *Component, zones TimeZones) (Event, error) {
+	event := Event{
+		UID:          comp.Value("UID"),
+		Summary:      UnescapeText(comp.Value("SUMMARY")),
+		Description:  UnescapeText(comp.Value("DESCRIPTION")),
+		Location:     UnescapeText(comp.Value("LOCATION")),
+		Status:       strings.ToLower(comp.Value("STATUS")),
+		RecurrenceID: comp.Value("RECURRENCE-ID"),
+	}
+	if event.Status == "" {
+		event.Status = "confirmed"
+	}
+	if seq, err := strconv.Atoi(comp.Value("SEQUENCE")); err == nil {
+		event.Sequence = seq
+	}
+
+	start := comp.Prop("DTSTART")
+	if start == nil {
+		return event, fmt.Errorf("missing DTSTART")
+	}
+	var err error
+	event.Start, event.AllDay, err = zones.Time(start)
+	if err != nil {
+		return event, err
+	}
+	if tzid := start.Param("TZID"); tzid != "" && !event.AllDay {
+		if _, err := time.LoadLocation(tzid); err == nil {
+			event.TimeZone = tzid
+		}
+	}
+
+	if end := comp.Prop("DTEND"); end != nil {
+		event.End, _, err = zones.Time(end)
+		if err != nil {
+			return event, err
+		}
+	} else if duration := comp.Value("DURATION"); duration != "" {
+		d, err := ParseDuration(duration)
+		if err != nil {
+			return event, err
+		}
+		event.End = event.Start.Add(d)
+	} else if event.AllDay {
+		event.End = event.Start.AddDate(0, 0, 1)
+	} else {
+		event.End = event.Start
+	}
+
+	for _, p := range comp.Props {
+		if recurrenceProps[p.Name] {
+			event.Recurrence = append(event.Recurrence, encodeProperty(p))
+		}
+	}
+
+	for _, p := range comp.PropsNamed("ATTENDEE") {
+		email := p.Value
+		if len(email) > 7 && strings.EqualFold(email[:7], "mailto:") {
+			email = email[7:]
+		}
+		event.Attendees = append(event.Attendees, email)
+	}
+
+	return event, nil
+}
+
+// ParseDuration reads an RFC 5545 duration such as "PT1H30M" or "-P1D".
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	s = s[1:]
+
+	var total time.Duration
+	inTime := false
+	num := 0
+	digits := false
+
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num = num*10 + int(r-'0')
+			digits = true
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+
+		if !digits {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+
+		n := time.Duration(num)
+		switch {
+		case r == 'W' && !inTime:
+			total += n * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			total += n * 24 * time.Hour
+		case r == 'H' && inTime:
+			total += n * time.Hour
+		case r == 'M' && inTime:
+			total += n * time.Minute
+		case r == 'S' && inTime:
+			total += n * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		num = 0
+		digits = false
+	}
+
+	if digits {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+
+	return sign * total, nil
+}
+
+// EventComponent builds a VEVENT. Times are written in UTC, or as dates for
+// all-day events.
+func EventComponent(event Event) *Component {
+	comp := NewComponent("VEVENT")
+	comp.Add("UID", event.UID)
+	comp.Add("DTSTAMP", time.Now().UTC().Format(utcDateTimeLayout))
+	addTime(comp, "DTSTART", event.Start, event.AllDay)
+	addTime(comp, "DTEND", event.End, event.AllDay)
+
+	if event.Summary != "" {
+		comp.Add("SUMMARY", EscapeText(event.Summary))
+	}
+	if event.Description != "" {
+		comp.Add("DESCRIPTION", EscapeText(event.Description))
+	}
+	if event.Location != "" {
+		comp.Add("LOCATION", EscapeText(event.Location))
+	}
+	if event.Status != "" {
+		comp.Add("STATUS", strings.ToUpper(event.Status))
+	}
+	if event.Sequence > 0 {
+		comp.Add("SEQUENCE", strconv.Itoa(event.Sequence))
+	}
+
+	for _, line := range event.Recurrence {
+		if p, err := parseLine(line); err == nil && recurrenceProps[p.Name] {
+			comp.Props = append(comp.Props, p)
+		}
+	}
+
+	for _, email := range event.Attendees {
+		comp.Add("ATTENDEE", "mailto:"+email)
+	}
+
+	return comp
+}
+
+func addTime(comp *Component, name string, t time.Time, allDay bool) {
+	if allDay {
+		p := comp.Add(name, t.UTC().Format(dateLayout))
+		p.SetParam("VALUE", "DATE")
+		return
+	}
+	comp.Add(name, t.UTC().Format(utcDateTimeLayout))
+}
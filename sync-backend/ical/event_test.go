@@ -0,0 +1,315 @@
+package ical
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func parseCalendar(t *testing.T, body string) *Component {
+	t.Helper()
+	cal, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + body + "END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return cal
+}
+
+func vevent(lines ...string) string {
+	return "BEGIN:VEVENT\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VEVENT\r\n"
+}
+
+func TestEventTimes(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name   string
+		body   string
+		start  time.Time
+		end    time.Time
+		allDay bool
+	}{
+		{
+			name:  "utc",
+			body:  vevent("UID:1", "DTSTART:20260301T090000Z", "DTEND:20260301T100000Z"),
+			start: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "iana tzid",
+			body:  vevent("UID:1", "DTSTART;TZID=Europe/Berlin:20260301T090000", "DTEND;TZID=Europe/Berlin:20260301T100000"),
+			start: time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "iana tzid in summer",
+			body:  vevent("UID:1", "DTSTART;TZID=Europe/Berlin:20260701T090000", "DURATION:PT30M"),
+			start: time.Date(2026, 7, 1, 9, 0, 0, 0, berlin),
+			end:   time.Date(2026, 7, 1, 9, 30, 0, 0, berlin),
+		},
+		{
+			name:  "unknown tzid is read as utc",
+			body:  vevent("UID:1", "DTSTART;TZID=Nowhere/Special:20260301T090000", "DTEND;TZID=Nowhere/Special:20260301T100000"),
+			start: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "floating",
+			body:  vevent("UID:1", "DTSTART:20260301T090000", "DTEND:20260301T100000"),
+			start: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "all day",
+			body:   vevent("UID:1", "DTSTART;VALUE=DATE:20260301", "DTEND;VALUE=DATE:20260303"),
+			start:  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			end:    time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+			allDay: true,
+		},
+		{
+			name:   "all day without end",
+			body:   vevent("UID:1", "DTSTART;VALUE=DATE:20260301"),
+			start:  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			end:    time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+			allDay: true,
+		},
+		{
+			name:  "date-time without end",
+			body:  vevent("UID:1", "DTSTART:20260301T090000Z"),
+			start: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Events(parseCalendar(t, tt.body))
+			if err != nil {
+				t.Fatalf("Events failed: %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(events))
+			}
+			ev := events[0]
+			if !ev.Start.Equal(tt.start) || !ev.End.Equal(tt.end) {
+				t.Errorf("Expected %v - %v, got %v - %v", tt.start, tt.end, ev.Start, ev.End)
+			}
+			if ev.AllDay != tt.allDay {
+				t.Errorf("Expected all day %v, got %v", tt.allDay, ev.AllDay)
+			}
+		})
+	}
+}
+
+func TestEventFields(t *testing.T) {
+	cal := parseCalendar(t, vevent(
+		"UID:fields@example.com",
+		`SUMMARY:Review\, part 2`,
+		`DESCRIPTION:Agenda:\n1. Intro\; 2. Q&A`,
+		"LOCATION:Room 4",
+		"STATUS:TENTATIVE",
+		"SEQUENCE:3",
+		"DTSTART:20260301T090000Z",
+		"DTEND:20260301T100000Z",
+		"ATTENDEE;CN=Jane:MAILTO:jane@example.com",
+		"ATTENDEE:bob@example.com",
+	))
+
+	events, err := Events(cal)
+	if err != nil {
+		t.Fatalf("Events failed: %v", err)
+	}
+	ev := events[0]
+
+	if ev.Summary != "Review, part 2" || ev.Description != "Agenda:\n1. Intro; 2. Q&A" || ev.Location != "Room 4" {
+		t.Errorf("Expected unescaped text fields, got %q, %q, %q", ev.Summary, ev.Description, ev.Location)
+	}
+	if ev.Status != "tentative" || ev.Sequence != 3 {
+		t.Errorf("Expected status tentative and sequence 3, got %q and %d", ev.Status, ev.Sequence)
+	}
+	if want := []string{"jane@example.com", "bob@example.com"}; !reflect.DeepEqual(ev.Attendees, want) {
+		t.Errorf("Expected attendees %v, got %v", want, ev.Attendees)
+	}
+}
+
+func TestEventErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing dtstart", vevent("UID:1", "SUMMARY:x")},
+		{"invalid dtstart", vevent("UID:1", "DTSTART:tomorrow")},
+		{"invalid dtend", vevent("UID:1", "DTSTART:20260301T090000Z", "DTEND:20260301T25")},
+		{"invalid duration", vevent("UID:1", "DTSTART:20260301T090000Z", "DURATION:1H")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Events(parseCalendar(t, tt.body)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestRecurringEventWithOverride(t *testing.T) {
+	cal := parseCalendar(t, vevent(
+		"UID:standup@example.com",
+		"DTSTART;TZID=Europe/Berlin:20260302T093000",
+		"DTEND;TZID=Europe/Berlin:20260302T094500",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=6",
+		"EXDATE;TZID=Europe/Berlin:20260304T093000",
+		"SUMMARY:Standup",
+	)+vevent(
+		"UID:standup@example.com",
+		"RECURRENCE-ID;TZID=Europe/Berlin:20260309T093000",
+		"DTSTART;TZID=Europe/Berlin:20260309T110000",
+		"DTEND;TZID=Europe/Berlin:20260309T111500",
+		"SUMMARY:Standup (moved)",
+	))
+
+	events, err := Events(cal)
+	if err != nil {
+		t.Fatalf("Events failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected the series and its override, got %d events", len(events))
+	}
+	master, override := events[0], events[1]
+
+	wantRecurrence := []string{
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=6",
+		"EXDATE;TZID=Europe/Berlin:20260304T093000",
+	}
+	if !reflect.DeepEqual(master.Recurrence, wantRecurrence) {
+		t.Errorf("Expected recurrence %q, got %q", wantRecurrence, master.Recurrence)
+	}
+	if master.RecurrenceID != "" {
+		t.Errorf("Expected no RECURRENCE-ID on the series, got %q", master.RecurrenceID)
+	}
+	if override.UID != master.UID || override.RecurrenceID != "20260309T093000" {
+		t.Errorf("Expected the override of the 9 March occurrence, got %q %q", override.UID, override.RecurrenceID)
+	}
+	if want := time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC); !override.Start.Equal(want) {
+		t.Errorf("Expected the override to start at %v, got %v", want, override.Start)
+	}
+}
+
+func TestEventComponentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{
+			name: "timed",
+			event: Event{
+				UID:         "timed@example.com",
+				Summary:     "Plan; review, ship",
+				Description: "Line one\nLine two \\ done",
+				Location:    "HQ",
+				Status:      "confirmed",
+				Start:       time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+				End:         time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+				Recurrence:  []string{"RRULE:FREQ=DAILY;COUNT=3", "EXDATE:20260302T090000Z"},
+				Attendees:   []string{"jane@example.com"},
+				Sequence:    2,
+			},
+		},
+		{
+			name: "all day",
+			event: Event{
+				UID:    "allday@example.com",
+				Status: "confirmed",
+				Start:  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+				AllDay: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := NewCalendar("-//test//EN")
+			cal.Children = append(cal.Children, EventComponent(tt.event))
+
+			var buf strings.Builder
+			if err := Encode(&buf, cal); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			parsed, err := Parse(strings.NewReader(buf.String()))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			events, err := Events(parsed)
+			if err != nil {
+				t.Fatalf("Events failed: %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("Expected 1 event, got %d", len(events))
+			}
+			if !reflect.DeepEqual(events[0], tt.event) {
+				t.Errorf("Expected %+v, got %+v", tt.event, events[0])
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"PT1H30M", 90 * time.Minute, true},
+		{"P1D", 24 * time.Hour, true},
+		{"P1W", 7 * 24 * time.Hour, true},
+		{"P1DT2H3M4S", 26*time.Hour + 3*time.Minute + 4*time.Second, true},
+		{"-PT15M", -15 * time.Minute, true},
+		{"+PT5S", 5 * time.Second, true},
+		{"PT", 0, false},
+		{"P1H", 0, false},
+		{"PT1D", 0, false},
+		{"P1", 0, false},
+		{"1H", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Expected ok %v, got error %v", tt.ok, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEventTimeZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		dtstart  string
+		timeZone string
+	}{
+		{"iana tzid", "DTSTART;TZID=Europe/Berlin:20260301T090000", "Europe/Berlin"},
+		{"utc", "DTSTART:20260301T090000Z", ""},
+		{"floating", "DTSTART:20260301T090000", ""},
+		{"unknown tzid", "DTSTART;TZID=Nowhere/Special:20260301T090000", ""},
+		{"all day with tzid", "DTSTART;TZID=Europe/Berlin;VALUE=DATE:20260301", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Events(parseCalendar(t, vevent("UID:1", tt.dtstart)))
+			if err != nil {
+				t.Fatalf("Events failed: %v", err)
+			}
+			if events[0].TimeZone != tt.timeZone {
+				t.Errorf("Expected time zone %q, got %q", tt.timeZone, events[0].TimeZone)
+			}
+		})
+	}
+}
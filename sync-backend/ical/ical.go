@@ -0,0 +1,316 @@
+// Package ical reads and writes iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Property struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+// Param returns the first value of a property parameter.
+func (p *Property) Param(name string) string {
+	if values := p.Params[strings.ToUpper(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (p *Property) SetParam(name, value string) {
+	if p.Params == nil {
+		p.Params = make(map[string][]string)
+	}
+	p.Params[strings.ToUpper(name)] = []string{value}
+}
+
+// Component is a BEGIN/END block such as VCALENDAR, VEVENT or VTIMEZONE.
+type Component struct {
+	Name     string
+	Props    []*Property
+	Children []*Component
+}
+
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Prop returns the first property with the given name, or nil.
+func (c *Component) Prop(name string) *Property {
+	name = strings.ToUpper(name)
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Value returns the value of the first property with the given name.
+func (c *Component) Value(name string) string {
+	if p := c.Prop(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+func (c *Component) PropsNamed(name string) []*Property {
+	name = strings.ToUpper(name)
+	var props []*Property
+	for _, p := range c.Props {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Add appends a property without replacing existing ones of the same name.
+func (c *Component) Add(name, value string) *Property {
+	p := &Property{Name: strings.ToUpper(name), Value: value}
+	c.Props = append(c.Props, p)
+	return p
+}
+
+// Set replaces all properties of the given name with a single one.
+func (c *Component) Set(name, value string) *Property {
+	name = strings.ToUpper(name)
+	props := c.Props[:0]
+	for _, p := range c.Props {
+		if p.Name != name {
+			props = append(props, p)
+		}
+	}
+	c.Props = props
+	return c.Add(name, value)
+}
+
+func (c *Component) ChildrenNamed(name string) []*Component {
+	name = strings.ToUpper(name)
+	var children []*Component
+	for _, child := range c.Children {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Parse reads the first VCALENDAR object from r.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stack []*Component
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch prop.Name {
+		case "BEGIN":
+			comp := NewComponent(strings.ToUpper(prop.Value))
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, comp)
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, prop.Value)
+			}
+			comp := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				if comp.Name != "VCALENDAR" {
+					return nil, fmt.Errorf("expected VCALENDAR, got %s", comp.Name)
+				}
+				return comp, nil
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property outside of a component", i+1)
+			}
+			comp := stack[len(stack)-1]
+			comp.Props = append(comp.Props, prop)
+		}
+	}
+
+	return nil, fmt.Errorf("no complete VCALENDAR found")
+}
+
+// unfold joins continuation lines, which start with a space or tab.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLine splits "NAME;PARAM=a,b;Q=\"x:y\":value" into a Property.
+func parseLine(line string) (*Property, error) {
+	prop := &Property{}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.Name = strings.ToUpper(line[:i])
+	rest := line[i:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed parameter in %q", line)
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var values []string
+		for {
+			var value string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated quoted parameter in %q", line)
+				}
+				value = rest[1 : end+1]
+				rest = rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ",;:")
+				if end < 0 {
+					return nil, fmt.Errorf("malformed parameter in %q", line)
+				}
+				value = rest[:end]
+				rest = rest[end:]
+			}
+			values = append(values, value)
+
+			if !strings.HasPrefix(rest, ",") {
+				break
+			}
+			rest = rest[1:]
+		}
+
+		prop.SetParam(name, "")
+		prop.Params[name] = values
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return nil, fmt.Errorf("missing value in %q", line)
+	}
+	prop.Value = rest[1:]
+
+	return prop, nil
+}
+
+// Encode writes the component with CRLF line endings, folding lines longer
+// than 75 octets.
+func Encode(w io.Writer, c *Component) error {
+	bw := bufio.NewWriter(w)
+	encodeComponent(bw, c)
+	return bw.Flush()
+}
+
+func encodeComponent(w *bufio.Writer, c *Component) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		writeLine(w, encodeProperty(p))
+	}
+	for _, child := range c.Children {
+		encodeComponent(w, child)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+func encodeProperty(p *Property) string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b.WriteString(";" + name + "=")
+		for i, value := range p.Params[name] {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if strings.ContainsAny(value, ";:,") {
+				value = `"` + value + `"`
+			}
+			b.WriteString(value)
+		}
+	}
+
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+func writeLine(w *bufio.Writer, line string) {
+	// Continuation lines start with a space, which counts towards the limit.
+	limit := 75
+	for len(line) > limit {
+		// Don't split a multi-byte UTF-8 sequence.
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	w.WriteString(line + "\r\n")
+}
+
+// EscapeText escapes a TEXT value.
+func EscapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// UnescapeText reverses EscapeText.
+func UnescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+package ical
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUnfold(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "crlf",
+			input: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+			want:  []string{"BEGIN:VCALENDAR", "VERSION:2.0"},
+		},
+		{
+			name:  "space continuation",
+			input: "DESCRIPTION:This is a lo\r\n ng description\r\n",
+			want:  []string{"DESCRIPTION:This is a long description"},
+		},
+		{
+			name:  "tab continuation",
+			input: "SUMMARY:Team\n\t meeting\n",
+			want:  []string{"SUMMARY:Team meeting"},
+		},
+		{
+			name:  "several continuations",
+			input: "X-A:1\r\n 2\r\n 3\r\nX-B:4\r\n",
+			want:  []string{"X-A:123", "X-B:4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unfold(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("unfold failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		params map[string][]string
+		value  string
+	}{
+		{
+			line:  "SUMMARY:Lunch",
+			name:  "SUMMARY",
+			value: "Lunch",
+		},
+		{
+			line:   "dtstart;tzid=Europe/Berlin:20260301T090000",
+			name:   "DTSTART",
+			params: map[string][]string{"TZID": {"Europe/Berlin"}},
+			value:  "20260301T090000",
+		},
+		{
+			line:   `ATTENDEE;CN="Doe, Jane";ROLE=REQ-PARTICIPANT:mailto:jane@example.com`,
+			name:   "ATTENDEE",
+			params: map[string][]string{"CN": {"Doe, Jane"}, "ROLE": {"REQ-PARTICIPANT"}},
+			value:  "mailto:jane@example.com",
+		},
+		{
+			line:   `X-LIST;MEMBER="a:b",c:value:with:colons`,
+			name:   "X-LIST",
+			params: map[string][]string{"MEMBER": {"a:b", "c"}},
+			value:  "value:with:colons",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			p, err := parseLine(tt.line)
+			if err != nil {
+				t.Fatalf("parseLine failed: %v", err)
+			}
+			if p.Name != tt.name || p.Value != tt.value {
+				t.Errorf("Expected %s:%s, got %s:%s", tt.name, tt.value, p.Name, p.Value)
+			}
+			if len(tt.params) > 0 && !reflect.DeepEqual(p.Params, tt.params) {
+				t.Errorf("Expected params %v, got %v", tt.params, p.Params)
+			}
+		})
+	}
+
+	for _, line := range []string{"NOVALUE", ":value", "X;PARAM:value", `X;P="open:value`} {
+		if _, err := parseLine(line); err == nil {
+			t.Errorf("Expected an error for %q", line)
+		}
+	}
+}
+
+func TestEscapeTextRoundTrip(t *testing.T) {
+	tests := []struct {
+		text    string
+		escaped string
+	}{
+		{"plain", "plain"},
+		{"a, b; c", `a\, b\; c`},
+		{`back\slash`, `back\\slash`},
+		{"two\nlines", `two\nlines`},
+		{"windows\r\nline", `windows\nline`},
+		{`\n is not a newline`, `\\n is not a newline`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			escaped := EscapeText(tt.text)
+			if escaped != tt.escaped {
+				t.Errorf("Expected %q, got %q", tt.escaped, escaped)
+			}
+			want := strings.ReplaceAll(tt.text, "\r\n", "\n")
+			if got := UnescapeText(escaped); got != want {
+				t.Errorf("Expected %q after unescaping, got %q", want, got)
+			}
+		})
+	}
+
+	if got := UnescapeText(`Upper\NCase`); got != "Upper\nCase" {
+		t.Errorf("Expected \\N to unescape to a newline, got %q", got)
+	}
+}
+
+func TestEncodeFoldsAndParsesBack(t *testing.T) {
+	long := strings.Repeat("Grüße, ", 40) + "end"
+
+	cal := NewCalendar("-//test//EN")
+	event := NewComponent("VEVENT")
+	event.Add("UID", "fold@example.com")
+	event.Add("SUMMARY", EscapeText(long))
+	p := event.Add("ATTENDEE", "mailto:jane@example.com")
+	p.SetParam("CN", "Doe, Jane")
+	cal.Children = append(cal.Children, event)
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, cal); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Expected lines of at most 75 octets, got %d: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("Expected folding to keep UTF-8 sequences whole, got %q", line)
+		}
+	}
+
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	events := parsed.ChildrenNamed("VEVENT")
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if got := UnescapeText(events[0].Value("SUMMARY")); got != long {
+		t.Errorf("Expected the summary to survive folding, got %q", got)
+	}
+	if got := events[0].Prop("ATTENDEE").Param("CN"); got != "Doe, Jane" {
+		t.Errorf("Expected the quoted parameter to survive, got %q", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unbalanced", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"not a calendar", "BEGIN:VEVENT\r\nEND:VEVENT\r\n"},
+		{"property outside", "SUMMARY:x\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"},
+		{"truncated", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedRule is returned for recurrence rules Occurrences can't
+// expand, such as ones using BYSETPOS or BYWEEKNO.
+var ErrUnsupportedRule = errors.New("unsupported recurrence rule")
+
+// maxPeriods bounds the expansion of rules without COUNT or UNTIL.
+const maxPeriods = 50000
+
+type weekdayNum struct {
+	n   int
+	day time.Weekday
+}
+
+type rule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []time.Month
+}
+
+func parseRule(value string) (*rule, error) {
+	r := &rule{interval: 1}
+
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			r.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", val)
+			}
+			r.count = n
+		case "UNTIL":
+			t, _, err := TimeZones(nil).Time(&Property{Value: val})
+			if err != nil {
+				return nil, err
+			}
+			r.until = t
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				day = strings.ToUpper(day)
+				if len(day) < 2 {
+					return nil, fmt.Errorf("invalid BYDAY %q", val)
+				}
+				wd, ok := weekdays[day[len(day)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid BYDAY %q", val)
+				}
+				var n int
+				if prefix := day[:len(day)-2]; prefix != "" {
+					var err error
+					if n, err = strconv.Atoi(prefix); err != nil {
+						return nil, fmt.Errorf("invalid BYDAY %q", val)
+					}
+				}
+				r.byDay = append(r.byDay, weekdayNum{n: n, day: wd})
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", val)
+				}
+				r.byMonthDay = append(r.byMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH %q", val)
+				}
+				r.byMonth = append(r.byMonth, time.Month(n))
+			}
+		case "WKST":
+			// Only matters for weekly rules with an interval and BYDAY
+			// spanning the week start; Monday is assumed.
+		default:
+			return nil, ErrUnsupportedRule
+		}
+	}
+
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, ErrUnsupportedRule
+	}
+	if r.freq == "YEARLY" && len(r.byDay) > 0 && len(r.byMonth) == 0 {
+		return nil, ErrUnsupportedRule
+	}
+	return r, nil
+}
+
+// Occurrences returns the starts of a recurring event's occurrences that fall
+// in [from, to), at most limit of them. start is the event's DTSTART in the
+// zone it repeats in, so occurrences keep their wall-clock time across DST
+// changes. recurrence holds the RRULE, RDATE and EXDATE lines of the event;
+// EXRULE is ignored.
+func Occurrences(start time.Time, recurrence []string, from, to time.Time, limit int) ([]time.Time, error) {
+	var rules []*rule
+	var rdates []time.Time
+	excluded := make(map[int64]bool)
+
+	for _, line := range recurrence {
+		p, err := parseLine(strings.TrimSpace(line))
+		if err != nil {
+			return nil, err
+		}
+		switch p.Name {
+		case "RRULE":
+			r, err := parseRule(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, r)
+		case "RDATE", "EXDATE":
+			for _, value := range strings.Split(p.Value, ",") {
+				single := &Property{Name: p.Name, Params: p.Params, Value: value}
+				t, allDay, err := TimeZones(nil).Time(single)
+				if err != nil {
+					return nil, err
+				}
+				if allDay {
+					t = time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+				}
+				if p.Name == "RDATE" {
+					rdates = append(rdates, t)
+				} else {
+					excluded[t.Unix()] = true
+				}
+			}
+		}
+	}
+
+	seen := map[int64]bool{start.Unix(): true}
+	all := []time.Time{start}
+	for _, r := range rules {
+		for _, t := range r.expand(start, to) {
+			if !seen[t.Unix()] {
+				seen[t.Unix()] = true
+				all = append(all, t)
+			}
+		}
+	}
+	for _, t := range rdates {
+		if !seen[t.Unix()] {
+			seen[t.Unix()] = true
+			all = append(all, t)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Before(all[j]) })
+
+	var result []time.Time
+	for _, t := range all {
+		if excluded[t.Unix()] || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		result = append(result, t)
+		if len(result) >= limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// expand returns the rule's occurrences after start and before to. COUNT
+// counts start itself, which is always the first occurrence.
+func (r *rule) expand(start, to time.Time) []time.Time {
+	var result []time.Time
+	n := 1
+
+	for period := 0; period < maxPeriods; period++ {
+		candidates := r.candidates(start, period)
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+		for _, t := range candidates {
+			if !t.After(start) {
+				continue
+			}
+			if !r.until.IsZero() && t.After(r.until) {
+				return result
+			}
+			if r.count > 0 && n >= r.count {
+				return result
+			}
+			if !t.Before(to) {
+				return result
+			}
+			n++
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// candidates returns the dates the rule selects in one period: a day, week,
+// month or year, counted in intervals from start.
+func (r *rule) candidates(start time.Time, period int) []time.Time {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	step := period * r.interval
+
+	var dates []time.Time
+	switch r.freq {
+	case "DAILY":
+		t := at(start.Year(), start.Month(), start.Day()+step)
+		if r.matchesMonth(t) && r.matchesMonthDay(t) && r.matchesWeekday(t) {
+			dates = append(dates, t)
+		}
+	case "WEEKLY":
+		monday := start.Day() - (int(start.Weekday())+6)%7 + 7*step
+		days := []time.Weekday{start.Weekday()}
+		if len(r.byDay) > 0 {
+			days = days[:0]
+			for _, wd := range r.byDay {
+				days = append(days, wd.day)
+			}
+		}
+		for _, day := range days {
+			t := at(start.Year(), start.Month(), monday+(int(day)+6)%7)
+			if r.matchesMonth(t) {
+				dates = append(dates, t)
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		if r.matchesMonth(first) {
+			dates = r.monthDays(start, first.Year(), first.Month(), at)
+		}
+	case "YEARLY":
+		year := start.Year() + step
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []time.Month{start.Month()}
+		}
+		for _, month := range months {
+			dates = append(dates, r.monthDays(start, year, month, at)...)
+		}
+	}
+	return dates
+}
+
+// monthDays returns the days of one month selected by BYMONTHDAY and BYDAY,
+// or start's day of the month when neither is given.
+func (r *rule) monthDays(start time.Time, year int, month time.Month, at func(int, time.Month, int) time.Time) []time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	var days []int
+	switch {
+	case len(r.byMonthDay) > 0:
+		for _, n := range r.byMonthDay {
+			day := n
+			if n < 0 {
+				day = last + n + 1
+			}
+			if day >= 1 && day <= last {
+				days = append(days, day)
+			}
+		}
+	case len(r.byDay) > 0:
+		for _, wd := range r.byDay {
+			var matching []int
+			for day := 1; day <= last; day++ {
+				if time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() == wd.day {
+					matching = append(matching, day)
+				}
+			}
+			switch {
+			case wd.n == 0:
+				days = append(days, matching...)
+			case wd.n > 0 && wd.n <= len(matching):
+				days = append(days, matching[wd.n-1])
+			case wd.n < 0 && -wd.n <= len(matching):
+				days = append(days, matching[len(matching)+wd.n])
+			}
+		}
+	default:
+		if start.Day() <= last {
+			days = append(days, start.Day())
+		}
+	}
+
+	var dates []time.Time
+	for _, day := range days {
+		t := at(year, month, day)
+		if len(r.byMonthDay) > 0 && !r.matchesWeekday(t) {
+			continue
+		}
+		dates = append(dates, t)
+	}
+	return dates
+}
+
+func (r *rule) matchesMonth(t time.Time) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, month := range r.byMonth {
+		if t.Month() == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesMonthDay(t time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, n := range r.byMonthDay {
+		if n == t.Day() || n < 0 && last+n+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchesWeekday(t time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, wd := range r.byDay {
+		if wd.day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package ical
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOccurrences(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	local := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, berlin)
+	}
+	year2026 := [2]time.Time{utc(2026, 1, 1, 0, 0), utc(2027, 1, 1, 0, 0)}
+
+	tests := []struct {
+		name       string
+		start      time.Time
+		recurrence []string
+		window     [2]time.Time
+		limit      int
+		want       []time.Time
+	}{
+		{
+			name:       "daily count includes start",
+			start:      utc(2026, 3, 1, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY;COUNT=3"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 3, 1, 9, 0), utc(2026, 3, 2, 9, 0), utc(2026, 3, 3, 9, 0)},
+		},
+		{
+			name:       "until is inclusive",
+			start:      utc(2026, 3, 1, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY;INTERVAL=2;UNTIL=20260305T090000Z"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 3, 1, 9, 0), utc(2026, 3, 3, 9, 0), utc(2026, 3, 5, 9, 0)},
+		},
+		{
+			name:       "until as date",
+			start:      utc(2026, 3, 1, 9, 0),
+			recurrence: []string{"RRULE:FREQ=WEEKLY;UNTIL=20260315"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 3, 1, 9, 0), utc(2026, 3, 8, 9, 0)},
+		},
+		{
+			name:       "weekly byday with interval",
+			start:      utc(2026, 3, 3, 14, 0),
+			recurrence: []string{"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;COUNT=5"},
+			window:     year2026,
+			want: []time.Time{
+				utc(2026, 3, 3, 14, 0), utc(2026, 3, 5, 14, 0),
+				utc(2026, 3, 17, 14, 0), utc(2026, 3, 19, 14, 0),
+				utc(2026, 3, 31, 14, 0),
+			},
+		},
+		{
+			name:       "monthly last friday",
+			start:      utc(2026, 1, 30, 16, 0),
+			recurrence: []string{"RRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=4"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 1, 30, 16, 0), utc(2026, 2, 27, 16, 0), utc(2026, 3, 27, 16, 0), utc(2026, 4, 24, 16, 0)},
+		},
+		{
+			name:       "monthly skips short months",
+			start:      utc(2026, 1, 31, 8, 0),
+			recurrence: []string{"RRULE:FREQ=MONTHLY;COUNT=3"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 1, 31, 8, 0), utc(2026, 3, 31, 8, 0), utc(2026, 5, 31, 8, 0)},
+		},
+		{
+			name:       "monthly byday and bymonthday",
+			start:      utc(2026, 2, 13, 20, 0),
+			recurrence: []string{"RRULE:FREQ=MONTHLY;BYMONTHDAY=13;BYDAY=FR"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 2, 13, 20, 0), utc(2026, 3, 13, 20, 0), utc(2026, 11, 13, 20, 0)},
+		},
+		{
+			name:       "yearly fourth thursday of november",
+			start:      utc(2026, 11, 26, 17, 0),
+			recurrence: []string{"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH"},
+			window:     [2]time.Time{utc(2026, 1, 1, 0, 0), utc(2029, 1, 1, 0, 0)},
+			want:       []time.Time{utc(2026, 11, 26, 17, 0), utc(2027, 11, 25, 17, 0), utc(2028, 11, 23, 17, 0)},
+		},
+		{
+			name:       "wall clock kept across dst",
+			start:      local(2026, 3, 27, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY;COUNT=4"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 3, 27, 8, 0), utc(2026, 3, 28, 8, 0), utc(2026, 3, 29, 7, 0), utc(2026, 3, 30, 7, 0)},
+		},
+		{
+			name:  "exdate in utc and with tzid",
+			start: local(2026, 10, 23, 9, 0),
+			recurrence: []string{
+				"RRULE:FREQ=DAILY;COUNT=5",
+				"EXDATE:20261024T070000Z",
+				"EXDATE;TZID=Europe/Berlin:20261026T090000",
+			},
+			window: year2026,
+			want:   []time.Time{local(2026, 10, 23, 9, 0), local(2026, 10, 25, 9, 0), local(2026, 10, 27, 9, 0)},
+		},
+		{
+			name:       "exdate as date",
+			start:      local(2026, 10, 23, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY;COUNT=3", "EXDATE;VALUE=DATE:20261024"},
+			window:     year2026,
+			want:       []time.Time{local(2026, 10, 23, 9, 0), local(2026, 10, 25, 9, 0)},
+		},
+		{
+			name:       "rdate adds and deduplicates",
+			start:      utc(2026, 3, 1, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY;COUNT=2", "RDATE:20260310T120000Z,20260302T090000Z"},
+			window:     year2026,
+			want:       []time.Time{utc(2026, 3, 1, 9, 0), utc(2026, 3, 2, 9, 0), utc(2026, 3, 10, 12, 0)},
+		},
+		{
+			name:       "window and limit",
+			start:      utc(2026, 3, 1, 9, 0),
+			recurrence: []string{"RRULE:FREQ=DAILY"},
+			window:     [2]time.Time{utc(2026, 3, 10, 0, 0), utc(2026, 4, 1, 0, 0)},
+			limit:      2,
+			want:       []time.Time{utc(2026, 3, 10, 9, 0), utc(2026, 3, 11, 9, 0)},
+		},
+		{
+			name:   "no rule",
+			start:  utc(2026, 3, 1, 9, 0),
+			window: year2026,
+			want:   []time.Time{utc(2026, 3, 1, 9, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = 100
+			}
+			got, err := Occurrences(tt.start, tt.recurrence, tt.window[0], tt.window[1], limit)
+			if err != nil {
+				t.Fatalf("Occurrences failed: %v", err)
+			}
+			assertTimes(t, got, tt.want)
+		})
+	}
+}
+
+func TestOccurrencesRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule        string
+		unsupported bool
+	}{
+		{"FREQ=MONTHLY;BYDAY=MO;BYSETPOS=1", true},
+		{"FREQ=YEARLY;BYWEEKNO=20", true},
+		{"FREQ=HOURLY;COUNT=3", true},
+		{"FREQ=YEARLY;BYDAY=MO", true},
+		{"COUNT=3", true},
+		{"FREQ=DAILY;INTERVAL=0", false},
+		{"FREQ=DAILY;COUNT=x", false},
+		{"FREQ=WEEKLY;BYDAY=XX", false},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", false},
+		{"FREQ=YEARLY;BYMONTH=13", false},
+		{"FREQ=DAILY;UNTIL=soon", false},
+	}
+
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := Occurrences(start, []string{"RRULE:" + tt.rule}, start, start.AddDate(1, 0, 0), 10)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if errors.Is(err, ErrUnsupportedRule) != tt.unsupported {
+				t.Errorf("Expected unsupported %v, got %v", tt.unsupported, err)
+			}
+		})
+	}
+}
+
+func TestOccurrencesOfParsedEvent(t *testing.T) {
+	cal := parseCalendar(t, vevent(
+		"UID:standup@example.com",
+		"DTSTART;TZID=Europe/Berlin:20260302T093000",
+		"DTEND;TZID=Europe/Berlin:20260302T094500",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=6",
+		"EXDATE;TZID=Europe/Berlin:20260304T093000",
+	))
+	events, err := Events(cal)
+	if err != nil {
+		t.Fatalf("Events failed: %v", err)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	occurrences, err := Occurrences(events[0].Start.In(berlin), events[0].Recurrence,
+		time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 100)
+	if err != nil {
+		t.Fatalf("Occurrences failed: %v", err)
+	}
+	// Six occurrences counted, the excluded Wednesday among them.
+	want := []time.Time{
+		time.Date(2026, 3, 2, 9, 30, 0, 0, berlin),
+		time.Date(2026, 3, 9, 9, 30, 0, 0, berlin),
+		time.Date(2026, 3, 11, 9, 30, 0, 0, berlin),
+		time.Date(2026, 3, 16, 9, 30, 0, 0, berlin),
+		time.Date(2026, 3, 18, 9, 30, 0, 0, berlin),
+	}
+	assertTimes(t, occurrences, want)
+}
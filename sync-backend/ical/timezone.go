@@ -0,0 +1,289 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeZone is a VTIMEZONE definition. It is used for TZIDs Go's time zone
+// database doesn't know, such as Windows names exported by Outlook.
+type TimeZone struct {
+	ID          string
+	observances []observance
+}
+
+type observance struct {
+	start      time.Time // local wall clock, stored as UTC
+	offsetFrom int
+	offsetTo   int
+	rule       *yearlyRule
+	rdates     []time.Time
+}
+
+// yearlyRule is the RRULE subset time zones use in practice:
+// FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU, optionally with UNTIL.
+type yearlyRule struct {
+	month   time.Month
+	week    int
+	weekday time.Weekday
+	until   time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// TimeZones maps TZIDs to the VTIMEZONEs of a calendar.
+type TimeZones map[string]*TimeZone
+
+// ParseTimeZones reads the VTIMEZONE components of a calendar. Definitions
+// that can't be understood are skipped.
+func ParseTimeZones(cal *Component) TimeZones {
+	zones := make(TimeZones)
+	for _, comp := range cal.ChildrenNamed("VTIMEZONE") {
+		if tz, err := parseTimeZone(comp); err == nil {
+			zones[tz.ID] = tz
+		}
+	}
+	return zones
+}
+
+func parseTimeZone(comp *Component) (*TimeZone, error) {
+	tz := &TimeZone{ID: comp.Value("TZID")}
+	if tz.ID == "" {
+		return nil, fmt.Errorf("VTIMEZONE without TZID")
+	}
+
+	for _, child := range comp.Children {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+
+		var obs observance
+		var err error
+
+		obs.start, err = time.ParseInLocation(dateTimeLayout, child.Value("DTSTART"), time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid observance DTSTART in %s", tz.ID)
+		}
+		if obs.offsetFrom, err = parseUTCOffset(child.Value("TZOFFSETFROM")); err != nil {
+			return nil, err
+		}
+		if obs.offsetTo, err = parseUTCOffset(child.Value("TZOFFSETTO")); err != nil {
+			return nil, err
+		}
+
+		if rrule := child.Value("RRULE"); rrule != "" {
+			if obs.rule, err = parseYearlyRule(rrule); err != nil {
+				return nil, fmt.Errorf("%s: %w", tz.ID, err)
+			}
+		}
+		for _, p := range child.PropsNamed("RDATE") {
+			for _, value := range strings.Split(p.Value, ",") {
+				if t, err := time.ParseInLocation(dateTimeLayout, value, time.UTC); err == nil {
+					obs.rdates = append(obs.rdates, t)
+				}
+			}
+		}
+
+		tz.observances = append(tz.observances, obs)
+	}
+
+	if len(tz.observances) == 0 {
+		return nil, fmt.Errorf("VTIMEZONE %s has no observances", tz.ID)
+	}
+	return tz, nil
+}
+
+// parseUTCOffset reads "+0100" or "-023000" into seconds east of UTC.
+func parseUTCOffset(s string) (int, error) {
+	if len(s) != 5 && len(s) != 7 {
+		return 0, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	sign := 1
+	switch s[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, fmt.Errorf("invalid UTC offset %q", s)
+	}
+
+	n, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	seconds := 0
+	if len(s) == 7 {
+		seconds = n % 100
+		n /= 100
+	}
+	return sign * ((n/100)*3600 + (n%100)*60 + seconds), nil
+}
+
+func parseYearlyRule(rrule string) (*yearlyRule, error) {
+	rule := &yearlyRule{}
+	for _, part := range strings.Split(rrule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			if !strings.EqualFold(value, "YEARLY") {
+				return nil, fmt.Errorf("unsupported time zone rule %q", rrule)
+			}
+		case "BYMONTH":
+			month, err := strconv.Atoi(value)
+			if err != nil || month < 1 || month > 12 {
+				return nil, fmt.Errorf("unsupported time zone rule %q", rrule)
+			}
+			rule.month = time.Month(month)
+		case "BYDAY":
+			day, ok := weekdays[strings.ToUpper(value[max(len(value)-2, 0):])]
+			if !ok {
+				return nil, fmt.Errorf("unsupported time zone rule %q", rrule)
+			}
+			week := 1
+			if prefix := value[:len(value)-2]; prefix != "" {
+				n, err := strconv.Atoi(prefix)
+				if err != nil {
+					return nil, fmt.Errorf("unsupported time zone rule %q", rrule)
+				}
+				week = n
+			}
+			rule.weekday, rule.week = day, week
+		case "UNTIL":
+			value = strings.TrimSuffix(value, "Z")
+			if until, err := time.ParseInLocation(dateTimeLayout, value, time.UTC); err == nil {
+				rule.until = until
+			} else if until, err := time.ParseInLocation(dateLayout, value, time.UTC); err == nil {
+				rule.until = until
+			}
+		}
+	}
+
+	if rule.month == 0 {
+		return nil, fmt.Errorf("unsupported time zone rule %q", rrule)
+	}
+	return rule, nil
+}
+
+// onset returns the local wall clock time the rule fires in a year.
+func (r *yearlyRule) onset(year int, clock time.Time) (time.Time, bool) {
+	hour, min, sec := clock.Clock()
+
+	var day time.Time
+	if r.week > 0 {
+		first := time.Date(year, r.month, 1, hour, min, sec, 0, time.UTC)
+		shift := (int(r.weekday) - int(first.Weekday()) + 7) % 7
+		day = first.AddDate(0, 0, shift+7*(r.week-1))
+	} else {
+		last := time.Date(year, r.month+1, 0, hour, min, sec, 0, time.UTC)
+		shift := (int(last.Weekday()) - int(r.weekday) + 7) % 7
+		day = last.AddDate(0, 0, -shift+7*(r.week+1))
+	}
+
+	if day.Month() != r.month {
+		return time.Time{}, false
+	}
+	if !r.until.IsZero() && day.After(r.until.Add(time.Duration(24)*time.Hour)) {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+type transition struct {
+	at  time.Time // UTC instant
+	obs *observance
+}
+
+func (tz *TimeZone) transitions(year int) []transition {
+	var result []transition
+	for i := range tz.observances {
+		obs := &tz.observances[i]
+		onsets := append([]time.Time{}, obs.rdates...)
+		onsets = append(onsets, obs.start)
+		if obs.rule != nil {
+			for y := year - 1; y <= year; y++ {
+				if y < obs.start.Year() {
+					continue
+				}
+				if t, ok := obs.rule.onset(y, obs.start); ok && !t.Before(obs.start) {
+					onsets = append(onsets, t)
+				}
+			}
+		}
+		for _, local := range onsets {
+			result = append(result, transition{
+				at:  local.Add(-time.Duration(obs.offsetFrom) * time.Second),
+				obs: obs,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].at.Before(result[j].at) })
+	return result
+}
+
+// Convert interprets a wall clock time (given with UTC as location) in this
+// time zone and returns the instant.
+func (tz *TimeZone) Convert(local time.Time) time.Time {
+	transitions := tz.transitions(local.Year())
+
+	for i := len(transitions) - 1; i >= 0; i-- {
+		t := transitions[i]
+		candidate := local.Add(-time.Duration(t.obs.offsetTo) * time.Second)
+		if !candidate.Before(t.at) {
+			return candidate
+		}
+	}
+
+	// Before the first known transition: use the earliest offset.
+	if len(transitions) > 0 {
+		return local.Add(-time.Duration(transitions[0].obs.offsetFrom) * time.Second)
+	}
+	return local
+}
+
+// Time reads a DATE or DATE-TIME property value and reports whether it was a
+// date. TZIDs are looked up in Go's time zone database first and in the
+// calendar's VTIMEZONEs second; floating and unknown times are read as UTC.
+func (zones TimeZones) Time(p *Property) (time.Time, bool, error) {
+	value := p.Value
+
+	if strings.EqualFold(p.Param("VALUE"), "DATE") || len(value) == len(dateLayout) {
+		t, err := time.ParseInLocation(dateLayout, value, time.UTC)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(utcDateTimeLayout, value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+		}
+		return t, false, nil
+	}
+
+	local, err := time.ParseInLocation(dateTimeLayout, value, time.UTC)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+	}
+
+	tzid := p.Param("TZID")
+	if tzid == "" {
+		return local, false, nil
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return time.Date(local.Year(), local.Month(), local.Day(),
+			local.Hour(), local.Minute(), local.Second(), 0, loc), false, nil
+	}
+	if tz, ok := zones[tzid]; ok {
+		return tz.Convert(local), false, nil
+	}
+	return local, false, nil
+}
@@ -0,0 +1,179 @@
+package ical
+
+import (
+	"testing"
+	"time"
+)
+
+// windowsZone is a VTIMEZONE as Outlook exports it, under a name Go's time
+// zone database doesn't know.
+const windowsZone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:W. Europe Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:+0100\r\n" +
+	"TZOFFSETTO:+0200\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n"
+
+// easternZone uses first- and second-week rules rather than the last week.
+const easternZone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:Eastern Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0400\r\n" +
+	"TZOFFSETTO:-0500\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:-0500\r\n" +
+	"TZOFFSETTO:-0400\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n"
+
+func TestParseUTCOffset(t *testing.T) {
+	tests := []struct {
+		value   string
+		seconds int
+		ok      bool
+	}{
+		{"+0000", 0, true},
+		{"+0100", 3600, true},
+		{"-0500", -5 * 3600, true},
+		{"+0530", 5*3600 + 30*60, true},
+		{"+0545", 5*3600 + 45*60, true},
+		{"-003000", -30 * 60, true},
+		{"+001215", 12*60 + 15, true},
+		{"0100", 0, false},
+		{"+1", 0, false},
+		{"+01:00", 0, false},
+		{"+01a0", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			seconds, err := parseUTCOffset(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Expected ok %v, got error %v", tt.ok, err)
+			}
+			if seconds != tt.seconds {
+				t.Errorf("Expected %d, got %d", tt.seconds, seconds)
+			}
+		})
+	}
+}
+
+func TestTimeZoneConvert(t *testing.T) {
+	cal := parseCalendar(t, windowsZone+easternZone)
+	zones := ParseTimeZones(cal)
+
+	west, ok := zones["W. Europe Standard Time"]
+	if !ok {
+		t.Fatal("Expected the W. Europe zone to be parsed")
+	}
+	east, ok := zones["Eastern Standard Time"]
+	if !ok {
+		t.Fatal("Expected the Eastern zone to be parsed")
+	}
+
+	wall := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		zone  *TimeZone
+		local time.Time
+		want  time.Time
+	}{
+		{"winter", west, wall(1, 15, 12, 0), wall(1, 15, 11, 0)},
+		{"before spring forward", west, wall(3, 29, 1, 59), wall(3, 29, 0, 59)},
+		{"after spring forward", west, wall(3, 29, 3, 0), wall(3, 29, 1, 0)},
+		{"summer", west, wall(7, 1, 12, 0), wall(7, 1, 10, 0)},
+		{"before fall back", west, wall(10, 25, 1, 59), wall(10, 24, 23, 59)},
+		{"after fall back", west, wall(10, 25, 3, 0), wall(10, 25, 2, 0)},
+		{"december", west, wall(12, 31, 23, 0), wall(12, 31, 22, 0)},
+		{"eastern winter", east, wall(1, 15, 12, 0), wall(1, 15, 17, 0)},
+		{"eastern after spring forward", east, wall(3, 8, 3, 0), wall(3, 8, 7, 0)},
+		{"eastern summer", east, wall(7, 1, 12, 0), wall(7, 1, 16, 0)},
+		{"eastern after fall back", east, wall(11, 1, 2, 0), wall(11, 1, 7, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.zone.Convert(tt.local); !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTimeZonesSkipsInvalid(t *testing.T) {
+	cal := parseCalendar(t, ""+
+		"BEGIN:VTIMEZONE\r\nTZID:No Observances\r\nEND:VTIMEZONE\r\n"+
+		"BEGIN:VTIMEZONE\r\nBEGIN:STANDARD\r\nDTSTART:19700101T000000\r\n"+
+		"TZOFFSETFROM:+0100\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n"+
+		"BEGIN:VTIMEZONE\r\nTZID:Bad Offset\r\nBEGIN:STANDARD\r\nDTSTART:19700101T000000\r\n"+
+		"TZOFFSETFROM:+1\r\nTZOFFSETTO:+0100\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n"+
+		"BEGIN:VTIMEZONE\r\nTZID:Monthly\r\nBEGIN:STANDARD\r\nDTSTART:19700101T000000\r\n"+
+		"TZOFFSETFROM:+0100\r\nTZOFFSETTO:+0100\r\nRRULE:FREQ=MONTHLY;BYMONTHDAY=1\r\n"+
+		"END:STANDARD\r\nEND:VTIMEZONE\r\n"+
+		windowsZone)
+
+	zones := ParseTimeZones(cal)
+	if len(zones) != 1 {
+		t.Errorf("Expected only the valid zone, got %d zones", len(zones))
+	}
+	if _, ok := zones["W. Europe Standard Time"]; !ok {
+		t.Error("Expected the valid zone to be kept")
+	}
+}
+
+func TestEventTimesWithVTimeZone(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		start time.Time
+		end   time.Time
+	}{
+		{
+			name:  "winter",
+			body:  vevent("UID:1", "DTSTART;TZID=W. Europe Standard Time:20260115T090000", "DTEND;TZID=W. Europe Standard Time:20260115T100000"),
+			start: time.Date(2026, 1, 15, 8, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 1, 15, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "summer",
+			body:  vevent("UID:1", "DTSTART;TZID=W. Europe Standard Time:20260715T090000", "DTEND;TZID=W. Europe Standard Time:20260715T100000"),
+			start: time.Date(2026, 7, 15, 7, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "eastern across the fall back",
+			body:  vevent("UID:1", "DTSTART;TZID=Eastern Standard Time:20261031T120000", "DTEND;TZID=Eastern Standard Time:20261102T120000"),
+			start: time.Date(2026, 10, 31, 16, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, 11, 2, 17, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Events(parseCalendar(t, windowsZone+easternZone+tt.body))
+			if err != nil {
+				t.Fatalf("Events failed: %v", err)
+			}
+			if !events[0].Start.Equal(tt.start) || !events[0].End.Equal(tt.end) {
+				t.Errorf("Expected %v - %v, got %v - %v", tt.start, tt.end, events[0].Start, events[0].End)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeZoneComponent builds a VTIMEZONE for a Go location covering the
+// transitions between from and to. Each transition gets its own observance
+// with an explicit DTSTART, which every client understands, rather than an
+// RRULE that would have to be reverse-engineered from the tz database.
+func TimeZoneComponent(loc *time.Location, from, to time.Time) *Component {
+	tz := NewComponent("VTIMEZONE")
+	tz.Add("TZID", loc.String())
+
+	transitions := zoneTransitions(loc, from, to)
+
+	_, offset := from.In(loc).Zone()
+	if len(transitions) == 0 {
+		// No transitions in range: a single observance with a fixed offset.
+		name, _ := from.In(loc).Zone()
+		tz.Children = append(tz.Children, observanceComponent("STANDARD", name, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), offset, offset))
+		return tz
+	}
+
+	// Open with the offset in force before the first transition.
+	first := transitions[0].Add(-time.Second).In(loc)
+	name, _ := first.Zone()
+	tz.Children = append(tz.Children, observanceComponent(kindOf(first), name,
+		time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), offset, offset))
+
+	for _, at := range transitions {
+		before := at.Add(-time.Second).In(loc)
+		after := at.In(loc)
+		_, offsetFrom := before.Zone()
+		name, offsetTo := after.Zone()
+
+		// DTSTART is the local time just before the transition.
+		local := at.Add(time.Duration(offsetFrom) * time.Second).UTC()
+		tz.Children = append(tz.Children, observanceComponent(kindOf(after), name, local, offsetFrom, offsetTo))
+	}
+
+	return tz
+}
+
+func kindOf(t time.Time) string {
+	if t.IsDST() {
+		return "DAYLIGHT"
+	}
+	return "STANDARD"
+}
+
+func observanceComponent(kind, name string, start time.Time, offsetFrom, offsetTo int) *Component {
+	obs := NewComponent(kind)
+	obs.Add("DTSTART", start.Format(dateTimeLayout))
+	obs.Add("TZOFFSETFROM", formatUTCOffset(offsetFrom))
+	obs.Add("TZOFFSETTO", formatUTCOffset(offsetTo))
+	if name != "" {
+		obs.Add("TZNAME", name)
+	}
+	return obs
+}
+
+func formatUTCOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if s != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, h, m, s)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, h, m)
+}
+
+// zoneTransitions finds the instants in [from, to) where the UTC offset or
+// zone abbreviation of loc changes, probing daily and bisecting to the
+// second.
+func zoneTransitions(loc *time.Location, from, to time.Time) []time.Time {
+	var transitions []time.Time
+
+	prev := from
+	prevName, prevOffset := prev.In(loc).Zone()
+
+	for t := from.Add(24 * time.Hour); ; t = t.Add(24 * time.Hour) {
+		if t.After(to) {
+			t = to
+		}
+
+		name, offset := t.In(loc).Zone()
+		if name != prevName || offset != prevOffset {
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if n, o := mid.In(loc).Zone(); n == prevName && o == prevOffset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			transitions = append(transitions, hi.Truncate(time.Second))
+		}
+
+		prev, prevName, prevOffset = t, name, offset
+		if !t.Before(to) {
+			break
+		}
+	}
+
+	return transitions
+}
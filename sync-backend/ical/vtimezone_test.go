@@ -0,0 +1,151 @@
+package ical
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTimeZoneComponent(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		zone        string
+		transitions []time.Time
+		offsets     [][2]string // TZOFFSETFROM and TZOFFSETTO per observance
+	}{
+		{
+			zone: "Europe/Berlin",
+			transitions: []time.Time{
+				time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+				time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC),
+			},
+			offsets: [][2]string{{"+0100", "+0100"}, {"+0100", "+0200"}, {"+0200", "+0100"}},
+		},
+		{
+			zone: "America/New_York",
+			transitions: []time.Time{
+				time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+				time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+			},
+			offsets: [][2]string{{"-0500", "-0500"}, {"-0500", "-0400"}, {"-0400", "-0500"}},
+		},
+		{
+			zone: "Asia/Kolkata",
+			// No DST: one fixed observance.
+			offsets: [][2]string{{"+0530", "+0530"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatalf("LoadLocation failed: %v", err)
+			}
+
+			got := zoneTransitions(loc, from, to)
+			assertTimes(t, got, tt.transitions)
+
+			comp := TimeZoneComponent(loc, from, to)
+			if comp.Value("TZID") != tt.zone {
+				t.Errorf("Expected TZID %s, got %s", tt.zone, comp.Value("TZID"))
+			}
+			if len(comp.Children) != len(tt.offsets) {
+				t.Fatalf("Expected %d observances, got %d", len(tt.offsets), len(comp.Children))
+			}
+			for i, obs := range comp.Children {
+				offsets := [2]string{obs.Value("TZOFFSETFROM"), obs.Value("TZOFFSETTO")}
+				if offsets != tt.offsets[i] {
+					t.Errorf("Expected offsets %v for observance %d, got %v", tt.offsets[i], i, offsets)
+				}
+			}
+
+			// Read the generated definition back, as a client without Go's
+			// zone database would, and compare it with the location hour by
+			// hour around each transition.
+			cal := NewCalendar("-//test//EN")
+			cal.Children = append(cal.Children, comp)
+			var buf bytes.Buffer
+			if err := Encode(&buf, cal); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			parsed, err := Parse(&buf)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			tz, ok := ParseTimeZones(parsed)[tt.zone]
+			if !ok {
+				t.Fatal("Expected the generated VTIMEZONE to parse")
+			}
+
+			probes := []time.Time{from.AddDate(0, 6, 0)}
+			for _, at := range tt.transitions {
+				for h := -3; h <= 3; h++ {
+					probes = append(probes, at.Add(time.Duration(h)*time.Hour))
+				}
+			}
+			for _, instant := range probes {
+				local := instant.In(loc)
+				wall := time.Date(local.Year(), local.Month(), local.Day(),
+					local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+				if isAmbiguous(loc, wall) {
+					continue
+				}
+				if got := tz.Convert(wall); !got.Equal(instant) {
+					t.Errorf("Expected %s to convert to %v, got %v", wall.Format(dateTimeLayout), instant, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatUTCOffset(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    string
+	}{
+		{0, "+0000"},
+		{3600, "+0100"},
+		{-4 * 3600, "-0400"},
+		{-(3*3600 + 30*60), "-0330"},
+		{5*3600 + 45*60, "+0545"},
+		{-(17*60 + 30), "-001730"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatUTCOffset(tt.seconds); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// isAmbiguous reports whether a wall clock time occurs twice in loc, as in
+// the hour repeated when clocks fall back. Either instant is a valid reading.
+func isAmbiguous(loc *time.Location, wall time.Time) bool {
+	first := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	_, offset := first.Zone()
+	for _, d := range []time.Duration{-time.Hour, time.Hour} {
+		other := first.Add(d)
+		_, otherOffset := other.Zone()
+		if otherOffset != offset && other.Add(time.Duration(otherOffset)*time.Second).UTC().Equal(wall) {
+			return true
+		}
+	}
+	return false
+}
+
+func assertTimes(t *testing.T, got, want []time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d times, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("Expected %v at %d, got %v", want[i], i, got[i])
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package ics implements read-only calendars subscribed to by URL and the
+// import of uploaded .ics files.
+package ics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/ical"
+	"calendar-backend/netguard"
+)
+
+const ProviderName = "ics"
+
+// maxFeedSize caps how much of a feed is read. Large public calendars are a
+// few megabytes at most.
+const maxFeedSize = 20 << 20
+
+// httpClient fetches feeds from URLs users gave, so it can't reach the
+// backend's own network, redirects included.
+var httpClient = &http.Client{Timeout: 60 * time.Second, Transport: netguard.Transport()}
+
+// NormalizeURL accepts http(s) and webcal(s) URLs and returns the URL to
+// fetch.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid URL %q", raw)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "webcal", "webcals":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	return u.String(), nil
+}
+
+type FetchResult struct {
+	NotModified  bool
+	Calendar     *ical.Component
+	ETag         string
+	LastModified string
+}
+
+// Fetch downloads a feed, sending the validators of the previous fetch so an
+// unchanged feed costs a 304.
+func Fetch(ctx context.Context, feedURL, etag, lastModified string) (*FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar, */*;q=0.5")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &FetchResult{NotModified: true, ETag: etag, LastModified: lastModified}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedSize)
+	}
+
+	cal, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	return &FetchResult{
+		Calendar:     cal,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// ToEvents converts the VEVENTs of a calendar to event rows. Modified
+// occurrences of a recurring event are kept as separate rows keyed by UID
+// and RECURRENCE-ID; the etag is a hash of the VEVENT, so unchanged events
+// are skipped when the snapshot is applied. Events that can't be parsed are
+// left out and returned as errors, so one bad entry in someone else's feed
+// doesn't hold back the rest of it.
+func ToEvents(cal *ical.Component) ([]database.Event, []error) {
+	zones := ical.ParseTimeZones(cal)
+
+	var events []database.Event
+	var skipped []error
+	for _, vevent := range cal.ChildrenNamed("VEVENT") {
+		parsed, err := zones.Event(vevent)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("event %q: %w", vevent.Value("UID"), err))
+			continue
+		}
+
+		event, err := eventRow(vevent, parsed)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("event %q: %w", vevent.Value("UID"), err))
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, skipped
+}
+
+func eventRow(vevent *ical.Component, parsed ical.Event) (database.Event, error) {
+	var raw bytes.Buffer
+	if err := ical.Encode(&raw, vevent); err != nil {
+		return database.Event{}, err
+	}
+	sum := sha256.Sum256(raw.Bytes())
+	etag := hex.EncodeToString(sum[:])
+
+	id := parsed.UID
+	if id == "" {
+		id = etag
+	}
+	if parsed.RecurrenceID != "" {
+		id += "@" + parsed.RecurrenceID
+	}
+
+	event := database.Event{
+		ProviderEventID: id,
+		Title:           parsed.Summary,
+		Description:     parsed.Description,
+		Location:        parsed.Location,
+		StartTime:       parsed.Start.Unix(),
+		EndTime:         parsed.End.Unix(),
+		IsAllDay:        parsed.AllDay,
+		Status:          parsed.Status,
+		Recurrence:      strings.Join(parsed.Recurrence, "\n"),
+		Etag:            etag,
+		RawData:         raw.String(),
+	}
+	if len(parsed.Attendees) > 0 {
+		if data, err := json.Marshal(parsed.Attendees); err == nil {
+			event.Attendees = string(data)
+		}
+	}
+
+	return event, nil
+}
+
+// Name returns the display name a feed advertises, if any.
+func Name(cal *ical.Component) string {
+	return ical.UnescapeText(cal.Value("X-WR-CALNAME"))
+}
+
+// Color returns the color a feed advertises as #RRGGBB, if any.
+func Color(cal *ical.Component) string {
+	color := cal.Value("X-APPLE-CALENDAR-COLOR")
+	if len(color) == 9 && strings.HasPrefix(color, "#") {
+		color = color[:7]
+	}
+	return color
+}
@@ -0,0 +1,260 @@
+package ics
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/ical"
+	"calendar-backend/provider"
+)
+
+// MaxImportSize caps uploaded .ics files. Exports of several years of a busy
+// calendar stay well below this.
+const MaxImportSize = 10 << 20
+
+// ImportItem is one importable VEVENT of an uploaded file.
+type ImportItem struct {
+	Index        int    `json:"index"`
+	UID          string `json:"uid"`
+	Title        string `json:"title"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	AllDay       bool   `json:"all_day"`
+	TimeZone     string `json:"time_zone,omitempty"`
+	Recurring    bool   `json:"recurring"`
+	RecurrenceID string `json:"recurrence_id,omitempty"`
+
+	vevent *ical.Component
+	event  ical.Event
+	// exdates are the occurrences of a recurring event that the file
+	// overrides or cancels with RECURRENCE-ID components; cancelled are
+	// those it only cancels.
+	exdates   []string
+	cancelled []string
+}
+
+// ImportError is a VEVENT that couldn't be read or written.
+type ImportError struct {
+	Index int    `json:"index"`
+	UID   string `json:"uid,omitempty"`
+	Title string `json:"title,omitempty"`
+	Error string `json:"error"`
+}
+
+// Import is a parsed .ics upload. Events that fail to parse are listed in
+// Skipped rather than failing the whole file.
+type Import struct {
+	Name      string
+	TimeZones []string
+	Items     []*ImportItem
+	Skipped   []ImportError
+}
+
+// ImportSummary describes what an import will write.
+type ImportSummary struct {
+	Name       string        `json:"name,omitempty"`
+	Events     int           `json:"events"`
+	Recurring  int           `json:"recurring"`
+	Overrides  int           `json:"overrides"`
+	AllDay     int           `json:"all_day"`
+	TimeZones  []string      `json:"time_zones"`
+	FirstStart string        `json:"first_start,omitempty"`
+	LastEnd    string        `json:"last_end,omitempty"`
+	Items      []*ImportItem `json:"items"`
+	Skipped    []ImportError `json:"skipped"`
+}
+
+// ReadImport parses an uploaded .ics file.
+func ReadImport(r io.Reader) (*Import, error) {
+	cal, err := ical.Parse(io.LimitReader(r, MaxImportSize))
+	if err != nil {
+		return nil, err
+	}
+
+	zones := ical.ParseTimeZones(cal)
+	imp := &Import{Name: Name(cal), Skipped: []ImportError{}}
+
+	// Overrides and cancellations of single occurrences, by UID. A recurring
+	// event written through a provider excludes them from its rule, and the
+	// overrides are written as events of their own.
+	exdates := make(map[string][]string)
+	cancelled := make(map[string][]string)
+	tzids := make(map[string]bool)
+
+	for i, vevent := range cal.ChildrenNamed("VEVENT") {
+		parsed, err := zones.Event(vevent)
+		if err != nil {
+			imp.Skipped = append(imp.Skipped, ImportError{
+				Index: i,
+				UID:   vevent.Value("UID"),
+				Title: ical.UnescapeText(vevent.Value("SUMMARY")),
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		if rid := vevent.Prop("RECURRENCE-ID"); rid != nil && parsed.UID != "" {
+			if exdate, err := exdateLine(zones, rid); err == nil {
+				exdates[parsed.UID] = append(exdates[parsed.UID], exdate)
+				if parsed.Status == "cancelled" {
+					cancelled[parsed.UID] = append(cancelled[parsed.UID], exdate)
+				}
+			}
+		}
+
+		if parsed.Status == "cancelled" {
+			// A cancelled occurrence only removes it from the series.
+			if parsed.RecurrenceID == "" {
+				imp.Skipped = append(imp.Skipped, ImportError{
+					Index: i,
+					UID:   parsed.UID,
+					Title: parsed.Summary,
+					Error: "event is cancelled",
+				})
+			}
+			continue
+		}
+
+		if tzid := vevent.Prop("DTSTART").Param("TZID"); tzid != "" {
+			tzids[tzid] = true
+		}
+
+		imp.Items = append(imp.Items, &ImportItem{
+			Index:        i,
+			UID:          parsed.UID,
+			Title:        parsed.Summary,
+			Start:        parsed.Start.UTC().Format(time.RFC3339),
+			End:          parsed.End.UTC().Format(time.RFC3339),
+			AllDay:       parsed.AllDay,
+			TimeZone:     parsed.TimeZone,
+			Recurring:    len(parsed.Recurrence) > 0,
+			RecurrenceID: parsed.RecurrenceID,
+			vevent:       vevent,
+			event:        parsed,
+		})
+	}
+
+	for _, item := range imp.Items {
+		if item.Recurring && item.RecurrenceID == "" {
+			item.exdates = exdates[item.UID]
+			item.cancelled = cancelled[item.UID]
+		}
+	}
+
+	for tzid := range tzids {
+		imp.TimeZones = append(imp.TimeZones, tzid)
+	}
+	sort.Strings(imp.TimeZones)
+
+	return imp, nil
+}
+
+// exdateLine turns a RECURRENCE-ID into the EXDATE line that removes the
+// occurrence from its series. Times are written in UTC so the line doesn't
+// depend on a VTIMEZONE the provider won't see.
+func exdateLine(zones ical.TimeZones, rid *ical.Property) (string, error) {
+	t, allDay, err := zones.Time(rid)
+	if err != nil {
+		return "", err
+	}
+	if allDay {
+		return "EXDATE;VALUE=DATE:" + t.UTC().Format("20060102"), nil
+	}
+	return "EXDATE:" + t.UTC().Format("20060102T150405Z"), nil
+}
+
+// Summary counts what the import will write.
+func (imp *Import) Summary() ImportSummary {
+	summary := ImportSummary{
+		Name:      imp.Name,
+		Events:    len(imp.Items),
+		TimeZones: imp.TimeZones,
+		Items:     imp.Items,
+		Skipped:   imp.Skipped,
+	}
+	if summary.TimeZones == nil {
+		summary.TimeZones = []string{}
+	}
+	if summary.Items == nil {
+		summary.Items = []*ImportItem{}
+	}
+
+	var first, last time.Time
+	for _, item := range imp.Items {
+		switch {
+		case item.RecurrenceID != "":
+			summary.Overrides++
+		case item.Recurring:
+			summary.Recurring++
+		}
+		if item.AllDay {
+			summary.AllDay++
+		}
+		if first.IsZero() || item.event.Start.Before(first) {
+			first = item.event.Start
+		}
+		if item.event.End.After(last) {
+			last = item.event.End
+		}
+	}
+	if !first.IsZero() {
+		summary.FirstStart = first.UTC().Format(time.RFC3339)
+		summary.LastEnd = last.UTC().Format(time.RFC3339)
+	}
+
+	return summary
+}
+
+// Row returns the item as it is stored in a calendar that keeps its events
+// locally. Overrides are separate rows, as for subscribed feeds, so
+// importing the same file again updates the events instead of duplicating
+// them. Cancelled occurrences become EXDATEs of the series.
+func (item *ImportItem) Row() (database.Event, error) {
+	if len(item.cancelled) == 0 {
+		return eventRow(item.vevent, item.event)
+	}
+
+	vevent := &ical.Component{
+		Name:     item.vevent.Name,
+		Props:    append([]*ical.Property{}, item.vevent.Props...),
+		Children: item.vevent.Children,
+	}
+	event := item.event
+	event.Recurrence = append([]string{}, event.Recurrence...)
+	for _, line := range item.cancelled {
+		value := line[strings.LastIndex(line, ":")+1:]
+		p := vevent.Add("EXDATE", value)
+		if strings.Contains(line, "VALUE=DATE") {
+			p.SetParam("VALUE", "DATE")
+		}
+		event.Recurrence = append(event.Recurrence, line)
+	}
+
+	return eventRow(vevent, event)
+}
+
+// ProviderEvent returns the item as it is written through a provider. An
+// override becomes a standalone event and its occurrence is excluded from
+// the series it came from.
+func (item *ImportItem) ProviderEvent() provider.CalendarEvent {
+	event := provider.CalendarEvent{
+		Title:       item.event.Summary,
+		Description: item.event.Description,
+		Location:    item.event.Location,
+		StartTime:   item.event.Start.Unix(),
+		EndTime:     item.event.End.Unix(),
+		IsAllDay:    item.event.AllDay,
+		TimeZone:    item.event.TimeZone,
+		Status:      item.event.Status,
+	}
+
+	if item.RecurrenceID == "" && item.Recurring {
+		lines := append(append([]string{}, item.event.Recurrence...), item.exdates...)
+		event.Recurrence = strings.Join(lines, "\n")
+	}
+
+	return event
+}
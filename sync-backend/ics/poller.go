@@ -0,0 +1,114 @@
+package ics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"calendar-backend/database"
+)
+
+// Poll fetches one subscribed calendar and applies the feed to its events.
+// The outcome, including failures, is recorded on the calendar row.
+func Poll(ctx context.Context, cal *database.Calendar) (*database.EventDiff, error) {
+	var etag, lastModified string
+	if cal.FeedETag != nil {
+		etag = *cal.FeedETag
+	}
+	if cal.FeedLastModified != nil {
+		lastModified = *cal.FeedLastModified
+	}
+
+	checkedAt := time.Now().Unix()
+
+	diff, result, err := poll(ctx, cal, etag, lastModified)
+	if err != nil {
+		message := err.Error()
+		if stateErr := database.UpdateCalendarFeedState(cal.ID, cal.FeedETag, cal.FeedLastModified, checkedAt, &message); stateErr != nil {
+			log.Printf("Failed to record feed error for calendar %s: %v", cal.ID, stateErr)
+		}
+		return nil, err
+	}
+
+	if err := database.UpdateCalendarFeedState(cal.ID, nullable(result.ETag), nullable(result.LastModified), checkedAt, nil); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func poll(ctx context.Context, cal *database.Calendar, etag, lastModified string) (*database.EventDiff, *FetchResult, error) {
+	result, err := Fetch(ctx, cal.ProviderCalendarID, etag, lastModified)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.NotModified {
+		return &database.EventDiff{}, result, nil
+	}
+
+	events, skipped := ToEvents(result.Calendar)
+	for _, err := range skipped {
+		log.Printf("Skipping event in feed for calendar %s: %v", cal.ID, err)
+	}
+
+	diff, err := database.ReplaceCalendarEvents(cal.ID, events)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return diff, result, nil
+}
+
+// RunPoller polls every subscribed calendar once per interval. It never
+// returns.
+func RunPoller(interval time.Duration) {
+	tick := time.Minute
+	if interval < tick {
+		tick = interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		pollDue(interval)
+		<-ticker.C
+	}
+}
+
+func pollDue(interval time.Duration) {
+	calendars, err := database.GetCalendarsByProvider(ProviderName)
+	if err != nil {
+		log.Printf("Failed to list subscribed calendars: %v", err)
+		return
+	}
+
+	due := time.Now().Add(-interval).Unix()
+
+	for i := range calendars {
+		cal := &calendars[i]
+		if cal.FeedCheckedAt != nil && *cal.FeedCheckedAt > due {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		diff, err := Poll(ctx, cal)
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to poll feed for calendar %s: %v", cal.ID, err)
+			continue
+		}
+		if diff.Added+diff.Updated+diff.Removed > 0 {
+			log.Printf("Feed for calendar %s: %d added, %d updated, %d removed",
+				cal.ID, diff.Added, diff.Updated, diff.Removed)
+		}
+	}
+}
+
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,187 @@
+// Package local implements calendars that exist only in this system. Their
+// events live in the events table, so the provider reads and writes the
+// database directly and there is nothing to sync or watch.
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/ical"
+	"calendar-backend/provider"
+)
+
+const ProviderName = "local"
+
+type CalendarService struct{}
+
+var _ provider.Provider = (*CalendarService)(nil)
+
+func NewCalendarService() *CalendarService {
+	return &CalendarService{}
+}
+
+// ListCalendars returns nothing: local calendars aren't behind an account and
+// are created in the app instead of being discovered.
+func (s *CalendarService) ListCalendars(ctx context.Context, creds provider.Credentials) ([]provider.AvailableCalendar, error) {
+	return nil, nil
+}
+
+// ListEvents always returns every event. Deleted events leave no trace, so
+// there is no sync token and callers resync in full.
+func (s *CalendarService) ListEvents(ctx context.Context, creds provider.Credentials, calendarID string, syncToken *string) (*provider.EventsResult, error) {
+	events, err := database.GetEventsByCalendarId(calendarID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &provider.EventsResult{
+		Events: make([]provider.CalendarEvent, 0, len(events)),
+	}
+	for _, ev := range events {
+		result.Events = append(result.Events, toCalendarEvent(ev))
+	}
+
+	return result, nil
+}
+
+func (s *CalendarService) Watch(ctx context.Context, creds provider.Credentials, calendarID string, req provider.WatchRequest) (*provider.Channel, error) {
+	return nil, provider.ErrNotSupported
+}
+
+func (s *CalendarService) CreateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	event.ProviderEventID = newEventID()
+	return s.store(calendarID, event)
+}
+
+func (s *CalendarService) UpdateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	existing, err := database.GetEventByProviderEventId(calendarID, event.ProviderEventID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("event %s not found", event.ProviderEventID)
+	}
+
+	return s.store(calendarID, event)
+}
+
+func (s *CalendarService) DeleteEvent(ctx context.Context, creds provider.Credentials, calendarID, eventID string) error {
+	return database.DeleteEventByProviderEventId(calendarID, eventID)
+}
+
+// RefreshCredentials has nothing to refresh.
+func (s *CalendarService) RefreshCredentials(ctx context.Context, creds provider.Credentials) (*provider.Credentials, error) {
+	return &creds, nil
+}
+
+// store writes the event with its iCalendar form as raw data, which keeps
+// the time zone for feeds, and returns it as stored.
+func (s *CalendarService) store(calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	if event.Status == "" {
+		event.Status = "confirmed"
+	}
+
+	var raw bytes.Buffer
+	if err := ical.Encode(&raw, toVEvent(event)); err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	sum := sha256.Sum256(raw.Bytes())
+
+	event.Etag = hex.EncodeToString(sum[:])
+	event.RawData = raw.String()
+
+	err := database.UpsertEvent(calendarID, database.Event{
+		ProviderEventID: event.ProviderEventID,
+		Title:           event.Title,
+		Description:     event.Description,
+		Location:        event.Location,
+		StartTime:       event.StartTime,
+		EndTime:         event.EndTime,
+		IsAllDay:        event.IsAllDay,
+		Status:          event.Status,
+		Recurrence:      event.Recurrence,
+		Attendees:       event.Attendees,
+		Etag:            event.Etag,
+		RawData:         event.RawData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func toVEvent(event provider.CalendarEvent) *ical.Component {
+	vevent := ical.Event{
+		UID:         event.ProviderEventID,
+		Summary:     event.Title,
+		Description: event.Description,
+		Location:    event.Location,
+		Status:      event.Status,
+		Start:       time.Unix(event.StartTime, 0),
+		End:         time.Unix(event.EndTime, 0),
+		AllDay:      event.IsAllDay,
+	}
+	if event.Recurrence != "" {
+		vevent.Recurrence = strings.Split(event.Recurrence, "\n")
+	}
+	if event.Attendees != "" {
+		json.Unmarshal([]byte(event.Attendees), &vevent.Attendees)
+	}
+
+	comp := ical.EventComponent(vevent)
+
+	if event.TimeZone != "" && !event.IsAllDay {
+		if loc, err := time.LoadLocation(event.TimeZone); err == nil && loc != time.UTC {
+			for name, t := range map[string]time.Time{"DTSTART": vevent.Start, "DTEND": vevent.End} {
+				p := comp.Prop(name)
+				p.Value = t.In(loc).Format("20060102T150405")
+				p.SetParam("TZID", event.TimeZone)
+			}
+		}
+	}
+
+	return comp
+}
+
+func toCalendarEvent(ev database.Event) provider.CalendarEvent {
+	event := provider.CalendarEvent{
+		ProviderEventID: ev.ProviderEventID,
+		Title:           ev.Title,
+		Description:     ev.Description,
+		Location:        ev.Location,
+		StartTime:       ev.StartTime,
+		EndTime:         ev.EndTime,
+		IsAllDay:        ev.IsAllDay,
+		Status:          ev.Status,
+		Recurrence:      ev.Recurrence,
+		Attendees:       ev.Attendees,
+		Etag:            ev.Etag,
+		RawData:         ev.RawData,
+	}
+
+	if cal, err := ical.Parse(strings.NewReader("BEGIN:VCALENDAR\r\n" + ev.RawData + "END:VCALENDAR\r\n")); err == nil {
+		for _, vevent := range cal.ChildrenNamed("VEVENT") {
+			if start := vevent.Prop("DTSTART"); start != nil {
+				event.TimeZone = start.Param("TZID")
+			}
+		}
+	}
+
+	return event
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
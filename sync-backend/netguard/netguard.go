@@ -0,0 +1,77 @@
+// Package netguard builds HTTP transports for URLs users supply, such as
+// feed subscriptions and CalDAV servers, that refuse to connect to the
+// backend's own network: loopback, private, link-local and unspecified
+// addresses. The check runs on the resolved address of every connection, so
+// neither DNS names nor redirects get around it.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a connection would go to an address
+// users may not reach through the backend.
+var ErrForbiddenAddress = errors.New("address is not allowed")
+
+var allowPrivate atomic.Bool
+
+// AllowPrivate lets connections go to private and loopback addresses, for
+// installs whose CalDAV server or feeds are on the local network.
+func AllowPrivate(allow bool) {
+	allowPrivate.Store(allow)
+}
+
+// sharedAddressSpace is carrier-grade NAT space, which some clouds use for
+// their metadata services.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Allowed reports whether connections to ip are permitted.
+func Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	if allowPrivate.Load() {
+		return true
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !Allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+// Transport returns a transport that only connects to allowed addresses.
+// Proxies from the environment aren't used, since the check would then only
+// see the proxy's address.
+func Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
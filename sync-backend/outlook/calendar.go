@@ -0,0 +1,295 @@
+package outlook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"calendar-backend/provider"
+	"calendar-backend/tokensource"
+)
+
+const ProviderName = "outlook"
+
+// Graph caps event subscriptions at 4230 minutes.
+const subscriptionTTL = 70 * time.Hour
+
+// deltaWindow bounds the calendarView delta query, which requires a start
+// and end date. Events outside the window are not synced.
+const (
+	deltaWindowPast   = 365 * 24 * time.Hour
+	deltaWindowFuture = 2 * 365 * 24 * time.Hour
+)
+
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	GraphBaseURL string
+}
+
+type CalendarService struct {
+	cfg Config
+}
+
+var _ provider.Provider = (*CalendarService)(nil)
+
+func NewCalendarService(cfg Config) *CalendarService {
+	return &CalendarService{cfg: cfg}
+}
+
+func (s *CalendarService) getOAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  s.cfg.AuthURL,
+			TokenURL: s.cfg.TokenURL,
+		},
+		Scopes: []string{"offline_access", "User.Read", "Calendars.ReadWrite"},
+	}
+}
+
+func (s *CalendarService) getClient(ctx context.Context, creds provider.Credentials) *http.Client {
+	client := oauth2.NewClient(ctx, tokensource.New(ctx, s.getOAuthConfig(), creds))
+	client.Timeout = 30 * time.Second
+	return client
+}
+
+// AuthCodeURL starts the connect flow for an Outlook account. A login hint
+// preselects the account, as when reconnecting one.
+func (s *CalendarService) AuthCodeURL(state, loginHint string) string {
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("prompt", "select_account")}
+	if loginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+	return s.getOAuthConfig().AuthCodeURL(state, opts...)
+}
+
+// Exchange trades the authorization code from the connect callback for
+// credentials.
+func (s *CalendarService) Exchange(ctx context.Context, code string) (*provider.Credentials, error) {
+	token, err := s.getOAuthConfig().Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	creds := &provider.Credentials{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	}
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		creds.Expiry = &expiry
+	}
+
+	return creds, nil
+}
+
+func (s *CalendarService) GetProfile(ctx context.Context, creds provider.Credentials) (*Profile, error) {
+	var profile Profile
+	if err := s.do(ctx, s.getClient(ctx, creds), http.MethodGet, "/me", nil, &profile); err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	return &profile, nil
+}
+
+func (s *CalendarService) ListCalendars(ctx context.Context, creds provider.Credentials) ([]provider.AvailableCalendar, error) {
+	client := s.getClient(ctx, creds)
+
+	var calendars []provider.AvailableCalendar
+	next := "/me/calendars"
+	for next != "" {
+		var page graphPage[graphCalendar]
+		if err := s.do(ctx, client, http.MethodGet, next, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to list calendars: %w", err)
+		}
+
+		for _, item := range page.Value {
+			cal := provider.AvailableCalendar{
+				ProviderCalendarID: item.ID,
+				Name:               item.Name,
+				IsPrimary:          item.IsDefaultCalendar,
+			}
+			if item.HexColor != "" {
+				color := item.HexColor
+				cal.Color = &color
+			}
+			calendars = append(calendars, cal)
+		}
+		next = page.NextLink
+	}
+
+	return calendars, nil
+}
+
+// ListEvents runs a calendarView delta query. The sync token is the deltaLink
+// Graph returned at the end of the previous run.
+func (s *CalendarService) ListEvents(ctx context.Context, creds provider.Credentials, calendarID string, syncToken *string) (*provider.EventsResult, error) {
+	client := s.getClient(ctx, creds)
+
+	start := func() string {
+		now := time.Now().UTC()
+		query := url.Values{}
+		query.Set("startDateTime", now.Add(-deltaWindowPast).Format(time.RFC3339))
+		query.Set("endDateTime", now.Add(deltaWindowFuture).Format(time.RFC3339))
+		return "/me/calendars/" + url.PathEscape(calendarID) + "/calendarView/delta?" + query.Encode()
+	}
+
+	result := &provider.EventsResult{
+		Events: []provider.CalendarEvent{},
+	}
+	next := ""
+	if syncToken != nil && *syncToken != "" {
+		next = *syncToken
+	} else {
+		next = start()
+		result.Full = true
+	}
+
+	for next != "" {
+		var page graphPage[json.RawMessage]
+		if err := s.do(ctx, client, http.MethodGet, next, nil, &page); err != nil {
+			// Graph answers an expired delta link with 410; start over.
+			var graphErr *GraphError
+			if !result.Full && errors.As(err, &graphErr) && graphErr.StatusCode == http.StatusGone {
+				result = &provider.EventsResult{Events: []provider.CalendarEvent{}, Full: true}
+				next = start()
+				continue
+			}
+			return nil, fmt.Errorf("failed to fetch events: %w", err)
+		}
+
+		for _, raw := range page.Value {
+			var event graphEvent
+			if err := json.Unmarshal(raw, &event); err != nil {
+				return nil, fmt.Errorf("failed to decode event: %w", err)
+			}
+			calEvent := convertEvent(&event)
+			calEvent.RawData = string(raw)
+			result.Events = append(result.Events, calEvent)
+		}
+
+		if page.DeltaLink != "" {
+			result.NextSyncToken = page.DeltaLink
+		}
+		next = page.NextLink
+	}
+
+	return result, nil
+}
+
+func (s *CalendarService) Watch(ctx context.Context, creds provider.Credentials, calendarID string, req provider.WatchRequest) (*provider.Channel, error) {
+	sub := graphSubscription{
+		ChangeType:         "created,updated,deleted",
+		NotificationURL:    req.Address,
+		Resource:           "me/calendars/" + calendarID + "/events",
+		ExpirationDateTime: time.Now().Add(subscriptionTTL).UTC().Format(time.RFC3339),
+		ClientState:        req.Token,
+	}
+
+	var created graphSubscription
+	if err := s.do(ctx, s.getClient(ctx, creds), http.MethodPost, "/subscriptions", sub, &created); err != nil {
+		return nil, fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, created.ExpirationDateTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription expiry %q: %w", created.ExpirationDateTime, err)
+	}
+
+	return &provider.Channel{
+		ID:         created.ID,
+		ResourceID: created.Resource,
+		Expiry:     expiry.Unix(),
+	}, nil
+}
+
+func (s *CalendarService) CreateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	var created graphEvent
+	path := "/me/calendars/" + url.PathEscape(calendarID) + "/events"
+	if err := s.do(ctx, s.getClient(ctx, creds), http.MethodPost, path, toGraphEvent(event), &created); err != nil {
+		return nil, fmt.Errorf("failed to create event: %w", err)
+	}
+
+	result := convertEvent(&created)
+	return &result, nil
+}
+
+func (s *CalendarService) UpdateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	var updated graphEvent
+	path := "/me/events/" + url.PathEscape(event.ProviderEventID)
+	if err := s.do(ctx, s.getClient(ctx, creds), http.MethodPatch, path, toGraphEvent(event), &updated); err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+
+	result := convertEvent(&updated)
+	return &result, nil
+}
+
+func (s *CalendarService) DeleteEvent(ctx context.Context, creds provider.Credentials, calendarID, eventID string) error {
+	path := "/me/events/" + url.PathEscape(eventID)
+	if err := s.do(ctx, s.getClient(ctx, creds), http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	return nil
+}
+
+func (s *CalendarService) RefreshCredentials(ctx context.Context, creds provider.Credentials) (*provider.Credentials, error) {
+	return tokensource.Refresh(ctx, s.getOAuthConfig(), creds)
+}
+
+func convertEvent(event *graphEvent) provider.CalendarEvent {
+	calEvent := provider.CalendarEvent{
+		ProviderEventID: event.ID,
+		Title:           event.Subject,
+		StartTime:       parseGraphTime(event.Start),
+		EndTime:         parseGraphTime(event.End),
+		IsAllDay:        event.IsAllDay,
+		Status:          "confirmed",
+		Etag:            event.ETag,
+	}
+
+	if event.Removed != nil || event.IsCancelled {
+		calEvent.Status = "cancelled"
+	}
+	if event.Body != nil {
+		calEvent.Description = event.Body.Content
+	}
+	if event.Location != nil {
+		calEvent.Location = event.Location.DisplayName
+	}
+
+	if len(event.Attendees) > 0 {
+		emails := make([]string, 0, len(event.Attendees))
+		for _, attendee := range event.Attendees {
+			emails = append(emails, attendee.EmailAddress.Address)
+		}
+		if data, err := json.Marshal(emails); err == nil {
+			calEvent.Attendees = string(data)
+		}
+	}
+
+	return calEvent
+}
+
+func toGraphEvent(event provider.CalendarEvent) graphEvent {
+	gEvent := graphEvent{
+		Subject:  event.Title,
+		Body:     &graphBody{ContentType: "text", Content: event.Description},
+		Location: &graphLocation{DisplayName: event.Location},
+		Start:    formatGraphTime(event.StartTime),
+		End:      formatGraphTime(event.EndTime),
+		IsAllDay: event.IsAllDay,
+	}
+	return gEvent
+}
@@ -0,0 +1,204 @@
+package outlook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"calendar-backend/provider"
+)
+
+// graphServer fakes Graph with handler, recording the requests it gets.
+type graphServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newGraphServer(t *testing.T, handler func(srv *graphServer, w http.ResponseWriter, r *http.Request)) *graphServer {
+	t.Helper()
+	srv := &graphServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r)
+		srv.mu.Unlock()
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Expected the access token to be sent, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		handler(srv, w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *graphServer) service() *CalendarService {
+	return NewCalendarService(Config{GraphBaseURL: srv.URL})
+}
+
+func testCredentials() provider.Credentials {
+	expiry := time.Now().Add(time.Hour)
+	return provider.Credentials{AccessToken: "access", RefreshToken: "refresh", Expiry: &expiry}
+}
+
+const eventJSON = `{"id":"%s","@odata.etag":"W/\"1\"","subject":"%s",` +
+	`"start":{"dateTime":"2026-03-01T09:00:00.0000000","timeZone":"UTC"},` +
+	`"end":{"dateTime":"2026-03-01T10:00:00.0000000","timeZone":"UTC"}}`
+
+func TestListEventsFollowsPages(t *testing.T) {
+	srv := newGraphServer(t, func(srv *graphServer, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprintf(w, `{"value":[`+eventJSON+`],"@odata.nextLink":"%s/delta?page=2"}`, "a", "First", srv.URL)
+		case "2":
+			fmt.Fprintf(w, `{"value":[`+eventJSON+`,{"id":"gone","@removed":{"reason":"deleted"}}],"@odata.deltaLink":"%s/delta?token=next"}`,
+				"b", "Second", srv.URL)
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+	})
+
+	result, err := srv.service().ListEvents(context.Background(), testCredentials(), "cal/1", nil)
+	if err != nil {
+		t.Fatalf("ListEvents failed: %v", err)
+	}
+
+	if !result.Full {
+		t.Error("Expected a full sync without a sync token")
+	}
+	if result.NextSyncToken != srv.URL+"/delta?token=next" {
+		t.Errorf("Expected the deltaLink as sync token, got %q", result.NextSyncToken)
+	}
+	if len(result.Events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(result.Events))
+	}
+	if result.Events[0].ProviderEventID != "a" || result.Events[1].Title != "Second" {
+		t.Errorf("Expected the events of both pages in order, got %+v", result.Events)
+	}
+	if want := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC).Unix(); result.Events[0].StartTime != want {
+		t.Errorf("Expected start %d, got %d", want, result.Events[0].StartTime)
+	}
+	if result.Events[2].ProviderEventID != "gone" || result.Events[2].Status != "cancelled" {
+		t.Errorf("Expected the removed event to be cancelled, got %+v", result.Events[2])
+	}
+
+	first := srv.requests[0]
+	if first.URL.EscapedPath() != "/me/calendars/cal%2F1/calendarView/delta" {
+		t.Errorf("Unexpected delta path %s", first.URL.EscapedPath())
+	}
+	if first.URL.Query().Get("startDateTime") == "" || first.URL.Query().Get("endDateTime") == "" {
+		t.Errorf("Expected the delta window in the query, got %s", first.URL.RawQuery)
+	}
+	if got := first.Header.Get("Prefer"); got != `outlook.timezone="UTC"` {
+		t.Errorf("Expected UTC times to be requested, got %q", got)
+	}
+}
+
+func TestListEventsWithSyncToken(t *testing.T) {
+	srv := newGraphServer(t, func(srv *graphServer, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "prev" {
+			t.Errorf("Expected the sync token to be followed, got %s", r.URL)
+		}
+		fmt.Fprintf(w, `{"value":[`+eventJSON+`],"@odata.deltaLink":"%s/delta?token=next"}`, "a", "Changed", srv.URL)
+	})
+
+	token := srv.URL + "/delta?token=prev"
+	result, err := srv.service().ListEvents(context.Background(), testCredentials(), "cal", &token)
+	if err != nil {
+		t.Fatalf("ListEvents failed: %v", err)
+	}
+	if result.Full {
+		t.Error("Expected an incremental sync")
+	}
+	if len(result.Events) != 1 || result.NextSyncToken != srv.URL+"/delta?token=next" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestListEventsRestartsExpiredDeltaLink(t *testing.T) {
+	srv := newGraphServer(t, func(srv *graphServer, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") == "expired" {
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprint(w, `{"error":{"code":"SyncStateNotFound","message":"The sync state is gone."}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"value":[`+eventJSON+`],"@odata.deltaLink":"%s/delta?token=fresh"}`, "a", "Again", srv.URL)
+	})
+
+	token := srv.URL + "/delta?token=expired"
+	result, err := srv.service().ListEvents(context.Background(), testCredentials(), "cal", &token)
+	if err != nil {
+		t.Fatalf("ListEvents failed: %v", err)
+	}
+	if !result.Full {
+		t.Error("Expected the restarted sync to be full")
+	}
+	if len(result.Events) != 1 || result.NextSyncToken != srv.URL+"/delta?token=fresh" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(srv.requests) != 2 || !strings.HasSuffix(srv.requests[1].URL.Path, "/calendarView/delta") {
+		t.Errorf("Expected one retry with a new delta query, got %d requests", len(srv.requests))
+	}
+}
+
+func TestListEventsGoneOnFullSync(t *testing.T) {
+	srv := newGraphServer(t, func(srv *graphServer, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, `{"error":{"code":"Gone","message":"gone"}}`)
+	})
+
+	_, err := srv.service().ListEvents(context.Background(), testCredentials(), "cal", nil)
+	var graphErr *GraphError
+	if !errors.As(err, &graphErr) || graphErr.StatusCode != http.StatusGone {
+		t.Fatalf("Expected the 410 to be returned, got %v", err)
+	}
+	if len(srv.requests) != 1 {
+		t.Errorf("Expected no retry of a full sync, got %d requests", len(srv.requests))
+	}
+}
+
+func TestGraphErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		rateLimit  time.Duration
+	}{
+		{"throttled", http.StatusTooManyRequests, "7", 7 * time.Second},
+		{"unavailable with retry-after", http.StatusServiceUnavailable, "3", 3 * time.Second},
+		{"unavailable", http.StatusServiceUnavailable, "", 0},
+		{"not found", http.StatusNotFound, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newGraphServer(t, func(srv *graphServer, w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"error":{"code":"Code","message":"message"}}`)
+			})
+
+			err := srv.service().DeleteEvent(context.Background(), testCredentials(), "cal", "event")
+
+			var rateErr *provider.RateLimitError
+			if isRateLimit := errors.As(err, &rateErr); isRateLimit != (tt.rateLimit > 0) {
+				t.Fatalf("Expected rate limit %v, got %v", tt.rateLimit > 0, err)
+			}
+			if rateErr != nil && rateErr.RetryAfter != tt.rateLimit {
+				t.Errorf("Expected to retry after %v, got %v", tt.rateLimit, rateErr.RetryAfter)
+			}
+			var graphErr *GraphError
+			if !errors.As(err, &graphErr) || graphErr.StatusCode != tt.status || graphErr.Code != "Code" {
+				t.Errorf("Expected a GraphError with status %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
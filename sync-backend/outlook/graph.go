@@ -0,0 +1,191 @@
+package outlook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"calendar-backend/provider"
+	"shared/ratelimit"
+)
+
+// GraphError is a non-2xx response from Microsoft Graph.
+type GraphError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("graph returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphEmailAddress struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+}
+
+type graphEvent struct {
+	ID          string         `json:"id,omitempty"`
+	ETag        string         `json:"@odata.etag,omitempty"`
+	Removed     *struct{}      `json:"@removed,omitempty"`
+	Subject     string         `json:"subject,omitempty"`
+	Body        *graphBody     `json:"body,omitempty"`
+	Location    *graphLocation `json:"location,omitempty"`
+	Start       *graphDateTime `json:"start,omitempty"`
+	End         *graphDateTime `json:"end,omitempty"`
+	IsAllDay    bool           `json:"isAllDay"`
+	IsCancelled bool           `json:"isCancelled,omitempty"`
+	Attendees   []struct {
+		EmailAddress graphEmailAddress `json:"emailAddress"`
+	} `json:"attendees,omitempty"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphCalendar struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	HexColor          string `json:"hexColor"`
+	IsDefaultCalendar bool   `json:"isDefaultCalendar"`
+}
+
+type graphPage[T any] struct {
+	Value     []T    `json:"value"`
+	NextLink  string `json:"@odata.nextLink"`
+	DeltaLink string `json:"@odata.deltaLink"`
+}
+
+type graphSubscription struct {
+	ID                 string `json:"id,omitempty"`
+	ChangeType         string `json:"changeType,omitempty"`
+	NotificationURL    string `json:"notificationUrl,omitempty"`
+	Resource           string `json:"resource,omitempty"`
+	ExpirationDateTime string `json:"expirationDateTime"`
+	ClientState        string `json:"clientState,omitempty"`
+}
+
+// Profile is the signed-in Graph user.
+type Profile struct {
+	ID                string `json:"id"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+// Email returns the user's mail address, falling back to the UPN for
+// accounts without a mailbox address.
+func (p *Profile) Email() string {
+	if p.Mail != "" {
+		return p.Mail
+	}
+	return p.UserPrincipalName
+}
+
+// do sends a Graph request. target is either a path relative to the Graph
+// base URL or an absolute nextLink/deltaLink returned by Graph.
+func (s *CalendarService) do(ctx context.Context, client *http.Client, method, target string, payload, out any) error {
+	url := target
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		url = s.cfg.GraphBaseURL + target
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Ask Graph to report all times in UTC so they can be parsed without
+	// resolving Windows time zone names.
+	req.Header.Set("Prefer", `outlook.timezone="UTC"`)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errBody struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		json.Unmarshal(data, &errBody)
+		graphErr := &GraphError{
+			StatusCode: resp.StatusCode,
+			Code:       errBody.Error.Code,
+			Message:    errBody.Error.Message,
+		}
+		// Graph throttles with 429, and sometimes 503, plus Retry-After.
+		if resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "" {
+			return &provider.RateLimitError{RetryAfter: ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After")), Err: graphErr}
+		}
+		return graphErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// graphTimeLayout matches the zone-less timestamps Graph returns, such as
+// "2024-05-01T09:30:00.0000000".
+const graphTimeLayout = "2006-01-02T15:04:05.9999999"
+
+func parseGraphTime(t *graphDateTime) int64 {
+	if t == nil || t.DateTime == "" {
+		return 0
+	}
+
+	loc := time.UTC
+	if t.TimeZone != "" && t.TimeZone != "UTC" {
+		if l, err := time.LoadLocation(t.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	parsed, err := time.ParseInLocation(graphTimeLayout, t.DateTime, loc)
+	if err != nil {
+		return 0
+	}
+	return parsed.Unix()
+}
+
+func formatGraphTime(unix int64) *graphDateTime {
+	return &graphDateTime{
+		DateTime: time.Unix(unix, 0).UTC().Format("2006-01-02T15:04:05"),
+		TimeZone: "UTC",
+	}
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"shared/oauthtoken"
+	"shared/ratelimit"
+)
+
+// ErrNotSupported is returned by providers for operations their calendars
+// can't perform, such as watching a read-only feed.
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+// RateLimitError is returned by providers when the service throttled the
+// call. RetryAfter is how long it asked to wait, or zero if it didn't say.
+type RateLimitError = ratelimit.RateLimitError
+
+// ReauthError is returned when the provider no longer accepts the account's
+// grant, for example because the user revoked access. Retrying won't help;
+// the user has to consent again.
+type ReauthError = oauthtoken.ReauthError
+
+// Credentials are what a provider needs to act on behalf of a connected
+// account. Password-based providers such as CalDAV use ServerURL and
+// Username, with the app password in AccessToken.
+type Credentials struct {
+	AccountID    string
+	AccessToken  string
+	RefreshToken string
+	Expiry       *time.Time
+	ServerURL    string
+	Username     string
+}
+
+type AvailableCalendar struct {
+	ProviderCalendarID string
+	Name               string
+	Color              *string
+	IsPrimary          bool
+}
+
+// CalendarEvent is a provider-neutral event. StartTime and EndTime are Unix
+// seconds; Recurrence holds RRULE/EXDATE lines separated by newlines.
+// TimeZone is the IANA zone recurrences expand in; it may be empty for
+// single events.
+type CalendarEvent struct {
+	ProviderEventID string
+	Title           string
+	Description     string
+	Location        string
+	StartTime       int64
+	EndTime         int64
+	IsAllDay        bool
+	TimeZone        string
+	Status          string
+	Recurrence      string
+	Attendees       string
+	Etag            string
+	RawData         string
+}
+
+// EventsResult is one page of changes. Passing NextSyncToken to the next
+// ListEvents call returns only what changed since. Full is set when Events
+// is the whole calendar rather than changes, as on a first sync or after the
+// provider expired the sync token, so stored events missing from it were
+// deleted.
+type EventsResult struct {
+	Events        []CalendarEvent
+	NextSyncToken string
+	Full          bool
+}
+
+type WatchRequest struct {
+	ChannelID string
+	Address   string
+	Token     string
+}
+
+// Channel is a registered push channel. Expiry is in Unix seconds.
+type Channel struct {
+	ID         string
+	ResourceID string
+	Expiry     int64
+}
+
+// Provider is implemented by every calendar backend (Google, Outlook, ...).
+// Handlers only talk to providers through this interface.
+type Provider interface {
+	ListCalendars(ctx context.Context, creds Credentials) ([]AvailableCalendar, error)
+	// ListEvents returns all events of the calendar, or only the changes
+	// since syncToken when one is given. Cancelled events are included.
+	ListEvents(ctx context.Context, creds Credentials, calendarID string, syncToken *string) (*EventsResult, error)
+	Watch(ctx context.Context, creds Credentials, calendarID string, req WatchRequest) (*Channel, error)
+	CreateEvent(ctx context.Context, creds Credentials, calendarID string, event CalendarEvent) (*CalendarEvent, error)
+	UpdateEvent(ctx context.Context, creds Credentials, calendarID string, event CalendarEvent) (*CalendarEvent, error)
+	DeleteEvent(ctx context.Context, creds Credentials, calendarID, eventID string) error
+	RefreshCredentials(ctx context.Context, creds Credentials) (*Credentials, error)
+}
+
+// BusyBlock is a span of time a calendar is busy, in Unix seconds.
+type BusyBlock struct {
+	Start int64
+	End   int64
+}
+
+// FreeBusyQuerier is implemented by providers that can report when a
+// calendar is busy without its events being readable, as for calendars
+// shared free/busy only.
+type FreeBusyQuerier interface {
+	QueryFreeBusy(ctx context.Context, creds Credentials, calendarID string, from, to time.Time) ([]BusyBlock, error)
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	mu        sync.RWMutex
+	providers = make(map[string]Provider)
+)
+
+// Register makes a provider available under the name stored in the
+// provider columns of calendars and connected_accounts.
+func Register(name string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[name] = p
+}
+
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not configured", name)
+	}
+	return p, nil
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Unwrap returns the provider a wrapper such as the rate limiter was built
+// around, for callers that need provider-specific methods.
+func Unwrap(p Provider) Provider {
+	for {
+		wrapper, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return p
+		}
+		p = wrapper.Unwrap()
+	}
+}
@@ -0,0 +1,112 @@
+// Package ratelimit paces the calls of registered providers per connected
+// account with the limiters of shared/ratelimit.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"calendar-backend/provider"
+	sharedlimit "shared/ratelimit"
+)
+
+// Config sets the pace of one provider's calls for each account.
+type Config = sharedlimit.Config
+
+// limitedProvider runs every call of a provider through a Limiter, keyed by
+// the connected account in the credentials. Calls made before an account is
+// saved, such as listing calendars while connecting, share one bucket.
+type limitedProvider struct {
+	provider.Provider
+	limiter *sharedlimit.Limiter
+}
+
+// limitedFreeBusy is a limitedProvider for providers that can also query
+// free/busy, so wrapping them doesn't hide that.
+type limitedFreeBusy struct {
+	*limitedProvider
+	querier provider.FreeBusyQuerier
+}
+
+// Wrap returns p with its calls paced per account by cfg. The usage is
+// recorded under name.
+func Wrap(name string, p provider.Provider, cfg Config) provider.Provider {
+	lp := &limitedProvider{Provider: p, limiter: sharedlimit.NewLimiter(name, cfg)}
+	if querier, ok := p.(provider.FreeBusyQuerier); ok {
+		return &limitedFreeBusy{limitedProvider: lp, querier: querier}
+	}
+	return lp
+}
+
+func (p *limitedProvider) Unwrap() provider.Provider {
+	return p.Provider
+}
+
+func (p *limitedProvider) ListCalendars(ctx context.Context, creds provider.Credentials) ([]provider.AvailableCalendar, error) {
+	var result []provider.AvailableCalendar
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.ListCalendars(ctx, creds)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedProvider) ListEvents(ctx context.Context, creds provider.Credentials, calendarID string, syncToken *string) (*provider.EventsResult, error) {
+	var result *provider.EventsResult
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.ListEvents(ctx, creds, calendarID, syncToken)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedProvider) Watch(ctx context.Context, creds provider.Credentials, calendarID string, req provider.WatchRequest) (*provider.Channel, error) {
+	var result *provider.Channel
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.Watch(ctx, creds, calendarID, req)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedProvider) CreateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	var result *provider.CalendarEvent
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.CreateEvent(ctx, creds, calendarID, event)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedProvider) UpdateEvent(ctx context.Context, creds provider.Credentials, calendarID string, event provider.CalendarEvent) (*provider.CalendarEvent, error) {
+	var result *provider.CalendarEvent
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.UpdateEvent(ctx, creds, calendarID, event)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedProvider) DeleteEvent(ctx context.Context, creds provider.Credentials, calendarID, eventID string) error {
+	return p.limiter.Do(ctx, creds.AccountID, func() error {
+		return p.Provider.DeleteEvent(ctx, creds, calendarID, eventID)
+	})
+}
+
+func (p *limitedProvider) RefreshCredentials(ctx context.Context, creds provider.Credentials) (*provider.Credentials, error) {
+	var result *provider.Credentials
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.Provider.RefreshCredentials(ctx, creds)
+		return err
+	})
+	return result, err
+}
+
+func (p *limitedFreeBusy) QueryFreeBusy(ctx context.Context, creds provider.Credentials, calendarID string, from, to time.Time) ([]provider.BusyBlock, error) {
+	var result []provider.BusyBlock
+	err := p.limiter.Do(ctx, creds.AccountID, func() (err error) {
+		result, err = p.querier.QueryFreeBusy(ctx, creds, calendarID, from, to)
+		return err
+	})
+	return result, err
+}
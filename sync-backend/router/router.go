@@ -9,7 +9,9 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"calendar-backend/config"
+	"calendar-backend/davserver"
 	"calendar-backend/handler"
+	"shared/logger"
 	"shared/middleware"
 )
 
@@ -31,12 +33,20 @@ func SetupRouter() *gin.Engine {
 
 	distDir := cfg.FrontendDir
 
-	r.POST("/event/:eventId", handler.HandleEvent)
+	// Changes forwarded by the watcher
+	if cfg.WatcherSecret != "" {
+		watcher := r.Group("/", handler.RequireWatcherSecret())
+		watcher.POST("/event/:eventId", handler.HandleEvent)
+		watcher.POST("/calendar/:calendarId/events", handler.HandleCalendarEvents)
+		watcher.POST("/calendar/:calendarId/sync", handler.HandleCalendarSync)
+	} else {
+		logger.Warn.Printf("Watcher routes disabled - WATCHER_BACKEND_SECRET is not set")
+	}
+
 	r.Static("/home/assets", filepath.Join(distDir, "assets"))
 	r.StaticFile("/home/favicon.ico", filepath.Join(distDir, "favicon.ico"))
 
 	r.GET("/home", serveIndex(distDir))
-	r.GET("/auth/callback", handler.HandleAuthCallback)
 
 	// API routes
 	r.GET("/api/tokens", handler.HandleTokens)
@@ -45,11 +55,48 @@ func SetupRouter() *gin.Engine {
 	r.GET("/api/connected-accounts", handler.HandleGetConnectedAccounts)
 	r.GET("/api/connected-accounts/:id/calendars", handler.HandleGetAvailableCalendars)
 	r.DELETE("/api/connected-accounts/:id", handler.HandleDeleteConnectedAccount)
+	r.GET("/api/connect/outlook", handler.HandleConnectOutlook)
+	r.GET("/api/connect/outlook/callback", handler.HandleConnectOutlookCallback)
+	r.POST("/api/connect/caldav", handler.HandleConnectCalDAV)
 
 	// Calendars
 	r.GET("/api/calendars", handler.HandleGetCalendars)
 	r.POST("/api/calendars", handler.HandleAddCalendar)
+	r.POST("/api/calendars/subscribe", handler.HandleSubscribeCalendar)
+	r.POST("/api/calendars/local", handler.HandleCreateLocalCalendar)
+	r.POST("/api/calendars/freebusy", handler.HandleAddFreeBusyCalendar)
+	r.PUT("/api/calendars/:id", handler.HandleUpdateCalendar)
 	r.DELETE("/api/calendars/:id", handler.HandleDeleteCalendar)
+	r.POST("/api/calendars/:id/import", handler.HandleImportCalendar)
+
+	// Events
+	r.GET("/api/calendars/:id/events", handler.HandleGetEvents)
+	r.POST("/api/calendars/:id/events", handler.HandleCreateEvent)
+	r.PUT("/api/calendars/:id/events/:eventId", handler.HandleUpdateEvent)
+	r.DELETE("/api/calendars/:id/events/:eventId", handler.HandleDeleteEvent)
+
+	// Availability
+	r.GET("/api/availability", handler.HandleGetAvailability)
+
+	// .ics import
+	r.POST("/api/import/preview", handler.HandleImportPreview)
+
+	// ICS feeds
+	r.GET("/api/feeds", handler.HandleGetFeeds)
+	r.POST("/api/feeds", handler.HandleCreateFeed)
+	r.DELETE("/api/feeds/:id", handler.HandleDeleteFeed)
+	r.GET("/feeds/:token", handler.HandleServeFeed)
+
+	// CalDAV, signed in with app passwords
+	r.GET("/api/app-passwords", handler.HandleGetAppPasswords)
+	r.POST("/api/app-passwords", handler.HandleCreateAppPassword)
+	r.DELETE("/api/app-passwords/:id", handler.HandleDeleteAppPassword)
+
+	dav := davserver.New("/dav")
+	for _, method := range davserver.Methods {
+		r.Handle(method, "/dav/*path", gin.WrapH(dav))
+		r.Handle(method, "/.well-known/caldav", gin.WrapF(dav.WellKnown))
+	}
 
 	return r
 }
@@ -0,0 +1,61 @@
+// Package tokensource gives providers token sources for the credentials of
+// connected accounts. Accounts in the database are refreshed and stored
+// through shared/oauthtoken, which the watcher uses too.
+package tokensource
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+
+	"calendar-backend/provider"
+	"shared/oauthtoken"
+)
+
+// New returns a token source for the account in creds that refreshes
+// through config and stores what it gets. Without an account ID, as during
+// the connect flow, tokens are refreshed but not stored.
+func New(ctx context.Context, config *oauth2.Config, creds provider.Credentials) oauth2.TokenSource {
+	token := fromCredentials(creds)
+	if creds.AccountID == "" {
+		return config.TokenSource(ctx, token)
+	}
+	return oauthtoken.Source(ctx, config, creds.AccountID, token)
+}
+
+// Refresh refreshes the account's token even if it hasn't expired, stores
+// it and returns the updated credentials.
+func Refresh(ctx context.Context, config *oauth2.Config, creds provider.Credentials) (*provider.Credentials, error) {
+	var token *oauth2.Token
+	var err error
+	if creds.AccountID == "" {
+		token, err = oauthtoken.Refresh(ctx, config, fromCredentials(creds))
+	} else {
+		token, err = oauthtoken.ForceRefresh(ctx, config, creds.AccountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := creds
+	refreshed.AccessToken = token.AccessToken
+	refreshed.RefreshToken = token.RefreshToken
+	refreshed.Expiry = nil
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		refreshed.Expiry = &expiry
+	}
+	return &refreshed, nil
+}
+
+func fromCredentials(creds provider.Credentials) *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  creds.AccessToken,
+		RefreshToken: creds.RefreshToken,
+		TokenType:    "Bearer",
+	}
+	if creds.Expiry != nil {
+		token.Expiry = *creds.Expiry
+	}
+	return token
+}
@@ -0,0 +1,151 @@
+// Package writethrough applies event changes to the calendar's provider and
+// then to the events table, so the REST API, imports and CalDAV clients all
+// write the same way.
+package writethrough
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"calendar-backend/database"
+	"calendar-backend/google"
+	"calendar-backend/ics"
+	"calendar-backend/provider"
+)
+
+var (
+	// ErrReadOnly is returned for calendars nothing can be written to, such
+	// as subscribed feeds.
+	ErrReadOnly = errors.New("calendar is read-only")
+	// ErrAccountMissing is returned when the calendar's connected account
+	// was removed.
+	ErrAccountMissing = errors.New("calendar's account is no longer connected")
+	// ErrNeedsReauth is returned when the provider revoked the calendar's
+	// account, until the user reconnects it.
+	ErrNeedsReauth = errors.New("calendar's account needs to be reconnected")
+)
+
+// Credentials returns what a provider needs to act for the account.
+func Credentials(account *database.ConnectedAccount) provider.Credentials {
+	creds := provider.Credentials{
+		AccountID:    account.ID,
+		AccessToken:  account.AccessToken,
+		RefreshToken: account.RefreshToken,
+		ServerURL:    account.ServerURL,
+		Username:     account.Username,
+	}
+	if account.TokenExpiry != nil {
+		expiry := time.Unix(*account.TokenExpiry, 0)
+		creds.Expiry = &expiry
+	}
+	return creds
+}
+
+// Target is a calendar events are written to and the provider that writes
+// them.
+type Target struct {
+	Calendar *database.Calendar
+	Provider provider.Provider
+	Creds    provider.Credentials
+}
+
+// Writable reports whether events can be written to the calendar at all.
+// Subscribed feeds and free/busy calendars only mirror someone else's data.
+func Writable(calendar *database.Calendar) bool {
+	return calendar.Provider != ics.ProviderName && calendar.Provider != google.FreeBusyProviderName
+}
+
+// Resolve finds the provider and credentials to write to the calendar with.
+func Resolve(calendar *database.Calendar) (*Target, error) {
+	if !Writable(calendar) {
+		return nil, ErrReadOnly
+	}
+
+	target := &Target{Calendar: calendar}
+
+	if calendar.ConnectedAccountID != nil {
+		account, err := database.GetConnectedAccountById(*calendar.ConnectedAccountID)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, ErrAccountMissing
+		}
+		if account.NeedsReauth {
+			return nil, ErrNeedsReauth
+		}
+		target.Creds = Credentials(account)
+	}
+
+	p, err := provider.Get(calendar.Provider)
+	if err != nil {
+		return nil, err
+	}
+	target.Provider = p
+
+	return target, nil
+}
+
+// Create writes a new event through the provider and stores what it returns.
+func (t *Target) Create(ctx context.Context, event provider.CalendarEvent) (*database.Event, error) {
+	created, err := t.Provider.CreateEvent(ctx, t.Creds, t.Calendar.ProviderCalendarID, event)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(*created)
+}
+
+// Update replaces an existing event through the provider and stores what it
+// returns.
+func (t *Target) Update(ctx context.Context, existing *database.Event, event provider.CalendarEvent) (*database.Event, error) {
+	event.ProviderEventID = existing.ProviderEventID
+	updated, err := t.Provider.UpdateEvent(ctx, t.Creds, t.Calendar.ProviderCalendarID, event)
+	if err != nil {
+		return nil, err
+	}
+	return t.store(*updated)
+}
+
+// Delete removes an event through the provider and from the events table.
+func (t *Target) Delete(ctx context.Context, existing *database.Event) error {
+	err := t.Provider.DeleteEvent(ctx, t.Creds, t.Calendar.ProviderCalendarID, existing.ProviderEventID)
+	if err != nil {
+		return err
+	}
+	return database.DeleteEventByProviderEventId(t.Calendar.ID, existing.ProviderEventID)
+}
+
+// store records the event so it shows up before the next sync, and returns
+// the stored row.
+func (t *Target) store(event provider.CalendarEvent) (*database.Event, error) {
+	if err := database.UpsertEvent(t.Calendar.ID, StoredEvent(event)); err != nil {
+		return nil, err
+	}
+	stored, err := database.GetEventByProviderEventId(t.Calendar.ID, event.ProviderEventID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errors.New("stored event not found")
+	}
+	return stored, nil
+}
+
+// StoredEvent converts an event returned by a provider to its database row.
+func StoredEvent(event provider.CalendarEvent) database.Event {
+	return database.Event{
+		ProviderEventID: event.ProviderEventID,
+		Title:           event.Title,
+		Description:     event.Description,
+		Location:        event.Location,
+		StartTime:       event.StartTime,
+		EndTime:         event.EndTime,
+		IsAllDay:        event.IsAllDay,
+		Status:          event.Status,
+		Recurrence:      event.Recurrence,
+		Attendees:       event.Attendees,
+		Etag:            event.Etag,
+		RawData:         event.RawData,
+	}
+}
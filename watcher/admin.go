@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerAdminRoutes exposes the operational API used to debug calendars
+// that stopped syncing. It is only enabled when WATCHER_ADMIN_TOKEN is set and
+// every request must send it as a bearer token.
+func registerAdminRoutes(r *gin.Engine) {
+	if config.AdminToken == "" {
+		log.Printf("Admin API disabled - WATCHER_ADMIN_TOKEN is not set")
+		return
+	}
+
+	admin := r.Group("/admin", requireAdminToken())
+	admin.GET("/health", handleAdminHealth)
+	admin.GET("/channels", handleAdminChannels)
+	admin.GET("/quota", handleAdminQuota)
+	admin.POST("/calendars/:id/register", handleAdminRegister)
+	admin.POST("/calendars/:id/resync", handleAdminResync)
+}
+
+func requireAdminToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func handleAdminHealth(c *gin.Context) {
+	syncs, failures, failing := stats.totals()
+
+	status := "ok"
+	if failing > 0 {
+		status = "degraded"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":            status,
+		"uptime_seconds":    int64(time.Since(stats.started).Seconds()),
+		"queue_depth":       queue.depth(),
+		"queue_capacity":    queue.capacity(),
+		"syncs_running":     queue.running(),
+		"workers":           config.SyncWorkers,
+		"syncs_total":       syncs,
+		"failures_total":    failures,
+		"failing_calendars": failing,
+	})
+}
+
+func handleAdminChannels(c *gin.Context) {
+	calendars, err := listWatchedCalendars()
+	if err != nil {
+		log.Printf("Failed to list calendars: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list calendars"})
+		return
+	}
+
+	now := time.Now()
+	result := make([]gin.H, 0, len(calendars))
+	for _, cal := range calendars {
+		status := stats.snapshot(cal.ID)
+		result = append(result, gin.H{
+			"calendar_id":          cal.ID,
+			"user_id":              cal.UserID,
+			"provider":             cal.Provider,
+			"provider_calendar_id": cal.ProviderCalendarID,
+			"channel_id":           nullString(cal.ChannelID),
+			"resource_id":          nullString(cal.ResourceID),
+			"expires_at":           nullTime(unixTime(cal.WebhookExpiry)),
+			"expired":              cal.WebhookExpiry <= now.Unix(),
+			"sync_mode":            effectiveSyncMode(cal),
+			"polled":               calendarPoller.isPolled(cal.ID),
+			"needs_reauth":         cal.NeedsReauth,
+			"last_notification_at": nullTime(status.LastNotification),
+			"last_sync_at":         nullTime(status.LastSync),
+			"last_changes":         status.LastChanges,
+			"last_error":           nullString(status.LastError),
+			"consecutive_failures": status.ConsecutiveFailures,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"channels": result})
+}
+
+// handleAdminQuota reports the sync backend's provider calls per connected
+// account over the last ?hours= (24 by default), busiest accounts first.
+func handleAdminQuota(c *gin.Context) {
+	hours := 24
+	if value := c.Query("hours"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be a positive number"})
+			return
+		}
+		hours = n
+	}
+
+	since := time.Now().Truncate(time.Hour).Add(-time.Duration(hours-1) * time.Hour)
+	usage, err := listQuotaUsage(since.Unix())
+	if err != nil {
+		log.Printf("Failed to list quota usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list quota usage"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(usage))
+	for _, u := range usage {
+		result = append(result, gin.H{
+			"provider":   u.Provider,
+			"account_id": u.AccountID,
+			"user_id":    nullString(u.UserID),
+			"email":      nullString(u.Email),
+			"calls":      u.Calls,
+			"throttled":  u.Throttled,
+			"wait_ms":    u.WaitMs,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"since": since.UTC().Format(time.RFC3339),
+		"usage": result,
+	})
+}
+
+// handleAdminRegister replaces the calendar's push channel or Graph
+// subscription with a freshly registered one.
+func handleAdminRegister(c *gin.Context) {
+	cal, ok := loadAdminCalendar(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	var err error
+
+	switch cal.Provider {
+	case "google":
+		err = setWatcher(ctx, cal)
+	case "outlook":
+		if outlookOAuthConf == nil || config.PublicURL == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Outlook subscriptions are not configured"})
+			return
+		}
+		if cal.ChannelID != "" {
+			if err := deleteOutlookSubscription(ctx, cal); err != nil {
+				log.Printf("Failed to delete Outlook subscription %s: %v", cal.ChannelID, err)
+			}
+		}
+		err = createOutlookSubscription(ctx, cal)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider does not support push channels"})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Forced re-registration of calendar %s failed: %v", cal.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := getCalendarById(cal.ID)
+	if err != nil || updated == nil {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"channel_id":  updated.ChannelID,
+		"resource_id": updated.ResourceID,
+		"expires_at":  nullTime(unixTime(updated.WebhookExpiry)),
+	})
+}
+
+// handleAdminResync queues an incremental sync of the calendar, or with
+// ?full=true one that replaces the stored events with a full listing and
+// re-establishes the token.
+func handleAdminResync(c *gin.Context) {
+	cal, ok := loadAdminCalendar(c)
+	if !ok {
+		return
+	}
+
+	if cal.Provider != "google" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Resync is only supported for Google calendars"})
+		return
+	}
+
+	full := c.Query("full") == "true"
+	if !queue.enqueue(&syncJob{calendarID: cal.ID, source: "admin", full: full}) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Sync queue is full"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queued": true, "full": full})
+}
+
+func loadAdminCalendar(c *gin.Context) (*watchedCalendar, bool) {
+	cal, err := getCalendarById(c.Param("id"))
+	if err != nil {
+		log.Printf("Failed to load calendar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load calendar"})
+		return nil, false
+	}
+
+	if cal == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found"})
+		return nil, false
+	}
+
+	return cal, true
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const capturedCalendarsKey = "captured_calendars"
+
+// capturedWebhook is one line of a WATCHER_CAPTURE_FILE. It holds everything
+// needed to feed the request back through the handlers with `watcher replay`.
+type capturedWebhook struct {
+	Timestamp   time.Time   `json:"timestamp"`
+	Method      string      `json:"method"`
+	Path        string      `json:"path"`
+	Query       string      `json:"query,omitempty"`
+	Headers     http.Header `json:"headers"`
+	Body        string      `json:"body,omitempty"`
+	CalendarIDs []string    `json:"calendar_ids,omitempty"`
+	Status      int         `json:"status"`
+}
+
+type webhookCapture struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openCapture opens the capture file for appending. With an empty path the
+// returned capture is disabled and its middleware does nothing.
+func openCapture(path string) (*webhookCapture, error) {
+	if path == "" {
+		return &webhookCapture{}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Capturing webhooks to %s", path)
+	return &webhookCapture{file: file}, nil
+}
+
+func (w *webhookCapture) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if w.file == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("Failed to read webhook body for capture: %v", err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		received := time.Now()
+		c.Next()
+
+		w.write(capturedWebhook{
+			Timestamp:   received,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			Query:       c.Request.URL.RawQuery,
+			Headers:     c.Request.Header.Clone(),
+			Body:        string(body),
+			CalendarIDs: c.GetStringSlice(capturedCalendarsKey),
+			Status:      c.Writer.Status(),
+		})
+	}
+}
+
+func (w *webhookCapture) write(record capturedWebhook) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode captured webhook: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write captured webhook: %v", err)
+	}
+}
+
+// noteCapturedCalendar records the calendar a webhook was resolved to, so the
+// capture shows which calendars each notification touched.
+func noteCapturedCalendar(c *gin.Context, calendarID string) {
+	c.Set(capturedCalendarsKey, append(c.GetStringSlice(capturedCalendarsKey), calendarID))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Google keeps event channels for up to a week. Channels are re-registered
+// once they get within googleRenewBefore of expiring.
+const (
+	googleRenewBefore   = 24 * time.Hour
+	googleRenewInterval = 30 * time.Minute
+)
+
+func runGoogleChannels() {
+	if config.PublicURL == "" {
+		log.Printf("Google push channels disabled - WATCHER_PUBLIC_URL is not set")
+		return
+	}
+
+	ticker := time.NewTicker(googleRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		ensureGoogleChannels()
+		<-ticker.C
+	}
+}
+
+// ensureGoogleChannels registers a push channel for every calendar that
+// should receive webhooks and doesn't have one expiring later than
+// googleRenewBefore. Calendars in poll mode are left alone.
+func ensureGoogleChannels() {
+	calendars, err := listCalendarsByProvider("google")
+	if err != nil {
+		log.Printf("Failed to list Google calendars: %v", err)
+		return
+	}
+
+	renewBefore := time.Now().Add(googleRenewBefore).Unix()
+
+	for _, cal := range calendars {
+		if effectiveSyncMode(cal) == syncModePoll {
+			continue
+		}
+		if cal.ChannelID != "" && cal.WebhookExpiry > renewBefore {
+			continue
+		}
+
+		if err := setWatcher(context.Background(), &cal); err != nil {
+			log.Printf("Failed to set watcher for calendar %s: %v", cal.ID, err)
+		}
+	}
+}
+
+// setWatcher registers a new push channel for the calendar and stops the one
+// it replaces. Each channel gets a fresh ID and verification token, so
+// notifications still in flight for the old channel are recognised as stale.
+func setWatcher(ctx context.Context, cal *watchedCalendar) error {
+	if config.PublicURL == "" {
+		return fmt.Errorf("WATCHER_PUBLIC_URL is not set")
+	}
+
+	srv, err := newCalendarClient(ctx, cal)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := randomToken()
+	if err != nil {
+		return err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	var channel *calendar.Channel
+	err = googleLimiter.Do(ctx, cal.AccountID, func() (err error) {
+		channel, err = srv.Events.Watch(cal.ProviderCalendarID, &calendar.Channel{
+			Id:      "channel-" + cal.ID + "-" + suffix[:16],
+			Type:    "web_hook",
+			Address: strings.TrimRight(config.PublicURL, "/") + "/google/webhook",
+			Token:   token,
+		}).Context(ctx).Do()
+		return googleRateLimited(err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch calendar: %w", err)
+	}
+
+	// Google reports the expiration in milliseconds.
+	if err := updateCalendarWebhook(cal.ID, channel.ResourceId, channel.Id, token, channel.Expiration/1000); err != nil {
+		return err
+	}
+
+	log.Printf("Watcher set for calendar: %s (channel %s)", cal.ID, channel.Id)
+
+	if cal.ChannelID != "" && cal.ResourceID != "" {
+		err := googleLimiter.Do(ctx, cal.AccountID, func() error {
+			return googleRateLimited(srv.Channels.Stop(&calendar.Channel{
+				Id:         cal.ChannelID,
+				ResourceId: cal.ResourceID,
+			}).Context(ctx).Do())
+		})
+		if err != nil {
+			log.Printf("Failed to stop old channel %s for calendar %s: %v", cal.ChannelID, cal.ID, err)
+		}
+	}
+
+	return nil
+}
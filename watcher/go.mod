@@ -8,8 +8,17 @@ require (
 	github.com/gin-gonic/gin v1.10.1
 	golang.org/x/oauth2 v0.30.0
 	google.golang.org/api v0.237.0
+	shared/database v0.0.0
+	shared/oauthtoken v0.0.0
+	shared/ratelimit v0.0.0
 )
 
+replace shared/database => ../shared/database
+
+replace shared/oauthtoken => ../shared/oauthtoken
+
+replace shared/ratelimit => ../shared/ratelimit
+
 require (
 	cloud.google.com/go/auth v0.16.2 // indirect
 	cloud.google.com/go/auth/oauth2adapt v0.2.8 // indirect
@@ -35,6 +44,7 @@ require (
 	github.com/klauspost/cpuid/v2 v2.2.7 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/mattn/go-sqlite3 v1.14.30 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.2 // indirect
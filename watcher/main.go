@@ -2,26 +2,45 @@ package main
 
 import (
 	"bytes"
-	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/calendar/v3"
+	"shared/ratelimit"
 )
 
 type Config struct {
-	BackendAddr   string
-	BackendPort   string
-	WatcherPort   string
-	OutlookClid   string
-	OutlookSecret string
+	BackendAddr     string
+	BackendPort     string
+	BackendURL      string
+	BackendSecret   string
+	WatcherPort     string
+	OutlookClid     string
+	OutlookSecret   string
+	OutlookTokenURL string
+	GraphBaseURL    string
+	PublicURL       string
+	AdminToken      string
+	SyncWorkers     int
+	QueueSize       int
+	CaptureFile     string
+	SyncMode        string
+	PollMinInterval time.Duration
+	PollMaxInterval time.Duration
+
+	ProviderRateLimit    float64
+	ProviderRateBurst    int
+	ProviderMaxRetryWait time.Duration
 }
 
 var config Config
@@ -34,13 +53,71 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s must be a positive duration, got %q", key, val)
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s must be a positive integer, got %q", key, val)
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f <= 0 {
+		log.Fatalf("%s must be a positive number, got %q", key, val)
+	}
+	return f
+}
+
 func main() {
 	loadConfig()
 	initOAuth()
+	initOutlookOAuth()
+	initLimiters()
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
+	capture, err := openCapture(config.CaptureFile)
+	if err != nil {
+		log.Fatal("Failed to open capture file:", err)
+	}
 
 	r := gin.Default()
 
-	r.POST("/google/webhook", handleGoogleWebhook)
+	r.POST("/google/webhook", capture.middleware(), handleGoogleWebhook)
+	r.POST("/outlook/webhook", capture.middleware(), handleOutlookWebhook)
+	registerAdminRoutes(r)
+
+	queue = newSyncQueue(config.QueueSize)
+	queue.start(config.SyncWorkers)
+
+	go ratelimit.RunFlusher(time.Minute)
+	go runPoller()
+	go runGoogleChannels()
+	go runOutlookSubscriptions()
 
 	log.Printf("Watcher running on port %s", config.WatcherPort)
 	if err := r.Run(":" + config.WatcherPort); err != nil {
@@ -50,11 +127,42 @@ func main() {
 
 func loadConfig() {
 	config = Config{
-		BackendAddr:   getEnv("BACKEND_ADDR", "http://localhost"),
-		BackendPort:   getEnv("BACKEND_PORT", "8080"),
-		WatcherPort:   getEnv("WATCHER_PORT", "3030"),
-		OutlookClid:   os.Getenv("OUTLOOK_CLIENT_ID"),
-		OutlookSecret: os.Getenv("OUTLOOK_CLIENT_SECRET"),
+		BackendAddr:     getEnv("BACKEND_ADDR", "http://localhost"),
+		BackendPort:     getEnv("BACKEND_PORT", "8080"),
+		BackendSecret:   os.Getenv("WATCHER_BACKEND_SECRET"),
+		WatcherPort:     getEnv("WATCHER_PORT", "3030"),
+		OutlookClid:     os.Getenv("OUTLOOK_CLIENT_ID"),
+		OutlookSecret:   os.Getenv("OUTLOOK_CLIENT_SECRET"),
+		OutlookTokenURL: getEnv("OUTLOOK_TOKEN_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/token"),
+		GraphBaseURL:    strings.TrimRight(getEnv("GRAPH_BASE_URL", "https://graph.microsoft.com/v1.0"), "/"),
+		PublicURL:       os.Getenv("WATCHER_PUBLIC_URL"),
+		AdminToken:      os.Getenv("WATCHER_ADMIN_TOKEN"),
+		SyncWorkers:     getEnvInt("WATCHER_SYNC_WORKERS", 4),
+		QueueSize:       getEnvInt("WATCHER_QUEUE_SIZE", 1000),
+		CaptureFile:     os.Getenv("WATCHER_CAPTURE_FILE"),
+		SyncMode:        getEnv("WATCHER_SYNC_MODE", syncModeAuto),
+		PollMinInterval: getEnvDuration("WATCHER_POLL_MIN_INTERVAL", 30*time.Second),
+		PollMaxInterval: getEnvDuration("WATCHER_POLL_MAX_INTERVAL", 15*time.Minute),
+
+		ProviderRateLimit:    getEnvFloat("PROVIDER_RATE_LIMIT", 5),
+		ProviderRateBurst:    getEnvInt("PROVIDER_RATE_BURST", 10),
+		ProviderMaxRetryWait: getEnvDuration("PROVIDER_MAX_RETRY_WAIT", 30*time.Second),
+	}
+
+	config.BackendURL = config.BackendAddr + ":" + config.BackendPort
+
+	if config.BackendSecret == "" {
+		log.Fatal("WATCHER_BACKEND_SECRET must be set, the backend rejects forwarded events without it")
+	}
+
+	switch config.SyncMode {
+	case syncModeAuto, syncModePush, syncModePoll:
+	default:
+		log.Fatalf("WATCHER_SYNC_MODE must be one of auto, push or poll, got %q", config.SyncMode)
+	}
+
+	if config.PollMaxInterval < config.PollMinInterval {
+		config.PollMaxInterval = config.PollMinInterval
 	}
 }
 
@@ -88,104 +196,76 @@ func handleGoogleWebhook(c *gin.Context) {
 		return
 	}
 
-	// TODO: Load calendar ID and tokens from database based on resourceID
-	calendarID := "" // Load from DB
-	at := ""         // Access token from DB
-	rt := ""         // Refresh token from DB
+	cal, err := getCalendarByResourceId(resourceID)
+	if err != nil {
+		log.Printf("Failed to load calendar for resource %s: %v", resourceID, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
 
-	if calendarID == "" || at == "" {
-		log.Println("Calendar not configured - webhook received but no calendar mapping")
+	if cal == nil {
+		log.Printf("Calendar not configured - webhook received for unknown resource %s", resourceID)
 		c.Status(http.StatusOK) // Acknowledge webhook but do nothing
 		return
 	}
 
-	token := &oauth2.Token{
-		AccessToken:  at,
-		RefreshToken: rt,
-		TokenType:    "Bearer",
-	}
+	noteCapturedCalendar(c, cal.ID)
 
-	ctx := context.Background()
-	client := oauthConf.Client(ctx, token)
+	channelID := c.GetHeader("X-Goog-Channel-ID")
+	if !isReplay(c) && cal.ChannelID != "" && channelID != cal.ChannelID {
+		log.Printf("Ignoring notification from stale channel %s for calendar %s", channelID, cal.ID)
+		c.Status(http.StatusOK)
+		return
+	}
 
-	srv, err := calendar.New(client)
-	if err != nil {
-		log.Printf("Calendar client error: %v", err)
-		c.Status(http.StatusInternalServerError)
+	if !isReplay(c) && cal.WebhookToken != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Goog-Channel-Token")), []byte(cal.WebhookToken)) != 1 {
+		log.Printf("Ignoring notification with invalid channel token for calendar %s", cal.ID)
+		c.Status(http.StatusOK)
 		return
 	}
 
-	updatedMin := time.Now().Add(-30 * time.Second).Format(time.RFC3339)
-	events, err := srv.Events.List(calendarID).
-		ShowDeleted(false).
-		SingleEvents(true).
-		OrderBy("updated").
-		TimeMin(updatedMin).
-		Do()
-	if err != nil {
-		log.Printf("Failed to list events: %v", err)
-		c.Status(http.StatusInternalServerError)
+	stats.recordNotification(cal.ID)
+
+	if cal.NeedsReauth {
+		log.Printf("Ignoring notification for calendar %s until its account is reconnected", cal.ID)
+		c.Status(http.StatusOK)
 		return
 	}
 
-	for _, event := range events.Items {
-		log.Printf("Triggering /event/%s (%s)\n", event.Id, event.Summary)
-		postToBackend("/event/"+event.Id, map[string]string{"summary": event.Summary})
+	if !queue.enqueue(&syncJob{calendarID: cal.ID, source: "webhook"}) {
+		// Google retries notifications answered with a 503.
+		log.Printf("Sync queue full, rejecting notification for calendar %s", cal.ID)
+		c.Status(http.StatusServiceUnavailable)
+		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
-func setWatcher(token, calendarID, webhookAddress string) error {
-	payload := map[string]interface{}{
-		"id":      "channel-" + calendarID,
-		"type":    "web_hook",
-		"address": webhookAddress,
-	}
+func postToBackend(endpoint string, payload any) error {
+	url := config.BackendURL + endpoint
 
-	jsonPayload, err := json.Marshal(payload)
+	req, err := http.NewRequest(http.MethodPost, url, toReader(payload))
 	if err != nil {
 		return err
 	}
-
-	req, err := http.NewRequest("POST",
-		"https://www.googleapis.com/calendar/v3/calendars/"+calendarID+"/events/watch",
-		bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return err
-	}
-
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Authorization", "Bearer "+config.BackendSecret)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		log.Printf("POST to backend failed: %v", err)
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Watcher setup failed: %s - %s", resp.Status, string(body))
-	} else {
-		log.Printf("Watcher set for calendar: %s", calendarID)
+		return fmt.Errorf("backend returned %s", resp.Status)
 	}
 
 	return nil
 }
 
-func postToBackend(endpoint string, payload any) {
-	url := config.BackendAddr + ":" + config.BackendPort + endpoint
-
-	resp, err := http.Post(url, "application/json", toReader(payload))
-	if err != nil {
-		log.Printf("POST to backend failed: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-}
-
 func toReader(v any) io.Reader {
 	buf, _ := json.Marshal(v)
 	return bytes.NewReader(buf)
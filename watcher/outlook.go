@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"shared/ratelimit"
+)
+
+// Graph caps event subscriptions at 4230 minutes. Subscriptions are renewed
+// once they get within outlookRenewBefore of expiring.
+const (
+	outlookSubscriptionTTL = 70 * time.Hour
+	outlookRenewBefore     = 12 * time.Hour
+	outlookRenewInterval   = 30 * time.Minute
+)
+
+var outlookOAuthConf *oauth2.Config
+
+type graphNotification struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ClientState    string `json:"clientState"`
+	ChangeType     string `json:"changeType"`
+	Resource       string `json:"resource"`
+	ResourceData   struct {
+		ID string `json:"id"`
+	} `json:"resourceData"`
+}
+
+type graphSubscription struct {
+	ID                 string `json:"id,omitempty"`
+	ChangeType         string `json:"changeType,omitempty"`
+	NotificationURL    string `json:"notificationUrl,omitempty"`
+	Resource           string `json:"resource,omitempty"`
+	ExpirationDateTime string `json:"expirationDateTime"`
+	ClientState        string `json:"clientState,omitempty"`
+}
+
+type graphError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *graphError) Error() string {
+	return fmt.Sprintf("graph returned %d: %s", e.StatusCode, e.Body)
+}
+
+type outlookResource struct {
+	UserID     string
+	CalendarID string
+	EventID    string
+}
+
+func initOutlookOAuth() {
+	if config.OutlookClid == "" || config.OutlookSecret == "" {
+		return
+	}
+
+	outlookOAuthConf = &oauth2.Config{
+		ClientID:     config.OutlookClid,
+		ClientSecret: config.OutlookSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL: config.OutlookTokenURL,
+		},
+		Scopes: []string{"offline_access", "Calendars.Read"},
+	}
+}
+
+func handleOutlookWebhook(c *gin.Context) {
+	// Graph validates the notification URL by posting a token that has to be
+	// echoed back as plain text within 10 seconds.
+	if validationToken := c.Query("validationToken"); validationToken != "" {
+		c.String(http.StatusOK, validationToken)
+		return
+	}
+
+	var body struct {
+		Value []graphNotification `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("Invalid Graph notification payload: %v", err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	failed := false
+	for _, n := range body.Value {
+		if err := processOutlookNotification(c, n); err != nil {
+			log.Printf("Failed to process Graph notification for subscription %s: %v", n.SubscriptionID, err)
+			failed = true
+		}
+	}
+
+	// A 5xx makes Graph redeliver the batch later.
+	if failed {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func processOutlookNotification(c *gin.Context, n graphNotification) error {
+	cal, err := getOutlookCalendarBySubscriptionId(n.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if cal == nil {
+		log.Printf("Ignoring Graph notification for unknown subscription %s", n.SubscriptionID)
+		return nil
+	}
+
+	noteCapturedCalendar(c, cal.ID)
+
+	if !isReplay(c) && (cal.WebhookToken == "" || subtle.ConstantTimeCompare([]byte(n.ClientState), []byte(cal.WebhookToken)) != 1) {
+		log.Printf("Ignoring Graph notification with invalid clientState for calendar %s", cal.ID)
+		return nil
+	}
+
+	stats.recordNotification(cal.ID)
+
+	if cal.NeedsReauth {
+		log.Printf("Ignoring Graph notification for calendar %s until its account is reconnected", cal.ID)
+		return nil
+	}
+
+	res := parseOutlookResource(n.Resource)
+	eventID := res.EventID
+	if eventID == "" {
+		eventID = n.ResourceData.ID
+	}
+	if eventID == "" {
+		log.Printf("Ignoring Graph notification without event id: %s", n.Resource)
+		return nil
+	}
+
+	status := "confirmed"
+	if n.ChangeType == "deleted" {
+		status = "cancelled"
+	}
+
+	log.Printf("Triggering sync of calendar %s for event %s (%s, outlook user %s)", cal.ID, eventID, n.ChangeType, res.UserID)
+	now := time.Now()
+	err = postToBackend("/calendar/"+url.PathEscape(cal.ID)+"/sync", eventChange{
+		Provider:           cal.Provider,
+		CalendarID:         cal.ID,
+		ProviderCalendarID: cal.ProviderCalendarID,
+		Status:             status,
+		Updated:            now.UTC().Format(time.RFC3339),
+	})
+	stats.recordSync(cal.ID, now, 1, err)
+
+	return err
+}
+
+// parseOutlookResource extracts IDs from a notification resource path such as
+// "Users/{user}/Events/{event}" or "Users('{user}')/calendars('{cal}')/events('{event}')".
+func parseOutlookResource(resource string) outlookResource {
+	var res outlookResource
+
+	parts := splitResource(strings.Trim(resource, "/"))
+	for i := 0; i < len(parts); i++ {
+		name, id := parts[i], ""
+
+		if open := strings.Index(name, "('"); open != -1 && strings.HasSuffix(name, "')") {
+			id = name[open+2 : len(name)-2]
+			name = name[:open]
+		} else if !strings.EqualFold(name, "me") && i+1 < len(parts) {
+			id = parts[i+1]
+			i++
+		}
+
+		switch strings.ToLower(name) {
+		case "users":
+			res.UserID = id
+		case "calendars":
+			res.CalendarID = id
+		case "events":
+			res.EventID = id
+		}
+	}
+
+	return res
+}
+
+func runOutlookSubscriptions() {
+	if outlookOAuthConf == nil {
+		log.Printf("Outlook subscriptions disabled - missing OUTLOOK_CLIENT_ID or OUTLOOK_CLIENT_SECRET")
+		return
+	}
+	if config.PublicURL == "" {
+		log.Printf("Outlook subscriptions disabled - WATCHER_PUBLIC_URL is not set")
+		return
+	}
+
+	ticker := time.NewTicker(outlookRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		ensureOutlookSubscriptions()
+		<-ticker.C
+	}
+}
+
+// ensureOutlookSubscriptions creates missing subscriptions and renews the ones
+// close to expiry. A subscription Graph no longer knows about is recreated.
+func ensureOutlookSubscriptions() {
+	calendars, err := listCalendarsByProvider("outlook")
+	if err != nil {
+		log.Printf("Failed to list Outlook calendars: %v", err)
+		return
+	}
+
+	renewBefore := time.Now().Add(outlookRenewBefore).Unix()
+
+	for _, cal := range calendars {
+		ctx := context.Background()
+
+		if cal.ChannelID != "" && cal.WebhookExpiry > renewBefore {
+			continue
+		}
+
+		if cal.ChannelID != "" {
+			err := renewOutlookSubscription(ctx, &cal)
+			var gErr *graphError
+			if errors.As(err, &gErr) && gErr.StatusCode == http.StatusNotFound {
+				log.Printf("Outlook subscription %s for calendar %s is gone, recreating", cal.ChannelID, cal.ID)
+				err = createOutlookSubscription(ctx, &cal)
+			}
+			if err != nil {
+				log.Printf("Failed to renew Outlook subscription for calendar %s: %v", cal.ID, err)
+			}
+			continue
+		}
+
+		if err := createOutlookSubscription(ctx, &cal); err != nil {
+			log.Printf("Failed to create Outlook subscription for calendar %s: %v", cal.ID, err)
+		}
+	}
+}
+
+func createOutlookSubscription(ctx context.Context, cal *watchedCalendar) error {
+	clientState, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	req := graphSubscription{
+		ChangeType:         "created,updated,deleted",
+		NotificationURL:    strings.TrimRight(config.PublicURL, "/") + "/outlook/webhook",
+		Resource:           "me/calendars/" + cal.ProviderCalendarID + "/events",
+		ExpirationDateTime: time.Now().Add(outlookSubscriptionTTL).UTC().Format(time.RFC3339),
+		ClientState:        clientState,
+	}
+
+	var sub graphSubscription
+	if err := graphRequest(ctx, cal, http.MethodPost, "/subscriptions", req, &sub); err != nil {
+		return err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, sub.ExpirationDateTime)
+	if err != nil {
+		return fmt.Errorf("invalid subscription expiry %q: %w", sub.ExpirationDateTime, err)
+	}
+
+	log.Printf("Outlook subscription %s created for calendar %s", sub.ID, cal.ID)
+	return updateCalendarWebhook(cal.ID, sub.Resource, sub.ID, clientState, expiry.Unix())
+}
+
+func renewOutlookSubscription(ctx context.Context, cal *watchedCalendar) error {
+	req := graphSubscription{
+		ExpirationDateTime: time.Now().Add(outlookSubscriptionTTL).UTC().Format(time.RFC3339),
+	}
+
+	var sub graphSubscription
+	if err := graphRequest(ctx, cal, http.MethodPatch, "/subscriptions/"+cal.ChannelID, req, &sub); err != nil {
+		return err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, sub.ExpirationDateTime)
+	if err != nil {
+		return fmt.Errorf("invalid subscription expiry %q: %w", sub.ExpirationDateTime, err)
+	}
+
+	resource := sub.Resource
+	if resource == "" {
+		resource = "me/calendars/" + cal.ProviderCalendarID + "/events"
+	}
+
+	log.Printf("Outlook subscription %s renewed for calendar %s", cal.ChannelID, cal.ID)
+	return updateCalendarWebhook(cal.ID, resource, cal.ChannelID, cal.WebhookToken, expiry.Unix())
+}
+
+func deleteOutlookSubscription(ctx context.Context, cal *watchedCalendar) error {
+	err := graphRequest(ctx, cal, http.MethodDelete, "/subscriptions/"+cal.ChannelID, nil, nil)
+	var gErr *graphError
+	if errors.As(err, &gErr) && gErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// graphRequest calls Graph for the calendar's account, paced by
+// graphLimiter. Throttled calls are retried after their Retry-After.
+func graphRequest(ctx context.Context, cal *watchedCalendar, method, path string, payload, out any) error {
+	client := oauth2.NewClient(ctx, accountTokenSource(ctx, outlookOAuthConf, cal))
+	client.Timeout = 10 * time.Second
+
+	return graphLimiter.Do(ctx, cal.AccountID, func() error {
+		var body io.Reader
+		if payload != nil {
+			body = toReader(payload)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, config.GraphBaseURL+path, body)
+		if err != nil {
+			return err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			body, _ := io.ReadAll(resp.Body)
+			graphErr := &graphError{StatusCode: resp.StatusCode, Body: string(body)}
+			// Graph throttles with 429, and sometimes 503, plus Retry-After.
+			if resp.StatusCode == http.StatusTooManyRequests ||
+				resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "" {
+				return &ratelimit.RateLimitError{RetryAfter: ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After")), Err: graphErr}
+			}
+			return graphErr
+		}
+
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	})
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
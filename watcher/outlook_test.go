@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"shared/database"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "watcher")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	gin.SetMode(gin.TestMode)
+
+	config = Config{
+		PublicURL:            "https://watcher.example.com",
+		BackendSecret:        "backend-secret",
+		ProviderRateLimit:    100,
+		ProviderRateBurst:    100,
+		ProviderMaxRetryWait: time.Second,
+	}
+	outlookOAuthConf = &oauth2.Config{ClientID: "client"}
+	initLimiters()
+
+	code := m.Run()
+	database.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// insertOutlookCalendar stores an Outlook calendar subscribed as channelID
+// with clientState token, on an account with a valid access token.
+func insertOutlookCalendar(t *testing.T, id, channelID, token string, expiry time.Time) {
+	t.Helper()
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	now := time.Now().Unix()
+	_, err = db.Exec(`INSERT OR IGNORE INTO users (id, email) VALUES ('user', 'user@example.com')`)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO connected_accounts
+		(id, user_id, provider, provider_account_id, email, access_token, refresh_token, token_expiry, created_at, updated_at)
+		VALUES (?, 'user', 'outlook', ?, 'user@example.com', 'access', 'refresh', ?, ?, ?)
+	`, "account-"+id, "account-"+id, now+3600, now, now)
+	if err != nil {
+		t.Fatalf("Failed to insert account: %v", err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO calendars
+		(id, user_id, connected_account_id, provider, provider_calendar_id, name,
+		 webhook_channel_id, webhook_token, webhook_expiry, created_at, updated_at)
+		VALUES (?, 'user', ?, 'outlook', ?, 'Calendar', NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?)
+	`, id, "account-"+id, "provider-"+id, channelID, token, expiry.Unix(), now, now)
+	if err != nil {
+		t.Fatalf("Failed to insert calendar: %v", err)
+	}
+}
+
+// recorder is a fake HTTP server that records the requests it answers.
+type recorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string // method, path and body
+}
+
+func newRecorder(t *testing.T, handler http.HandlerFunc) *recorder {
+	t.Helper()
+	rec := &recorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r.Method+" "+r.URL.Path+" "+string(body))
+		rec.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *recorder) calls() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.requests...)
+}
+
+func outlookRouter(replay bool) *gin.Engine {
+	r := gin.New()
+	if replay {
+		r.Use(func(c *gin.Context) { c.Set(replayKey, true) })
+	}
+	r.POST("/outlook/webhook", handleOutlookWebhook)
+	return r
+}
+
+func TestOutlookValidationHandshake(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/outlook/webhook?validationToken=Validation%3A+Testing+client+application", nil)
+	w := httptest.NewRecorder()
+	outlookRouter(false).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected a plain text answer, got %q", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != "Validation: Testing client application" {
+		t.Errorf("Expected the decoded token echoed back, got %q", w.Body.String())
+	}
+}
+
+func TestOutlookNotificationClientState(t *testing.T) {
+	backend := newRecorder(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer backend-secret" {
+			t.Errorf("Expected the backend secret to be sent, got %q", got)
+		}
+	})
+	config.BackendURL = backend.URL
+
+	insertOutlookCalendar(t, "state", "sub-state", "secret", time.Now().Add(time.Hour))
+	insertOutlookCalendar(t, "nostate", "sub-nostate", "", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name         string
+		subscription string
+		clientState  string
+		replay       bool
+		forwarded    bool
+	}{
+		{"matching", "sub-state", "secret", false, true},
+		{"wrong", "sub-state", "guess", false, false},
+		{"missing", "sub-state", "", false, false},
+		{"calendar without state", "sub-nostate", "", false, false},
+		{"unknown subscription", "sub-unknown", "secret", false, false},
+		{"replayed with old state", "sub-state", "rotated", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(backend.calls())
+			body := fmt.Sprintf(`{"value":[{"subscriptionId":%q,"clientState":%q,"changeType":"updated",`+
+				`"resource":"Users/u1/Events/ev1","resourceData":{"id":"ev1"}}]}`, tt.subscription, tt.clientState)
+			req := httptest.NewRequest(http.MethodPost, "/outlook/webhook", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			outlookRouter(tt.replay).ServeHTTP(w, req)
+
+			if w.Code != http.StatusAccepted {
+				t.Errorf("Expected 202, got %d", w.Code)
+			}
+			calls := backend.calls()[before:]
+			if forwarded := len(calls) > 0; forwarded != tt.forwarded {
+				t.Fatalf("Expected forwarded %v, got %v", tt.forwarded, calls)
+			}
+			if tt.forwarded && !strings.HasPrefix(calls[0], "POST /calendar/state/sync ") {
+				t.Errorf("Expected a sync of the calendar to be triggered, got %q", calls[0])
+			}
+		})
+	}
+}
+
+func TestOutlookNotificationBackendFailure(t *testing.T) {
+	backend := newRecorder(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	config.BackendURL = backend.URL
+
+	insertOutlookCalendar(t, "failing", "sub-failing", "secret", time.Now().Add(time.Hour))
+
+	body := `{"value":[{"subscriptionId":"sub-failing","clientState":"secret","changeType":"deleted","resource":"Users/u1/Events/ev2"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/outlook/webhook", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	outlookRouter(false).ServeHTTP(w, req)
+
+	// Graph redelivers batches answered with a 5xx.
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+}
+
+func TestEnsureOutlookSubscriptions(t *testing.T) {
+	db, err := database.GetDB()
+	if err != nil {
+		t.Fatalf("GetDB failed: %v", err)
+	}
+	db.Exec(`UPDATE calendars SET is_active = 0 WHERE provider = 'outlook'`)
+
+	insertOutlookCalendar(t, "expiring", "sub-expiring", "kept", time.Now().Add(time.Hour))
+	insertOutlookCalendar(t, "vanished", "sub-vanished", "stale", time.Now().Add(time.Hour))
+	insertOutlookCalendar(t, "fresh", "sub-fresh", "fresh", time.Now().Add(48*time.Hour))
+	insertOutlookCalendar(t, "new", "", "", time.Time{})
+
+	expiry := time.Now().Add(outlookSubscriptionTTL).UTC().Truncate(time.Second)
+	graph := newRecorder(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Expected the account's access token, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/subscriptions/sub-expiring":
+			fmt.Fprintf(w, `{"id":"sub-expiring","resource":"me/calendars/provider-expiring/events","expirationDateTime":%q}`,
+				expiry.Format(time.RFC3339))
+		case r.Method == http.MethodPatch && r.URL.Path == "/subscriptions/sub-vanished":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"ResourceNotFound"}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/subscriptions":
+			var sub graphSubscription
+			json.NewDecoder(r.Body).Decode(&sub)
+			id := "created-" + strings.TrimSuffix(strings.TrimPrefix(sub.Resource, "me/calendars/provider-"), "/events")
+			fmt.Fprintf(w, `{"id":%q,"resource":%q,"expirationDateTime":%q}`, id, sub.Resource, expiry.Format(time.RFC3339))
+		default:
+			t.Errorf("Unexpected Graph call %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	config.GraphBaseURL = graph.URL
+
+	ensureOutlookSubscriptions()
+
+	var posted []graphSubscription
+	for _, call := range graph.calls() {
+		if strings.HasPrefix(call, "POST /subscriptions ") {
+			var sub graphSubscription
+			json.Unmarshal([]byte(strings.TrimPrefix(call, "POST /subscriptions ")), &sub)
+			posted = append(posted, sub)
+		}
+		if strings.Contains(call, "sub-fresh") {
+			t.Errorf("Expected the fresh subscription to be left alone, got %q", call)
+		}
+	}
+	if len(posted) != 2 {
+		t.Fatalf("Expected subscriptions for the vanished and new calendars, got %d", len(posted))
+	}
+	for _, sub := range posted {
+		if sub.NotificationURL != "https://watcher.example.com/outlook/webhook" {
+			t.Errorf("Unexpected notification URL %q", sub.NotificationURL)
+		}
+		if len(sub.ClientState) != 64 {
+			t.Errorf("Expected a random clientState, got %q", sub.ClientState)
+		}
+	}
+
+	tests := []struct {
+		id        string
+		channelID string
+		token     string
+	}{
+		{"expiring", "sub-expiring", "kept"},
+		{"vanished", "created-vanished", ""},
+		{"new", "created-new", ""},
+		{"fresh", "sub-fresh", "fresh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			cal, err := getCalendarById(tt.id)
+			if err != nil || cal == nil {
+				t.Fatalf("getCalendarById failed: %v", err)
+			}
+			if cal.ChannelID != tt.channelID {
+				t.Errorf("Expected channel %s, got %s", tt.channelID, cal.ChannelID)
+			}
+			if tt.token != "" && cal.WebhookToken != tt.token {
+				t.Errorf("Expected clientState %q to be kept, got %q", tt.token, cal.WebhookToken)
+			}
+			if tt.token == "" {
+				var matched bool
+				for _, sub := range posted {
+					matched = matched || sub.ClientState == cal.WebhookToken
+				}
+				if !matched {
+					t.Errorf("Expected the new clientState to be stored, got %q", cal.WebhookToken)
+				}
+			}
+			if tt.id != "fresh" && cal.WebhookExpiry != expiry.Unix() {
+				t.Errorf("Expected expiry %d, got %d", expiry.Unix(), cal.WebhookExpiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	syncModeAuto = "auto"
+	syncModePush = "push"
+	syncModePoll = "poll"
+)
+
+// pollTick is how often the poller checks for calendars that are due. It only
+// bounds the scheduling precision; each calendar keeps its own interval.
+const pollTick = 5 * time.Second
+
+type pollState struct {
+	interval time.Duration
+	next     time.Time
+	queued   bool
+}
+
+// record adapts the calendar's interval to its activity: any change drops it
+// back to the minimum, an idle run doubles it up to the maximum.
+func (s *pollState) record(changes int, now time.Time) {
+	if changes > 0 {
+		s.interval = config.PollMinInterval
+	} else {
+		s.interval *= 2
+		if s.interval > config.PollMaxInterval {
+			s.interval = config.PollMaxInterval
+		}
+	}
+	s.next = now.Add(s.interval)
+}
+
+// effectiveSyncMode resolves the calendar's sync mode, falling back to the
+// global WATCHER_SYNC_MODE.
+func effectiveSyncMode(cal watchedCalendar) string {
+	if cal.SyncMode != "" {
+		return cal.SyncMode
+	}
+	return config.SyncMode
+}
+
+// shouldPoll reports whether the calendar is synced by polling. In auto mode
+// a calendar is polled until it has a push channel that has not expired yet.
+func shouldPoll(cal watchedCalendar, now time.Time) bool {
+	switch effectiveSyncMode(cal) {
+	case syncModePoll:
+		return true
+	case syncModePush:
+		return false
+	default:
+		return cal.WebhookExpiry <= now.Unix()
+	}
+}
+
+type poller struct {
+	mu     sync.Mutex
+	states map[string]*pollState
+}
+
+var calendarPoller = &poller{states: make(map[string]*pollState)}
+
+func runPoller() {
+	ticker := time.NewTicker(pollTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		calendarPoller.pollDue()
+	}
+}
+
+func (p *poller) pollDue() {
+	calendars, err := listCalendarsByProvider("google")
+	if err != nil {
+		log.Printf("Poller failed to list calendars: %v", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	polled := make(map[string]bool, len(calendars))
+
+	for _, cal := range calendars {
+		if !shouldPoll(cal, now) {
+			continue
+		}
+		polled[cal.ID] = true
+
+		state, ok := p.states[cal.ID]
+		if !ok {
+			state = &pollState{interval: config.PollMinInterval, next: now}
+			p.states[cal.ID] = state
+		}
+		if state.queued || now.Before(state.next) {
+			continue
+		}
+
+		state.queued = true
+		if !queue.enqueue(&syncJob{calendarID: cal.ID, source: "poll", done: p.done(state)}) {
+			log.Printf("Sync queue full, skipping poll of calendar %s", cal.ID)
+			state.queued = false
+		}
+	}
+
+	for id := range p.states {
+		if !polled[id] {
+			delete(p.states, id)
+		}
+	}
+}
+
+func (p *poller) done(state *pollState) func(int, error) {
+	return func(changes int, err error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		state.queued = false
+		state.record(changes, time.Now())
+	}
+}
+
+// isPolled reports whether the poller currently schedules the calendar.
+func (p *poller) isPolled(calendarID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.states[calendarID]
+	return ok
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type syncJob struct {
+	calendarID string
+	source     string
+	full       bool
+	done       func(changes int, err error)
+}
+
+// syncQueue hands calendar syncs to a fixed pool of workers. A calendar is in
+// the queue at most once: a sync reads the latest token when it starts, so a
+// job that hasn't started yet already covers any notification arriving after
+// it was queued.
+type syncQueue struct {
+	jobs     chan *syncJob
+	mu       sync.Mutex
+	pending  map[string]*syncJob
+	inFlight int
+}
+
+var queue *syncQueue
+
+func newSyncQueue(capacity int) *syncQueue {
+	return &syncQueue{
+		jobs:    make(chan *syncJob, capacity),
+		pending: make(map[string]*syncJob),
+	}
+}
+
+// enqueue adds a job unless one for the same calendar is already waiting, in
+// which case the new job is merged into it: the waiting job becomes a full
+// sync if either asked for one and reports its result to both callbacks. It
+// returns false when the queue is full.
+func (q *syncQueue) enqueue(job *syncJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if existing, ok := q.pending[job.calendarID]; ok {
+		existing.full = existing.full || job.full
+		if job.done != nil {
+			prev := existing.done
+			existing.done = func(changes int, err error) {
+				if prev != nil {
+					prev(changes, err)
+				}
+				job.done(changes, err)
+			}
+		}
+		return true
+	}
+
+	select {
+	case q.jobs <- job:
+		q.pending[job.calendarID] = job
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *syncQueue) depth() int {
+	return len(q.jobs)
+}
+
+func (q *syncQueue) capacity() int {
+	return cap(q.jobs)
+}
+
+func (q *syncQueue) running() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight
+}
+
+// wait blocks until no job is queued or running.
+func (q *syncQueue) wait() {
+	for {
+		q.mu.Lock()
+		idle := len(q.pending) == 0 && q.inFlight == 0
+		q.mu.Unlock()
+
+		if idle {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (q *syncQueue) start(workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+}
+
+func (q *syncQueue) work() {
+	for job := range q.jobs {
+		q.mu.Lock()
+		delete(q.pending, job.calendarID)
+		full := job.full
+		q.inFlight++
+		q.mu.Unlock()
+
+		started := time.Now()
+		count, err := syncCalendar(context.Background(), job.calendarID, full)
+		stats.recordSync(job.calendarID, started, count, err)
+
+		if err != nil {
+			log.Printf("Sync of calendar %s (%s) failed: %v", job.calendarID, job.source, err)
+		} else if count > 0 {
+			log.Printf("Forwarded %d change(s) for calendar %s (%s)", count, job.calendarID, job.source)
+		}
+
+		if job.done != nil {
+			job.done(count, err)
+		}
+
+		q.mu.Lock()
+		q.inFlight--
+		q.mu.Unlock()
+	}
+}
+
+type calendarStatus struct {
+	LastNotification    time.Time
+	LastSync            time.Time
+	LastChanges         int
+	LastError           string
+	ConsecutiveFailures int
+}
+
+// watcherStats keeps in-memory counters for the admin API. They reset when
+// the watcher restarts.
+type watcherStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	syncs     int64
+	failures  int64
+	calendars map[string]*calendarStatus
+}
+
+var stats = &watcherStats{
+	started:   time.Now(),
+	calendars: make(map[string]*calendarStatus),
+}
+
+func (s *watcherStats) calendar(id string) *calendarStatus {
+	status, ok := s.calendars[id]
+	if !ok {
+		status = &calendarStatus{}
+		s.calendars[id] = status
+	}
+	return status
+}
+
+func (s *watcherStats) recordNotification(calendarID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calendar(calendarID).LastNotification = time.Now()
+}
+
+func (s *watcherStats) recordSync(calendarID string, at time.Time, changes int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.calendar(calendarID)
+	status.LastSync = at
+	status.LastChanges = changes
+	s.syncs++
+
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+		s.failures++
+		return
+	}
+
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+}
+
+func (s *watcherStats) snapshot(calendarID string) calendarStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status, ok := s.calendars[calendarID]; ok {
+		return *status
+	}
+	return calendarStatus{}
+}
+
+// totals returns the number of syncs and failures since start and how many
+// calendars failed their most recent sync.
+func (s *watcherStats) totals() (syncs, failures int64, failing int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, status := range s.calendars {
+		if status.ConsecutiveFailures > 0 {
+			failing++
+		}
+	}
+	return s.syncs, s.failures, failing
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"shared/ratelimit"
+)
+
+// Calls to Google and Graph are paced per connected account and counted in
+// provider_quota under the same names as the sync backend's. The buckets are
+// this process's own; the backend keeps separate ones for its calls.
+var (
+	googleLimiter *ratelimit.Limiter
+	graphLimiter  *ratelimit.Limiter
+)
+
+func initLimiters() {
+	limits := ratelimit.Config{
+		Rate:       config.ProviderRateLimit,
+		Burst:      config.ProviderRateBurst,
+		MaxRetries: 3,
+		MaxWait:    config.ProviderMaxRetryWait,
+	}
+	googleLimiter = ratelimit.NewLimiter("google", limits)
+	graphLimiter = ratelimit.NewLimiter("outlook", limits)
+}
+
+// Google reports exhausted quotas as 403 with one of these reasons, besides
+// plain 429s.
+var googleRateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// googleRateLimited turns Google's quota errors into
+// ratelimit.RateLimitError, so the limiter backs off and retries them.
+func googleRateLimited(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	limited := apiErr.Code == http.StatusTooManyRequests
+	if apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			if googleRateLimitReasons[item.Reason] {
+				limited = true
+			}
+		}
+	}
+	if !limited {
+		return err
+	}
+
+	return &ratelimit.RateLimitError{RetryAfter: ratelimit.ParseRetryAfter(apiErr.Header.Get("Retry-After")), Err: err}
+}
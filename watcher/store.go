@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"shared/database"
+)
+
+type watchedCalendar struct {
+	ID                 string
+	UserID             string
+	Provider           string
+	ProviderCalendarID string
+	ChannelID          string
+	ResourceID         string
+	WebhookToken       string
+	WebhookExpiry      int64
+	SyncMode           string
+	SyncToken          string
+	AccountID          string
+	AccessToken        string
+	RefreshToken       string
+	TokenExpiry        int64
+	// NeedsReauth is set while the account's grant is revoked. Its calendars
+	// aren't synced, polled or renewed until the user reconnects it.
+	NeedsReauth bool
+}
+
+const watchedCalendarQuery = `
+	SELECT c.id, c.user_id, c.provider, c.provider_calendar_id, c.webhook_channel_id,
+	       c.webhook_resource_id, c.webhook_token, c.webhook_expiry, c.sync_mode, c.sync_token,
+	       a.id, a.access_token, a.refresh_token, a.token_expiry, a.token_key_id, a.needs_reauth
+	FROM calendars c
+	JOIN connected_accounts a ON a.id = c.connected_account_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWatchedCalendar(row rowScanner) (*watchedCalendar, error) {
+	var cal watchedCalendar
+	var channelID, resourceID, webhookToken, syncMode, syncToken, refreshToken, tokenKeyID sql.NullString
+	var webhookExpiry, tokenExpiry sql.NullInt64
+
+	err := row.Scan(
+		&cal.ID, &cal.UserID, &cal.Provider, &cal.ProviderCalendarID, &channelID,
+		&resourceID, &webhookToken, &webhookExpiry, &syncMode, &syncToken,
+		&cal.AccountID, &cal.AccessToken, &refreshToken, &tokenExpiry, &tokenKeyID,
+		&cal.NeedsReauth,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cal.ChannelID = channelID.String
+	cal.ResourceID = resourceID.String
+	cal.WebhookToken = webhookToken.String
+	cal.WebhookExpiry = webhookExpiry.Int64
+	cal.SyncMode = syncMode.String
+	cal.SyncToken = syncToken.String
+	cal.RefreshToken = refreshToken.String
+	cal.TokenExpiry = tokenExpiry.Int64
+
+	if err := database.OpenTokens(tokenKeyID, &cal.AccessToken, &cal.RefreshToken); err != nil {
+		return nil, err
+	}
+
+	return &cal, nil
+}
+
+func getCalendarByResourceId(resourceID string) (*watchedCalendar, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	cal, err := scanWatchedCalendar(db.QueryRow(watchedCalendarQuery+`
+		WHERE c.webhook_resource_id = ? AND c.provider = 'google' AND c.is_active = 1
+	`, resourceID))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get calendar by resource id: %w", err)
+	}
+
+	return cal, nil
+}
+
+func getCalendarById(id string) (*watchedCalendar, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	cal, err := scanWatchedCalendar(db.QueryRow(watchedCalendarQuery+`
+		WHERE c.id = ?
+	`, id))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get calendar %s: %w", id, err)
+	}
+
+	return cal, nil
+}
+
+func getOutlookCalendarBySubscriptionId(subscriptionID string) (*watchedCalendar, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	cal, err := scanWatchedCalendar(db.QueryRow(watchedCalendarQuery+`
+		WHERE c.webhook_channel_id = ? AND c.provider = 'outlook' AND c.is_active = 1
+	`, subscriptionID))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get calendar by subscription id: %w", err)
+	}
+
+	return cal, nil
+}
+
+func listCalendarsByProvider(provider string) ([]watchedCalendar, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(watchedCalendarQuery+`
+		WHERE c.provider = ? AND c.is_active = 1 AND a.needs_reauth = 0
+	`, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s calendars: %w", provider, err)
+	}
+	defer rows.Close()
+
+	var calendars []watchedCalendar
+	for rows.Next() {
+		cal, err := scanWatchedCalendar(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan calendar: %w", err)
+		}
+		calendars = append(calendars, *cal)
+	}
+
+	return calendars, rows.Err()
+}
+
+func listWatchedCalendars() ([]watchedCalendar, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(watchedCalendarQuery + `
+		WHERE c.is_active = 1
+		ORDER BY c.provider, c.user_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendars: %w", err)
+	}
+	defer rows.Close()
+
+	var calendars []watchedCalendar
+	for rows.Next() {
+		cal, err := scanWatchedCalendar(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan calendar: %w", err)
+		}
+		calendars = append(calendars, *cal)
+	}
+
+	return calendars, rows.Err()
+}
+
+// updateCalendarWebhook stores the push channel (Google) or subscription
+// (Graph) of a calendar. The expiry is in Unix seconds.
+func updateCalendarWebhook(calendarID, resourceID, channelID, token string, expiry int64) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE calendars
+		SET webhook_resource_id = ?, webhook_channel_id = ?, webhook_token = ?, webhook_expiry = ?, updated_at = ?
+		WHERE id = ?
+	`, resourceID, channelID, token, expiry, time.Now().Unix(), calendarID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update calendar webhook: %w", err)
+	}
+
+	return nil
+}
+
+func updateSyncToken(calendarID, syncToken string) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	var tokenVal interface{} = nil
+	if syncToken != "" {
+		tokenVal = syncToken
+	}
+
+	_, err = db.Exec(`
+		UPDATE calendars
+		SET sync_token = ?, updated_at = ?
+		WHERE id = ?
+	`, tokenVal, time.Now().Unix(), calendarID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update sync token: %w", err)
+	}
+
+	return nil
+}
+
+// quotaUsage is what the sync backend recorded of one account's calls to a
+// provider, summed over a span of hours.
+type quotaUsage struct {
+	Provider  string
+	AccountID string
+	UserID    string
+	Email     string
+	Calls     int64
+	Throttled int64
+	WaitMs    int64
+}
+
+// listQuotaUsage sums the provider_quota counts since the given time per
+// provider and account, busiest first. Accounts that have been removed keep
+// their counts but lose their user.
+func listQuotaUsage(since int64) ([]quotaUsage, error) {
+	db, err := database.GetDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT q.provider, q.account_id, COALESCE(a.user_id, ''), COALESCE(u.email, ''),
+		       SUM(q.calls), SUM(q.throttled), SUM(q.wait_ms)
+		FROM provider_quota q
+		LEFT JOIN connected_accounts a ON a.id = q.account_id
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE q.hour >= ?
+		GROUP BY q.provider, q.account_id
+		ORDER BY SUM(q.calls) DESC
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quota usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []quotaUsage
+	for rows.Next() {
+		var u quotaUsage
+		if err := rows.Scan(&u.Provider, &u.AccountID, &u.UserID, &u.Email, &u.Calls, &u.Throttled, &u.WaitMs); err != nil {
+			return nil, fmt.Errorf("failed to scan quota usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
+}
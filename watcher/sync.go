@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+type eventChange struct {
+	ID                 string          `json:"id,omitempty"`
+	Provider           string          `json:"provider"`
+	CalendarID         string          `json:"calendar_id"`
+	ProviderCalendarID string          `json:"provider_calendar_id"`
+	Status             string          `json:"status"`
+	Summary            string          `json:"summary"`
+	Description        string          `json:"description,omitempty"`
+	Location           string          `json:"location,omitempty"`
+	Attendees          []string        `json:"attendees,omitempty"`
+	Start              string          `json:"start,omitempty"`
+	End                string          `json:"end,omitempty"`
+	Updated            string          `json:"updated"`
+	Etag               string          `json:"etag,omitempty"`
+	RawData            json.RawMessage `json:"raw_data,omitempty"`
+}
+
+// calendarListing is every event of a calendar. The backend replaces the
+// calendar's events with it, removing the ones no longer listed.
+type calendarListing struct {
+	ProviderCalendarID string        `json:"provider_calendar_id"`
+	Events             []eventChange `json:"events"`
+}
+
+var calendarLocks sync.Map
+
+// lockCalendar serializes syncs of a single calendar. Google may deliver
+// several notifications for one change in quick succession, and two runs
+// reading the same sync token would forward the same events twice.
+func lockCalendar(id string) func() {
+	v, _ := calendarLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// syncCalendar forwards every event created, updated or cancelled since the
+// calendar's stored sync token and then advances the token. The token is only
+// saved once all changes were accepted by the backend, so a failed run is
+// retried in full on the next notification rather than losing events.
+//
+// Without a usable sync token, on the first run, when the token expired or
+// when a full sync is asked for, the calendar is listed in full and sent to
+// the backend in one batch, which drops events missing from the listing.
+func syncCalendar(ctx context.Context, calendarID string, full bool) (int, error) {
+	unlock := lockCalendar(calendarID)
+	defer unlock()
+
+	cal, err := getCalendarById(calendarID)
+	if err != nil {
+		return 0, err
+	}
+	if cal == nil {
+		return 0, fmt.Errorf("calendar %s not found", calendarID)
+	}
+	if cal.NeedsReauth {
+		return 0, errNeedsReauth
+	}
+
+	srv, err := newCalendarClient(ctx, cal)
+	if err != nil {
+		return 0, err
+	}
+
+	syncToken := cal.SyncToken
+	if full {
+		syncToken = ""
+	}
+
+	changes, nextToken, err := listChanges(ctx, srv, cal, syncToken)
+	if syncToken != "" && isSyncTokenExpired(err) {
+		// Google invalidated the token, so there is no way to tell what
+		// changed. Send the full listing and let the backend reconcile.
+		log.Printf("Sync token expired for calendar %s, running full sync", cal.ID)
+		syncToken = ""
+		changes, nextToken, err = listChanges(ctx, srv, cal, "")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if syncToken == "" {
+		listing := calendarListing{ProviderCalendarID: cal.ProviderCalendarID, Events: make([]eventChange, 0, len(changes))}
+		for _, event := range changes {
+			listing.Events = append(listing.Events, newEventChange(cal, event))
+		}
+		log.Printf("Sending full listing of calendar %s (%d events)", cal.ID, len(changes))
+		if err := postToBackend("/calendar/"+url.PathEscape(cal.ID)+"/events", listing); err != nil {
+			return 0, fmt.Errorf("failed to send listing of calendar %s: %w", cal.ID, err)
+		}
+	} else {
+		for _, event := range changes {
+			log.Printf("Triggering /event/%s (%s, %s)", event.Id, event.Status, event.Summary)
+			if err := postToBackend("/event/"+url.PathEscape(event.Id), newEventChange(cal, event)); err != nil {
+				return 0, fmt.Errorf("failed to forward event %s: %w", event.Id, err)
+			}
+		}
+	}
+
+	if err := updateSyncToken(cal.ID, nextToken); err != nil {
+		return len(changes), err
+	}
+
+	return len(changes), nil
+}
+
+func newCalendarClient(ctx context.Context, cal *watchedCalendar) (*calendar.Service, error) {
+	client := oauth2.NewClient(ctx, accountTokenSource(ctx, oauthConf, cal))
+
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("calendar client error: %w", err)
+	}
+
+	return srv, nil
+}
+
+// listChanges pages through the events list and returns the collected events
+// together with the sync token for the next incremental run. Without a sync
+// token the whole calendar is listed.
+func listChanges(ctx context.Context, srv *calendar.Service, cal *watchedCalendar, syncToken string) ([]*calendar.Event, string, error) {
+	var items []*calendar.Event
+	var nextSyncToken string
+
+	err := googleLimiter.Do(ctx, cal.AccountID, func() error {
+		call := srv.Events.List(cal.ProviderCalendarID).
+			ShowDeleted(true).
+			SingleEvents(true)
+
+		if syncToken != "" {
+			call = call.SyncToken(syncToken)
+		}
+
+		items, nextSyncToken = nil, ""
+		return googleRateLimited(call.Pages(ctx, func(events *calendar.Events) error {
+			items = append(items, events.Items...)
+			if events.NextSyncToken != "" {
+				nextSyncToken = events.NextSyncToken
+			}
+			return nil
+		}))
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list events: %w", err)
+	}
+
+	return items, nextSyncToken, nil
+}
+
+func isSyncTokenExpired(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusGone
+}
+
+func newEventChange(cal *watchedCalendar, event *calendar.Event) eventChange {
+	change := eventChange{
+		ID:                 event.Id,
+		Provider:           cal.Provider,
+		CalendarID:         cal.ID,
+		ProviderCalendarID: cal.ProviderCalendarID,
+		Status:             event.Status,
+		Summary:            event.Summary,
+		Description:        event.Description,
+		Location:           event.Location,
+		Updated:            event.Updated,
+		Etag:               event.Etag,
+	}
+
+	for _, attendee := range event.Attendees {
+		change.Attendees = append(change.Attendees, attendee.Email)
+	}
+	if data, err := json.Marshal(event); err == nil {
+		change.RawData = data
+	}
+
+	if event.Start != nil {
+		change.Start = eventTime(event.Start)
+	}
+	if event.End != nil {
+		change.End = eventTime(event.End)
+	}
+
+	return change
+}
+
+func eventTime(t *calendar.EventDateTime) string {
+	if t.DateTime != "" {
+		return t.DateTime
+	}
+	return t.Date
+}
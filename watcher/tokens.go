@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/oauth2"
+	"shared/oauthtoken"
+)
+
+// errNeedsReauth is returned for calendars whose account's grant was
+// revoked, until the user reconnects the account.
+var errNeedsReauth = errors.New("account needs to be reconnected")
+
+// accountTokenSource returns a token source for the calendar's account that
+// writes refreshed tokens back to connected_accounts, so the sync backend
+// and later runs reuse them.
+func accountTokenSource(ctx context.Context, conf *oauth2.Config, cal *watchedCalendar) oauth2.TokenSource {
+	token := &oauth2.Token{
+		AccessToken:  cal.AccessToken,
+		RefreshToken: cal.RefreshToken,
+		TokenType:    "Bearer",
+	}
+	if cal.TokenExpiry != 0 {
+		token.Expiry = time.Unix(cal.TokenExpiry, 0)
+	}
+	return oauthtoken.Source(ctx, conf, cal.AccountID, token)
+}